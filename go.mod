@@ -6,6 +6,7 @@ toolchain go1.24.1
 
 require (
 	github.com/glebarez/sqlite v1.11.0
+	github.com/miekg/dns v1.1.62
 	github.com/naoina/toml v0.1.1
 	github.com/unpoller/unifi v0.3.15
 	github.com/withmandala/go-log v0.1.0
@@ -21,6 +22,9 @@ require (
 	github.com/jinzhu/now v1.1.5 // indirect
 	github.com/mattn/go-isatty v0.0.17 // indirect
 	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	golang.org/x/mod v0.18.0 // indirect
+	golang.org/x/sync v0.7.0 // indirect
+	golang.org/x/tools v0.22.0 // indirect
 	modernc.org/libc v1.22.5 // indirect
 	modernc.org/mathutil v1.5.0 // indirect
 	modernc.org/memory v1.5.0 // indirect
@@ -2,6 +2,7 @@ package main
 
 import (
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -34,28 +35,13 @@ func TestIntegrationWorkflow(t *testing.T) {
 	config := &scraper.TomlConfig{
 		Daemonize: false,
 		Sleep:     60,
-		MaxAge:    3600,
-		Unifi: struct {
-			Host     string
-			User     string
-			Password string
-		}{
+		MaxAge:    scraper.Duration{Duration: time.Hour},
+		Unifi: scraper.UnifiControllers{{
 			Host:     "https://localhost:8443",
 			User:     "test",
 			Password: "test",
-		},
-		Processing: struct {
-			Domains    []string
-			Additional []struct {
-				IP           string
-				Hostnames    []string
-				Name         string
-				KeepMultiple *bool
-			}
-			Blocked  []struct{ IP, Name string }
-			Cnames   []struct{ Cname, Hostname string }
-			KeepMacs bool
-		}{
+		}},
+		Processing: scraper.ProcessingConfig{
 			Domains: []string{"test.local", "example.com"},
 			Additional: []struct {
 				IP           string
@@ -106,9 +92,12 @@ func TestIntegrationWorkflow(t *testing.T) {
 		t.Fatalf("SaveHostsFile failed: %v", err)
 	}
 
-	// Verify the hosts file was created
-	if _, err := os.Stat(hostsFilePath); os.IsNotExist(err) {
+	// Verify the hosts file was created with the hosts that were just seen
+	contents, err := os.ReadFile(hostsFilePath)
+	if os.IsNotExist(err) {
 		t.Errorf("Hosts file was not created at %s", hostsFilePath)
+	} else if !strings.Contains(string(contents), "192.168.1.100 laptop.test.local laptop.example.com\n") {
+		t.Errorf("Expected laptop in hosts file, got:\n%s", contents)
 	}
 
 	// 3. Save to database
@@ -47,6 +47,9 @@ func main() {
 
 	var hostmaps = []*scraper.Hostmap{}
 	var db *gorm.DB
+	var outputs []scraper.Output
+	var health *scraper.Health
+	var secretsErr error
 	var err error
 
 	loop_count := 0
@@ -60,12 +63,24 @@ func main() {
 				panic(err)
 			}
 			defer f.Close()
-			if err := toml.NewDecoder(f).Decode(&config); err != nil {
+			// start from scratch, so blocks removed from the file go away
+			var loaded scraper.TomlConfig
+			if err := toml.NewDecoder(f).Decode(&loaded); err != nil {
 				panic(err)
 			}
 
 			// Update config from environment variables (environment variables will override TOML values)
-			scraper.UpdateConfigFromEnv(&config)
+			scraper.UpdateConfigFromEnv(&loaded)
+
+			// read the secrets again every time, so they can be rotated. Once
+			// the daemon is running, a secret that can't be read counts as a
+			// failed scrape and the last configuration is kept.
+			secretsErr = scraper.ResolveSecrets(&loaded)
+			if secretsErr == nil {
+				config = loaded
+			} else if loop_count == 1 || !config.Daemonize {
+				globalLogger.Fatalf("Fatal error reading secrets: %s", secretsErr)
+			}
 		} else {
 			globalLogger.Fatal("Must specify configuration file with -config FILENAME")
 		}
@@ -86,27 +101,81 @@ func main() {
 			globalLogger.Infof("Database connection opened driver=%s", config.Database.Driver)
 		}
 
-		hostmaps, err = scraper.GenerateHostsFile(&config, hostmaps)
-		if err != nil {
+		if loop_count == 1 {
+			// serve the health state from the start, so orchestrators can
+			// tell that the scraper is still starting
+			health = scraper.NewHealth(&config.Health)
+			if config.Daemonize && config.Health.Listen != "" {
+				if err := health.Start(config.Health.Listen); err != nil {
+					globalLogger.Fatalf("Fatal error starting health endpoints: %s", err)
+				}
+				defer health.Close()
+			}
+
+			// pick up the hostmap from before the last restart
+			hostmaps, err = scraper.LoadState(&config, db)
+			if err != nil {
+				globalLogger.Errorf("Error loading saved state: %s", err)
+				hostmaps = []*scraper.Hostmap{}
+			}
+		}
+
+		// a failed scrape leaves the last known hosts in place, so in daemon
+		// mode the outputs keep serving them until the controllers are back
+		if secretsErr != nil {
+			err = fmt.Errorf("error reading secrets, not scraping with the last configuration: %w", secretsErr)
+		} else {
+			hostmaps, err = scraper.GenerateHostsFile(&config, hostmaps)
+		}
+		if err != nil && !config.Daemonize {
 			globalLogger.Fatalf("Fatal error generating hosts file: %s", err)
 		}
+		if err != nil && scraper.IsBackoff(err) {
+			// no controller was tried, so this isn't another failure
+			globalLogger.Warnf("Keeping the last known hosts: %s", err)
+		} else if err != nil {
+			state := health.Failure(err, len(hostmaps))
+			if state == scraper.HealthUnhealthy && config.Health.ExitWhenUnhealthy() {
+				globalLogger.Fatalf("Giving up after %d failed scrapes in a row: %s", health.Failures(), err)
+			}
+			globalLogger.Errorf("%d scrapes in a row failed, keeping the last known hosts: %s", health.Failures(), err)
+		} else {
+			health.Success(len(hostmaps))
+		}
 
-		if config.Hostsfile != (scraper.HostsfileConfig{}) {
-			scraper.SaveHostsFile(hostmaps, &config)
+		if err := scraper.SaveState(hostmaps, &config, db); err != nil {
+			globalLogger.Errorf("Error saving state: %s", err)
 		}
 
-		if config.Database != (scraper.DatabaseConfig{}) {
-			scraper.SaveDatabase(db, hostmaps, &config)
+		// set up the outputs the first time through the loop, after that
+		// they only change along with the configuration
+		if loop_count == 1 {
+			outputs, err = scraper.NewOutputs(&config, db)
+			if err != nil {
+				globalLogger.Fatalf("Fatal error setting up outputs: %s", err)
+			}
+			defer func() { scraper.CloseOutputs(outputs) }()
+		} else {
+			outputs, err = scraper.ReloadOutputs(outputs, &config, db)
+			if err != nil {
+				globalLogger.Errorf("Error setting up outputs, keeping the current ones: %s", err)
+			}
 		}
 
+		// each output logs its own errors, so a broken output doesn't stop
+		// the others or the loop
+		scraper.SaveOutputs(outputs, hostmaps, &config)
+
 		if config.Daemonize {
 			sleep_dur := config.Sleep
 			if sleep_dur == 0 {
 				sleep_dur = 120
 			}
-			globalLogger.Infof("Sleeping for %d seconds", sleep_dur)
+			// retry sooner after a failure, backing off to the normal sleep
+			delay := health.NextDelay(time.Duration(sleep_dur) * time.Second)
+			globalLogger.Infof("Sleeping for %s", delay)
 			globalLogger.Infof("** Ending loop %d **", loop_count)
-			time.Sleep(time.Duration(sleep_dur) * time.Second)
+			time.Sleep(delay)
 		} else {
 			break
 		}
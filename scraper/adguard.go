@@ -0,0 +1,131 @@
+package scraper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// adguardSetting is the name the rewrites are remembered under in the pushed
+// entries
+const adguardSetting = "rewrites"
+
+// adguardRewrite is a single DNS rewrite in AdGuard Home. The answer is an
+// address for A and AAAA records or a name for CNAMEs.
+type adguardRewrite struct {
+	Domain string `json:"domain"`
+	Answer string `json:"answer"`
+}
+
+// adguardClient talks to the API of AdGuard Home
+type adguardClient struct {
+	base     string
+	user     string
+	password string
+	client   *http.Client
+}
+
+// SyncAdGuard makes the DNS rewrites of AdGuard Home match the hostmap. Only
+// the rewrites in pushed are ever removed, and the rewrites that are added or
+// removed are recorded in it.
+func SyncAdGuard(hostmaps []*Hostmap, cfg *TomlConfig, pushed *pushedEntries) error {
+	if err := pushed.load(cfg); err != nil {
+		return fmt.Errorf("unable to load the AdGuard Home rewrites of the saved state: %w", err)
+	}
+
+	c := &adguardClient{
+		base:     strings.TrimSuffix(cfg.AdGuard.URL, "/"),
+		user:     cfg.AdGuard.User,
+		password: cfg.AdGuard.Password,
+		client:   &http.Client{Timeout: apiTimeout},
+	}
+
+	var rewrites []adguardRewrite
+	if err := c.do(http.MethodGet, "/control/rewrite/list", nil, &rewrites); err != nil {
+		return err
+	}
+
+	// the rewrites are compared as "domain answer" strings so they can share
+	// the logic with Pi-hole
+	var current, wanted []string
+	for _, rewrite := range rewrites {
+		current = append(current, rewrite.Domain+" "+rewrite.Answer)
+	}
+	for _, record := range collectRecords(hostmaps, cfg, false) {
+		wanted = append(wanted, record.Name+" "+record.Content)
+	}
+
+	pushed.forgetMissing(adguardSetting, current)
+	add, remove := syncEntries(current, wanted, func(entry string) bool {
+		return pushed.owned(adguardSetting, entry)
+	})
+	err := c.apply(add, remove, pushed)
+	if saveErr := pushed.save(cfg); saveErr != nil {
+		logger.Errorf("Error saving the AdGuard Home rewrites to the state: %s", saveErr)
+	}
+	if err != nil {
+		return err
+	}
+	logger.Infof("Synced AdGuard Home rewrites: %d added, %d removed", len(add), len(remove))
+
+	return nil
+}
+
+// apply removes and adds rewrites and records each change that worked in
+// pushed
+func (c *adguardClient) apply(add []string, remove []string, pushed *pushedEntries) error {
+	for _, entry := range remove {
+		fields := strings.Fields(entry)
+		if err := c.do(http.MethodPost, "/control/rewrite/delete", adguardRewrite{fields[0], fields[1]}, nil); err != nil {
+			return err
+		}
+		pushed.set(adguardSetting, entry, false)
+	}
+	for _, entry := range add {
+		fields := strings.Fields(entry)
+		if err := c.do(http.MethodPost, "/control/rewrite/add", adguardRewrite{fields[0], fields[1]}, nil); err != nil {
+			return err
+		}
+		pushed.set(adguardSetting, entry, true)
+	}
+	return nil
+}
+
+// do sends a request to the API and decodes the JSON response into result
+func (c *adguardClient) do(method string, path string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.base+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.user != "" {
+		req.SetBasicAuth(c.user, c.password)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	if result != nil {
+		return json.NewDecoder(resp.Body).Decode(result)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package scraper
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/withmandala/go-log"
+)
+
+// fakeAdGuard is a stand-in for the rewrite API of AdGuard Home
+type fakeAdGuard struct {
+	mu       sync.Mutex
+	rewrites []adguardRewrite
+}
+
+func (f *fakeAdGuard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if user, password, ok := r.BasicAuth(); !ok || user != "admin" || password != "secret" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var rewrite adguardRewrite
+	switch r.URL.Path {
+	case "/control/rewrite/list":
+		json.NewEncoder(w).Encode(f.rewrites)
+	case "/control/rewrite/add":
+		json.NewDecoder(r.Body).Decode(&rewrite)
+		f.rewrites = append(f.rewrites, rewrite)
+	case "/control/rewrite/delete":
+		json.NewDecoder(r.Body).Decode(&rewrite)
+		var kept []adguardRewrite
+		for _, existing := range f.rewrites {
+			if existing != rewrite {
+				kept = append(kept, existing)
+			}
+		}
+		f.rewrites = kept
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// TestSyncAdGuard tests that only the rewrites the scraper added are removed,
+// even if a rewrite added by hand is in one of the configured domains
+func TestSyncAdGuard(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	fake := &fakeAdGuard{
+		rewrites: []adguardRewrite{
+			{"host1.example.com", "192.168.1.10"},
+			{"host2.example.com", "192.168.1.99"},
+			{"printer.example.com", "192.168.1.20"},
+			{"nas.other.lan", "192.168.1.50"},
+		},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	hostmaps, cfg := apiTestHostmaps()
+	cfg.AdGuard = AdGuardConfig{URL: server.URL, User: "admin", Password: "secret"}
+
+	// the scraper added the old address of host2 before, host1 is already
+	// there because it was added by hand
+	pushed := newPushedEntries("adguard", nil)
+	pushed.set(adguardSetting, "host2.example.com 192.168.1.99", true)
+
+	check := func(want []adguardRewrite) {
+		t.Helper()
+		sort.Slice(fake.rewrites, func(i, j int) bool { return fake.rewrites[i].Domain < fake.rewrites[j].Domain })
+		if !reflect.DeepEqual(fake.rewrites, want) {
+			t.Errorf("Expected rewrites %v, got %v", want, fake.rewrites)
+		}
+	}
+
+	if err := SyncAdGuard(hostmaps, cfg, pushed); err != nil {
+		t.Fatalf("SyncAdGuard failed: %v", err)
+	}
+	check([]adguardRewrite{
+		{"host1.example.com", "192.168.1.10"},
+		{"host2.example.com", "192.168.1.11"},
+		{"nas.other.lan", "192.168.1.50"},
+		{"printer.example.com", "192.168.1.20"},
+		{"www.example.com", "host1.example.com"},
+	})
+
+	// host1 goes away, but its rewrite was added by hand so it stays
+	if err := SyncAdGuard(hostmaps[1:], cfg, pushed); err != nil {
+		t.Fatalf("SyncAdGuard failed: %v", err)
+	}
+	check([]adguardRewrite{
+		{"host1.example.com", "192.168.1.10"},
+		{"host2.example.com", "192.168.1.11"},
+		{"nas.other.lan", "192.168.1.50"},
+		{"printer.example.com", "192.168.1.20"},
+	})
+
+	cfg.AdGuard.Password = "wrong"
+	if err := SyncAdGuard(hostmaps, cfg, pushed); err == nil {
+		t.Errorf("Expected an error with the wrong password")
+	}
+}
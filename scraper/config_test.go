@@ -2,7 +2,11 @@ package scraper
 
 import (
 	"os"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/naoina/toml"
 )
 
 func TestUpdateConfigFromEnv(t *testing.T) {
@@ -16,125 +20,89 @@ func TestUpdateConfigFromEnv(t *testing.T) {
 		{
 			name: "no environment variables",
 			initialConfig: TomlConfig{
-				Unifi: struct {
-					Host     string
-					User     string
-					Password string
-				}{
+				Unifi: UnifiControllers{{
 					Host:     "https://unifi.example.com",
 					User:     "admin",
 					Password: "password",
-				},
+				}},
 			},
 			envVars: map[string]string{},
 			expectedConfig: TomlConfig{
-				Unifi: struct {
-					Host     string
-					User     string
-					Password string
-				}{
+				Unifi: UnifiControllers{{
 					Host:     "https://unifi.example.com",
 					User:     "admin",
 					Password: "password",
-				},
+				}},
 			},
 		},
 		{
 			name: "override user only",
 			initialConfig: TomlConfig{
-				Unifi: struct {
-					Host     string
-					User     string
-					Password string
-				}{
+				Unifi: UnifiControllers{{
 					Host:     "https://unifi.example.com",
 					User:     "admin",
 					Password: "password",
-				},
+				}},
 			},
 			envVars: map[string]string{
 				"SCRAPER_UNIFI_USER": "env_admin",
 			},
 			expectedConfig: TomlConfig{
-				Unifi: struct {
-					Host     string
-					User     string
-					Password string
-				}{
+				Unifi: UnifiControllers{{
 					Host:     "https://unifi.example.com",
 					User:     "env_admin",
 					Password: "password",
-				},
+				}},
 			},
 		},
 		{
 			name: "override host only",
 			initialConfig: TomlConfig{
-				Unifi: struct {
-					Host     string
-					User     string
-					Password string
-				}{
+				Unifi: UnifiControllers{{
 					Host:     "https://unifi.example.com",
 					User:     "admin",
 					Password: "password",
-				},
+				}},
 			},
 			envVars: map[string]string{
 				"SCRAPER_UNIFI_HOST": "https://env.unifi.example.com",
 			},
 			expectedConfig: TomlConfig{
-				Unifi: struct {
-					Host     string
-					User     string
-					Password string
-				}{
+				Unifi: UnifiControllers{{
 					Host:     "https://env.unifi.example.com",
 					User:     "admin",
 					Password: "password",
-				},
+				}},
 			},
 		},
 		{
 			name: "override password only",
 			initialConfig: TomlConfig{
-				Unifi: struct {
-					Host     string
-					User     string
-					Password string
-				}{
+				Unifi: UnifiControllers{{
 					Host:     "https://unifi.example.com",
 					User:     "admin",
 					Password: "password",
-				},
+				}},
 			},
 			envVars: map[string]string{
 				"SCRAPER_UNIFI_PASSWORD": "env_password",
 			},
 			expectedConfig: TomlConfig{
-				Unifi: struct {
-					Host     string
-					User     string
-					Password string
-				}{
+				Unifi: UnifiControllers{{
 					Host:     "https://unifi.example.com",
 					User:     "admin",
 					Password: "env_password",
-				},
+				}},
 			},
 		},
 		{
 			name: "override all values",
 			initialConfig: TomlConfig{
-				Unifi: struct {
-					Host     string
-					User     string
-					Password string
-				}{
+				Unifi: UnifiControllers{{
 					Host:     "https://unifi.example.com",
 					User:     "admin",
 					Password: "password",
-				},
+				}},
 			},
 			envVars: map[string]string{
 				"SCRAPER_UNIFI_USER":     "env_admin",
@@ -142,29 +110,21 @@ func TestUpdateConfigFromEnv(t *testing.T) {
 				"SCRAPER_UNIFI_HOST":     "https://env.unifi.example.com",
 			},
 			expectedConfig: TomlConfig{
-				Unifi: struct {
-					Host     string
-					User     string
-					Password string
-				}{
+				Unifi: UnifiControllers{{
 					Host:     "https://env.unifi.example.com",
 					User:     "env_admin",
 					Password: "env_password",
-				},
+				}},
 			},
 		},
 		{
 			name: "set values only in env, not in config",
 			initialConfig: TomlConfig{
-				Unifi: struct {
-					Host     string
-					User     string
-					Password string
-				}{
+				Unifi: UnifiControllers{{
 					Host:     "",
 					User:     "",
 					Password: "",
-				},
+				}},
 			},
 			envVars: map[string]string{
 				"SCRAPER_UNIFI_USER":     "env_admin",
@@ -172,15 +132,28 @@ func TestUpdateConfigFromEnv(t *testing.T) {
 				"SCRAPER_UNIFI_HOST":     "https://env.unifi.example.com",
 			},
 			expectedConfig: TomlConfig{
-				Unifi: struct {
-					Host     string
-					User     string
-					Password string
-				}{
+				Unifi: UnifiControllers{{
 					Host:     "https://env.unifi.example.com",
 					User:     "env_admin",
 					Password: "env_password",
-				},
+				}},
+			},
+		},
+		{
+			name: "api key from env",
+			initialConfig: TomlConfig{
+				Unifi: UnifiControllers{{
+					Host: "https://unifi.example.com",
+				}},
+			},
+			envVars: map[string]string{
+				"SCRAPER_UNIFI_API_KEY": "env_key",
+			},
+			expectedConfig: TomlConfig{
+				Unifi: UnifiControllers{{
+					Host:   "https://unifi.example.com",
+					APIKey: "env_key",
+				}},
 			},
 		},
 	}
@@ -191,6 +164,7 @@ func TestUpdateConfigFromEnv(t *testing.T) {
 			os.Unsetenv("SCRAPER_UNIFI_USER")
 			os.Unsetenv("SCRAPER_UNIFI_PASSWORD")
 			os.Unsetenv("SCRAPER_UNIFI_HOST")
+			os.Unsetenv("SCRAPER_UNIFI_API_KEY")
 
 			// Set up environment variables for the test
 			for key, value := range tt.envVars {
@@ -202,14 +176,17 @@ func TestUpdateConfigFromEnv(t *testing.T) {
 			UpdateConfigFromEnv(&config)
 
 			// Verify the config was updated correctly
-			if config.Unifi.User != tt.expectedConfig.Unifi.User {
-				t.Errorf("UpdateConfigFromEnv() User = %v, want %v", config.Unifi.User, tt.expectedConfig.Unifi.User)
+			if config.Unifi[0].User != tt.expectedConfig.Unifi[0].User {
+				t.Errorf("UpdateConfigFromEnv() User = %v, want %v", config.Unifi[0].User, tt.expectedConfig.Unifi[0].User)
 			}
-			if config.Unifi.Password != tt.expectedConfig.Unifi.Password {
-				t.Errorf("UpdateConfigFromEnv() Password = %v, want %v", config.Unifi.Password, tt.expectedConfig.Unifi.Password)
+			if config.Unifi[0].Password != tt.expectedConfig.Unifi[0].Password {
+				t.Errorf("UpdateConfigFromEnv() Password = %v, want %v", config.Unifi[0].Password, tt.expectedConfig.Unifi[0].Password)
 			}
-			if config.Unifi.Host != tt.expectedConfig.Unifi.Host {
-				t.Errorf("UpdateConfigFromEnv() Host = %v, want %v", config.Unifi.Host, tt.expectedConfig.Unifi.Host)
+			if config.Unifi[0].Host != tt.expectedConfig.Unifi[0].Host {
+				t.Errorf("UpdateConfigFromEnv() Host = %v, want %v", config.Unifi[0].Host, tt.expectedConfig.Unifi[0].Host)
+			}
+			if config.Unifi[0].APIKey != tt.expectedConfig.Unifi[0].APIKey {
+				t.Errorf("UpdateConfigFromEnv() APIKey = %v, want %v", config.Unifi[0].APIKey, tt.expectedConfig.Unifi[0].APIKey)
 			}
 
 			// Clean up
@@ -219,3 +196,35 @@ func TestUpdateConfigFromEnv(t *testing.T) {
 		})
 	}
 }
+
+func TestDurationFromTOML(t *testing.T) {
+	input := `
+MaxAge = 3600
+
+[expiry]
+clients = "36h"
+switches = "7d"
+grace = 600
+`
+	var cfg TomlConfig
+	if err := toml.NewDecoder(strings.NewReader(input)).Decode(&cfg); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	if cfg.MaxAge.Duration != time.Hour {
+		t.Errorf("MaxAge = %v, want %v", cfg.MaxAge.Duration, time.Hour)
+	}
+	if cfg.Expiry.Clients.Duration != 36*time.Hour {
+		t.Errorf("Expiry.Clients = %v, want %v", cfg.Expiry.Clients.Duration, 36*time.Hour)
+	}
+	if cfg.Expiry.Switches.Duration != 7*24*time.Hour {
+		t.Errorf("Expiry.Switches = %v, want %v", cfg.Expiry.Switches.Duration, 7*24*time.Hour)
+	}
+	if cfg.Expiry.Grace.Duration != 10*time.Minute {
+		t.Errorf("Expiry.Grace = %v, want %v", cfg.Expiry.Grace.Duration, 10*time.Minute)
+	}
+
+	if err := toml.NewDecoder(strings.NewReader(`MaxAge = "soon"`)).Decode(&cfg); err == nil {
+		t.Errorf("Decode() expected an error for an invalid duration")
+	}
+}
@@ -0,0 +1,263 @@
+package scraper
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Policies for resolving conflicts between hosts, set in the [conflicts] block
+const (
+	// PolicyNewest keeps the host that was seen most recently
+	PolicyNewest = "newest"
+	// PolicyStatic keeps hosts from Additional over hosts from the controller
+	PolicyStatic = "static"
+	// PolicyWired keeps wired hosts over wireless ones
+	PolicyWired = "wired"
+	// PolicyKeepBoth keeps both hosts
+	PolicyKeepBoth = "keep_both"
+)
+
+// conflictPolicy checks a policy from the configuration. Anything that
+// isn't a known policy falls back to newest.
+func conflictPolicy(configured string) string {
+	policy := strings.ToLower(strings.TrimSpace(configured))
+	switch policy {
+	case "":
+		return PolicyNewest
+	case PolicyNewest, PolicyStatic, PolicyWired, PolicyKeepBoth:
+		return policy
+	}
+	logger.Warnf("unknown conflict policy %q, using %s", configured, PolicyNewest)
+	return PolicyNewest
+}
+
+// resolveConflicts merges the records of hosts that were seen again and then
+// resolves the hosts that share an IP address and the hosts that share a
+// name using the policies in the [conflicts] block
+func resolveConflicts(m []*Hostmap, cfg *TomlConfig) []*Hostmap {
+	m = mergeSeenAgain(m)
+	m = resolveSameIPConflicts(m, conflictPolicy(cfg.Conflicts.SameIP))
+	m = resolveSameNameConflicts(m, conflictPolicy(cfg.Conflicts.SameName))
+	return m
+}
+
+// hostIdentity is the same for the records of a host from different scrapes.
+// Devices are known by their MAC address and IP address, hosts without a MAC
+// address by where they came from, their IP address and their names.
+func hostIdentity(host *Hostmap) string {
+	if host.mac != "" {
+		return fmt.Sprintf("mac|%s|%s", host.mac, host.ip)
+	}
+	return fmt.Sprintf("%s|%s|%s", host.source, host.ip, strings.ToLower(strings.Join(host.hostnames, ",")))
+}
+
+// describeHost names a host in log messages
+func describeHost(host *Hostmap) string {
+	details := []string{host.ip.String()}
+	if host.mac != "" {
+		details = append(details, host.mac)
+	}
+	if host.controller != "" {
+		details = append(details, "from "+host.controller)
+	}
+	return fmt.Sprintf("%s (%s)", strings.Join(host.hostnames, ","), strings.Join(details, ", "))
+}
+
+// sortForConflicts puts the hosts in a fixed order so that conflicts are
+// resolved the same way no matter what order the controller returned them in
+func sortForConflicts(m []*Hostmap) {
+	sort.SliceStable(m, func(i, j int) bool {
+		if c := m[i].ip.Compare(m[j].ip); c != 0 {
+			return c < 0
+		}
+		if m[i].mac != m[j].mac {
+			return m[i].mac < m[j].mac
+		}
+		if m[i].source != m[j].source {
+			return m[i].source < m[j].source
+		}
+		return strings.Join(m[i].hostnames, ",") < strings.Join(m[j].hostnames, ",")
+	})
+}
+
+// mergeSeenAgain keeps only the newest record of each host. Every scrape
+// adds new records for the hosts it finds, so this is what stops a host from
+// conflicting with itself.
+func mergeSeenAgain(m []*Hostmap) []*Hostmap {
+	newest := make(map[string]*Hostmap)
+	var order []string
+	for _, host := range m {
+		id := hostIdentity(host)
+		existing, ok := newest[id]
+		if !ok {
+			order = append(order, id)
+			newest[id] = host
+			continue
+		}
+		if !host.lastseen.Before(existing.lastseen) {
+			newest[id] = host
+		}
+	}
+
+	newhosts := make([]*Hostmap, 0, len(order))
+	for _, id := range order {
+		newhosts = append(newhosts, newest[id])
+	}
+	sortForConflicts(newhosts)
+	return newhosts
+}
+
+// decideConflict picks which of two conflicting hosts to keep using the
+// policy. It returns nil for the winner when both hosts should be kept,
+// along with the reason for the decision.
+func decideConflict(a, b *Hostmap, policy string) (winner, loser *Hostmap, reason string) {
+	aActive := a.removalCode == NotRemoved
+	bActive := b.removalCode == NotRemoved
+	if aActive != bActive {
+		if aActive {
+			return a, b, "the other host was removed"
+		}
+		return b, a, "the other host was removed"
+	}
+
+	aStatic := a.source == SourceStatic
+	bStatic := b.source == SourceStatic
+	if aStatic && bStatic && a.lastseen.Equal(b.lastseen) {
+		return nil, nil, "both hosts are configured in additional"
+	}
+
+	switch policy {
+	case PolicyKeepBoth:
+		return nil, nil, "policy is keep_both"
+	case PolicyStatic:
+		if aStatic != bStatic {
+			if aStatic {
+				return a, b, "static wins"
+			}
+			return b, a, "static wins"
+		}
+	case PolicyWired:
+		if a.wired != b.wired {
+			if a.wired {
+				return a, b, "wired wins"
+			}
+			return b, a, "wired wins"
+		}
+	}
+
+	if !a.lastseen.Equal(b.lastseen) {
+		if a.lastseen.After(b.lastseen) {
+			return a, b, "newest wins, seen in a later scrape"
+		}
+		return b, a, "newest wins, seen in a later scrape"
+	}
+	if !a.lastseenUnifi.Equal(b.lastseenUnifi) {
+		if a.lastseenUnifi.After(b.lastseenUnifi) {
+			return a, b, fmt.Sprintf("newest wins, last seen by the controller at %s", a.lastseenUnifi.Format("2006-01-02 15:04:05"))
+		}
+		return b, a, fmt.Sprintf("newest wins, last seen by the controller at %s", b.lastseenUnifi.Format("2006-01-02 15:04:05"))
+	}
+	// the hosts are in a fixed order, so the first one wins a tie
+	return a, b, "tie, kept the first host in address order"
+}
+
+// resolveSameIPConflicts handles hosts with different names on the same IP
+// address. Hosts that lose are dropped.
+func resolveSameIPConflicts(m []*Hostmap, policy string) []*Hostmap {
+	dropped := make(map[*Hostmap]bool)
+	for i, a := range m {
+		for _, b := range m[i+1:] {
+			if dropped[a] {
+				break
+			}
+			if dropped[b] || a.ip != b.ip {
+				continue
+			}
+			winner, loser, reason := decideConflict(a, b, policy)
+			if winner == nil {
+				logger.Debugf("Conflict on %s: keeping %s and %s: %s", a.ip, describeHost(a), describeHost(b), reason)
+				continue
+			}
+			logger.Infof("Conflict on %s: keeping %s, dropping %s: %s", a.ip, describeHost(winner), describeHost(loser), reason)
+			dropped[loser] = true
+		}
+	}
+
+	var newhosts []*Hostmap
+	for _, host := range m {
+		if !dropped[host] {
+			newhosts = append(newhosts, host)
+		}
+	}
+	return newhosts
+}
+
+// resolveSameNameConflicts handles active hosts that have the same fully
+// qualified name on different IP addresses. A host that loses only loses that
+// name and is dropped when it has no names left.
+func resolveSameNameConflicts(m []*Hostmap, policy string) []*Hostmap {
+	byName := make(map[string][]*Hostmap)
+	for _, host := range m {
+		if host.removalCode != NotRemoved {
+			continue
+		}
+		for _, fqdn := range host.fqdns {
+			name := strings.ToLower(fqdn)
+			byName[name] = append(byName[name], host)
+		}
+	}
+
+	names := make([]string, 0, len(byName))
+	for name, hosts := range byName {
+		if len(hosts) > 1 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	lost := make(map[*Hostmap]map[string]bool)
+	for _, name := range names {
+		hosts := byName[name]
+		for i, a := range hosts {
+			for _, b := range hosts[i+1:] {
+				if lost[a][name] {
+					break
+				}
+				if lost[b][name] || a.ip == b.ip {
+					continue
+				}
+				winner, loser, reason := decideConflict(a, b, policy)
+				if winner == nil {
+					logger.Debugf("Conflict on %s: keeping %s and %s: %s", name, describeHost(a), describeHost(b), reason)
+					continue
+				}
+				logger.Infof("Conflict on %s: keeping %s, removing the name from %s: %s", name, describeHost(winner), describeHost(loser), reason)
+				if lost[loser] == nil {
+					lost[loser] = make(map[string]bool)
+				}
+				lost[loser][name] = true
+			}
+		}
+	}
+
+	var newhosts []*Hostmap
+	for _, host := range m {
+		if lost[host] == nil {
+			newhosts = append(newhosts, host)
+			continue
+		}
+		var fqdns []string
+		for _, fqdn := range host.fqdns {
+			if !lost[host][strings.ToLower(fqdn)] {
+				fqdns = append(fqdns, fqdn)
+			}
+		}
+		if len(fqdns) == 0 {
+			continue
+		}
+		host.fqdns = fqdns
+		newhosts = append(newhosts, host)
+	}
+	return newhosts
+}
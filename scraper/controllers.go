@@ -0,0 +1,178 @@
+package scraper
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/unpoller/unifi"
+)
+
+// UnifiControllers is the list of controllers to scrape. In the
+// configuration this is either a single [unifi] block or one [[unifi]] block
+// for each controller.
+type UnifiControllers []UnifiConfig
+
+// UnmarshalTOML reads either a table or an array of tables
+func (c *UnifiControllers) UnmarshalTOML(decode func(interface{}) error) error {
+	var controllers []UnifiConfig
+	if err := decode(&controllers); err == nil {
+		*c = controllers
+		return nil
+	}
+
+	var controller UnifiConfig
+	if err := decode(&controller); err != nil {
+		return err
+	}
+	*c = UnifiControllers{controller}
+	return nil
+}
+
+// controllerName identifies a controller in log messages and on the hosts it
+// found. This is the name from the configuration, or else its host.
+func controllerName(controller *UnifiConfig) string {
+	if controller.Name != "" {
+		return controller.Name
+	}
+	return controller.Host
+}
+
+// controllerConfig finds the configuration of the controller a host came
+// from, or nil for hosts that didn't come from a controller
+func controllerConfig(name string, cfg *TomlConfig) *UnifiConfig {
+	for i := range cfg.Unifi {
+		if controllerName(&cfg.Unifi[i]) == name {
+			return &cfg.Unifi[i]
+		}
+	}
+	return nil
+}
+
+// controllerScrape is everything that was read from one controller
+type controllerScrape struct {
+	controller string
+	sites      []*unifi.Site
+	clients    []*unifi.Client
+	devices    *unifi.Devices
+	clientIPv6 map[string][]string
+}
+
+// scrapeController reads the sites, clients and devices of one controller
+func scrapeController(controller *UnifiConfig, cfg *TomlConfig, client UnifiClientInterface) (controllerScrape, error) {
+	name := controllerName(controller)
+	scrape := controllerScrape{controller: name}
+
+	sites, err := client.GetSites()
+	if err != nil {
+		logger.Errorf("Error getting sites: %s", err)
+		logger.Warnf("Not updating list of hosts this round - will try again later")
+		return scrape, err
+	}
+
+	sites, err = filterSites(sites, controller)
+	if err != nil {
+		logger.Errorf("Error selecting sites: %s", err)
+		return scrape, err
+	}
+	scrape.sites = sites
+
+	scrape.clients, err = client.GetClients(sites)
+	if err != nil {
+		logger.Errorf("Error getting clients: %s", err)
+		return scrape, err
+	}
+
+	scrape.devices, err = client.GetDevices(sites)
+	if err != nil {
+		logger.Errorf("Error getting devices: %s", err)
+		return scrape, err
+	}
+
+	prefix := ""
+	if name != "" {
+		prefix = name + ": "
+	}
+	logger.Infof("%s%d Unifi Sites Found", prefix, len(sites))
+	logger.Infof("%s%d Clients connected", prefix, len(scrape.clients))
+	logger.Infof("%s%d Unifi Switches Found", prefix, len(scrape.devices.USWs))
+	logger.Infof("%s%d Unifi Gateways Found", prefix, len(scrape.devices.USGs)+len(scrape.devices.UDMs)+len(scrape.devices.UXGs))
+	logger.Infof("%s%d Unifi Wireless APs Found", prefix, len(scrape.devices.UAPs))
+	logger.Infof("%s%d Unifi PDUs Found", prefix, len(scrape.devices.PDUs))
+
+	// IPv6 addresses are only looked up when some domain wants them. A failure
+	// here is not fatal, the hosts just get their IPv4 addresses this round.
+	if v6client, ok := client.(UnifiIPv6Interface); ok && len(cfg.Processing.IPv6Domains) > 0 {
+		scrape.clientIPv6, err = v6client.GetClientIPv6(sites)
+		if err != nil {
+			logger.Warnf("Error getting client IPv6 addresses: %s", err)
+		}
+	}
+
+	if session, ok := client.(interface{ scrapeSucceeded() }); ok {
+		session.scrapeSucceeded()
+	}
+	return scrape, nil
+}
+
+// generateHostmap scrapes all controllers at the same time, using connect
+// to get the client for each entry of cfg.Unifi, and merges what they found
+// into the hostmap. When some of the controllers fail, the hostmap is still
+// updated with the others and the error says which ones failed.
+func generateHostmap(cfg *TomlConfig, hostmaps []*Hostmap, controllers []UnifiConfig, connect func(i int) (UnifiClientInterface, error)) ([]*Hostmap, error) {
+	logger.Infof("Starting new host file generation")
+	logger.Infof("%d existing hosts in the hostmap", len(hostmaps))
+
+	scrapes := make([]controllerScrape, len(controllers))
+	errs := make([]error, len(controllers))
+
+	var wg sync.WaitGroup
+	for i := range controllers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			client, err := connect(i)
+			if err == nil {
+				scrapes[i], err = scrapeController(&controllers[i], cfg, client)
+			}
+			if err != nil {
+				if name := controllerName(&controllers[i]); name != "" {
+					err = fmt.Errorf("%s: %w", name, err)
+				}
+				errs[i] = err
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var succeeded []controllerScrape
+	for i, scrape := range scrapes {
+		if errs[i] == nil {
+			succeeded = append(succeeded, scrape)
+		}
+	}
+	err := errors.Join(errs...)
+	if len(succeeded) == 0 {
+		logger.Errorf("Error getting Unifi elements: %s", err)
+		return hostmaps, err
+	}
+	if err != nil {
+		logger.Errorf("Error getting Unifi elements, keeping the hosts of the failed controllers: %s", err)
+	}
+
+	return createHostmap(succeeded, cfg, hostmaps), err
+}
+
+// checkControllerNames makes sure that every controller can be told apart
+func checkControllerNames(controllers []UnifiConfig) error {
+	seen := make(map[string]bool)
+	for i := range controllers {
+		name := strings.ToLower(controllerName(&controllers[i]))
+		if seen[name] {
+			return fmt.Errorf("more than one Unifi controller is called %q, give them a name", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package scraper
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/naoina/toml"
+	"github.com/withmandala/go-log"
+)
+
+func TestUnifiControllersFromTOML(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			name:  "single table",
+			input: "[unifi]\nhost = \"https://a.example.com\"\nuser = \"scraper\"\n",
+			want:  []string{"https://a.example.com"},
+		},
+		{
+			name:  "array of tables",
+			input: "[[unifi]]\nname = \"home\"\nhost = \"https://a.example.com\"\n\n[[unifi]]\nname = \"office\"\nhost = \"https://b.example.com\"\ndomains = [\"office.example.com\"]\n",
+			want:  []string{"home", "office"},
+		},
+		{
+			name:  "no controller",
+			input: "Sleep = 60\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg TomlConfig
+			if err := toml.NewDecoder(strings.NewReader(tt.input)).Decode(&cfg); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			var names []string
+			for i := range cfg.Unifi {
+				names = append(names, controllerName(&cfg.Unifi[i]))
+			}
+			if strings.Join(names, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("controllers = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+// TestMultipleControllers tests that the hosts of several controllers end up
+// in one hostmap, each in the domains of its controller, and that the hosts
+// of a controller that fails are kept
+func TestMultipleControllers(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	now := float64(time.Now().Unix())
+	home := NewMockUnifiClient().AddSite("default")
+	home.AddClient("laptop", "192.168.1.10", now)
+	home.AddClient("printer", "192.168.1.20", now)
+	office := NewMockUnifiClient().AddSite("default")
+	office.AddClient("printer", "10.0.0.20", now)
+	office.AddSwitch("core", "10.0.0.2", now)
+
+	cfg := &TomlConfig{
+		Unifi: UnifiControllers{
+			{Name: "home", Host: "https://home.example.com"},
+			{Name: "office", Host: "https://office.example.com", Domains: []string{"office.example.com"}},
+		},
+		Processing: ProcessingConfig{Domains: []string{"home.example.com"}},
+	}
+
+	hostmaps, err := GenerateHostsFileWithClients(cfg, nil, []UnifiClientInterface{home, office})
+	if err != nil {
+		t.Fatalf("GenerateHostsFileWithClients() error = %v", err)
+	}
+
+	want := map[string]string{
+		"192.168.1.10": "home laptop.home.example.com",
+		"192.168.1.20": "home printer.home.example.com",
+		"10.0.0.20":    "office printer.office.example.com",
+		"10.0.0.2":     "office core.office.example.com",
+	}
+	if len(hostmaps) != len(want) {
+		t.Fatalf("Expected %d hosts, got %d", len(want), len(hostmaps))
+	}
+	for _, hm := range hostmaps {
+		got := hm.controller + " " + strings.Join(hm.fqdns, " ")
+		if got != want[hm.ip.String()] {
+			t.Errorf("Host %s got %q, want %q", hm.ip, got, want[hm.ip.String()])
+		}
+	}
+
+	// the office controller goes down, its hosts are carried over
+	office.SetError(errors.New("connection refused"))
+	hostmaps, err = GenerateHostsFileWithClients(cfg, hostmaps, []UnifiClientInterface{home, office})
+	if err == nil || !strings.Contains(err.Error(), "office: connection refused") {
+		t.Errorf("Expected an error naming the office controller, got %v", err)
+	}
+	if len(hostmaps) != len(want) {
+		t.Errorf("Expected the hosts of the failed controller to be kept, got %d hosts", len(hostmaps))
+	}
+
+	cfg.Unifi[1].Name = "HOME"
+	if _, err := GenerateHostsFileWithClients(cfg, nil, []UnifiClientInterface{home, office}); err == nil {
+		t.Errorf("Expected an error for two controllers with the same name")
+	}
+}
@@ -1,11 +1,14 @@
 package scraper
 
 import (
+	"database/sql"
 	"fmt"
+	"net/netip"
 
 	"github.com/pridkett/unifi-dns-scraper/sqlmodel"
 
 	"strings"
+	"time"
 
 	"github.com/glebarez/sqlite" // pure go sqlite driver
 	"gorm.io/driver/mysql"
@@ -33,7 +36,7 @@ func OpenDatabase(driver string, dsn string) (*gorm.DB, error) {
 	}
 
 	// Automatically migrate your schema, create tables if they do not exist
-	err = db.AutoMigrate(&sqlmodel.Domain{}, &sqlmodel.Record{}, &sqlmodel.UnifiHost{})
+	err = db.AutoMigrate(&sqlmodel.Domain{}, &sqlmodel.Record{}, &sqlmodel.UnifiHost{}, &sqlmodel.ScraperState{})
 	if err != nil {
 		return nil, err
 	}
@@ -43,52 +46,78 @@ func OpenDatabase(driver string, dsn string) (*gorm.DB, error) {
 
 func SaveDatabase(db *gorm.DB, hostmaps []*Hostmap, config *TomlConfig) error {
 
-	var records []sqlmodel.Record
-	var updateRecords []sqlmodel.Record
-	var newRecords []sqlmodel.Record
+	mode, err := staleRecordsMode(config)
+	if err != nil {
+		return err
+	}
 
-	// Get all existing A records from the database
-	if err := db.Model(&sqlmodel.Record{}).Where("type = ?", "A").Find(&records).Error; err != nil {
+	serialFormat, err := soaSerialFormat(config.Database.SerialFormat)
+	if err != nil {
 		return err
 	}
+	changes := newZoneChanges(time.Now())
 
-	// Map domain names to their DB records for easy lookup
-	recordMap := make(map[string]sqlmodel.Record)
-	for _, record := range records {
-		recordMap[record.Name] = record
+	// Make sure PowerDNS has a domain for each of the configured domains and
+	// then find the domain that each record belongs to
+	for _, domain := range allDomains(config) {
+		if _, err := ensureDomain(db, strings.ToLower(strings.Trim(domain, ".")), config, changes); err != nil {
+			return err
+		}
 	}
 
-	// First, process host A records
-	for _, hostmap := range hostmaps {
-		for _, host := range hostmap.fqdns {
-			host = strings.TrimSuffix(host, ".")
+	var domains []sqlmodel.Domain
+	if err := db.Find(&domains).Error; err != nil {
+		return err
+	}
 
-			if record, ok := recordMap[host]; ok {
-				if record.Content != hostmap.ip.String() {
-					record.Content = hostmap.ip.String()
-					updateRecords = append(updateRecords, record)
-				}
-			} else {
-				newRecords = append(newRecords, sqlmodel.Record{
-					Name:    host,
-					Type:    "A",
-					Content: hostmap.ip.String(),
-					Ttl:     3600,
-				})
+	if err := adoptLegacyRecords(db, config, domains, changes); err != nil {
+		return err
+	}
+
+	var updateRecords []sqlmodel.Record
+	var newRecords []sqlmodel.Record
+
+	// Every record that should exist after this run, used to find the stale
+	// records that the scraper created in earlier runs
+	wanted := make(map[recordKey]bool)
+
+	// A name can have several addresses, for example when hosts share a name
+	// with the keep_both policy, so records are matched by name and address.
+	// Removed hosts get no records, whatever the stale_records mode.
+	for _, rrtype := range []string{"A", "AAAA"} {
+		var records []sqlmodel.Record
+		if err := db.Model(&sqlmodel.Record{}).Where("type = ?", rrtype).Order("id").Find(&records).Error; err != nil {
+			return err
+		}
+		existing := make(map[string][]sqlmodel.Record)
+		for _, record := range records {
+			existing[record.Name] = append(existing[record.Name], record)
+		}
+
+		names, addresses := addressRecords(hostmaps, config, rrtype)
+		for _, host := range names {
+			updates, inserts, _, ok := syncRecords(host, rrtype, addresses[host], existing[host], domainFor(host, domains))
+			if !ok {
+				continue
 			}
+			for _, address := range addresses[host] {
+				wanted[recordKey{host, rrtype, address}] = true
+			}
+			updateRecords = append(updateRecords, updates...)
+			newRecords = append(newRecords, inserts...)
 		}
 	}
 
 	// Now handle CNAMEs - first we need to get existing CNAME records
 	var cnameRecords []sqlmodel.Record
-	if err := db.Model(&sqlmodel.Record{}).Where("type = ?", "CNAME").Find(&cnameRecords).Error; err != nil {
+	if err := db.Model(&sqlmodel.Record{}).Where("type = ?", "CNAME").Order("id").Find(&cnameRecords).Error; err != nil {
 		return err
 	}
 
 	// Create a map for CNAME lookups
-	cnameMap := make(map[string]sqlmodel.Record)
+	cnameMap := make(map[string][]sqlmodel.Record)
 	for _, record := range cnameRecords {
-		cnameMap[record.Name] = record
+		cnameMap[record.Name] = append(cnameMap[record.Name], record)
 	}
 
 	// Create a map of hostnames for resolving CNAMEs
@@ -105,21 +134,13 @@ func SaveDatabase(db *gorm.DB, hostmaps []*Hostmap, config *TomlConfig) error {
 	for _, cname := range config.Processing.Cnames {
 		// First make sure the target hostname exists somewhere in our data
 		if _, exists := hostnameMap[cname.Hostname]; exists {
-			if record, ok := cnameMap[cname.Cname]; ok {
-				// Update existing CNAME if the target changed
-				if record.Content != cname.Hostname {
-					record.Content = cname.Hostname
-					updateRecords = append(updateRecords, record)
-				}
-			} else {
-				// Create new CNAME record
-				newRecords = append(newRecords, sqlmodel.Record{
-					Name:    cname.Cname,
-					Type:    "CNAME",
-					Content: cname.Hostname,
-					Ttl:     3600,
-				})
+			updates, inserts, _, ok := syncRecords(cname.Cname, "CNAME", []string{cname.Hostname}, cnameMap[cname.Cname], domainFor(cname.Cname, domains))
+			if !ok {
+				continue
 			}
+			wanted[recordKey{cname.Cname, "CNAME", cname.Hostname}] = true
+			updateRecords = append(updateRecords, updates...)
+			newRecords = append(newRecords, inserts...)
 		} else {
 			logger.Warnf("CNAME target '%s' for '%s' not found in hosts, skipping database entry",
 				cname.Hostname, cname.Cname)
@@ -129,6 +150,7 @@ func SaveDatabase(db *gorm.DB, hostmaps []*Hostmap, config *TomlConfig) error {
 	// use GORM to update the records in updateRecords
 	// and insert the records in newRecords
 	if len(updateRecords) > 0 {
+		changes.touch(updateRecords)
 		if err := db.Save(&updateRecords).Error; err != nil {
 			return err
 		}
@@ -138,6 +160,7 @@ func SaveDatabase(db *gorm.DB, hostmaps []*Hostmap, config *TomlConfig) error {
 	}
 
 	if len(newRecords) > 0 {
+		changes.touch(newRecords)
 		if err := db.Create(&newRecords).Error; err != nil {
 			return err
 		}
@@ -146,5 +169,416 @@ func SaveDatabase(db *gorm.DB, hostmaps []*Hostmap, config *TomlConfig) error {
 		logger.Infof("No database records to insert")
 	}
 
+	if config.Database.ReverseZones {
+		if err := saveReverseRecords(db, hostmaps, config, wanted, changes); err != nil {
+			return err
+		}
+	}
+
+	if mode != "keep" {
+		if err := reconcileRecords(db, wanted, mode, changes); err != nil {
+			return err
+		}
+	}
+
+	return bumpSerials(db, changes, serialFormat)
+}
+
+// adoptLegacyRecords takes over the records written by versions of the
+// scraper from before the managed column. Those records never got a domain_id
+// either, which PowerDNS needs to serve a record, so an A, AAAA or CNAME
+// record in one of the configured domains without one can only have come from
+// the scraper. Adopted records get their domain_id, so this only happens once.
+func adoptLegacyRecords(db *gorm.DB, config *TomlConfig, domains []sqlmodel.Domain, changes *zoneChanges) error {
+	var records []sqlmodel.Record
+	if err := db.Where("managed = ? AND domain_id IS NULL AND type IN ?", false, []string{"A", "AAAA", "CNAME"}).Find(&records).Error; err != nil {
+		return err
+	}
+
+	var adopted []sqlmodel.Record
+	for _, record := range records {
+		if !inDomains(record.Name, allDomains(config)) {
+			continue
+		}
+		record.Managed = true
+		record.DomainId = domainFor(record.Name, domains)
+		adopted = append(adopted, record)
+	}
+	if len(adopted) == 0 {
+		return nil
+	}
+
+	changes.touch(adopted)
+	if err := db.Save(&adopted).Error; err != nil {
+		return err
+	}
+	logger.Infof("Adopted %d database records written by an older version of the scraper", len(adopted))
+	return nil
+}
+
+// inDomains checks if a name is one of the domains or in one of them
+func inDomains(name string, domains []string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.Trim(domain, "."))
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordKey identifies a single resource record
+type recordKey struct {
+	Name    string
+	Type    string
+	Content string
+}
+
+// addressRecords lists the names that should have A or AAAA records, in the
+// order of the hostmap, with the addresses for each of them
+func addressRecords(hostmaps []*Hostmap, config *TomlConfig, rrtype string) ([]string, map[string][]string) {
+	var names []string
+	addresses := make(map[string][]string)
+	seen := make(map[recordKey]bool)
+
+	for _, hostmap := range hostmaps {
+		if hostmap.removalCode != NotRemoved {
+			continue
+		}
+
+		for _, host := range hostmap.fqdns {
+			host = strings.TrimSuffix(host, ".")
+
+			var ips []netip.Addr
+			if addressType(hostmap.ip) == rrtype {
+				ips = append(ips, hostmap.ip)
+			}
+			if rrtype == "AAAA" && ipv6Enabled(host, config) {
+				ips = append(ips, hostmap.ipv6...)
+			}
+
+			for _, ip := range ips {
+				key := recordKey{host, rrtype, ip.String()}
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				if _, ok := addresses[host]; !ok {
+					names = append(names, host)
+				}
+				addresses[host] = append(addresses[host], ip.String())
+			}
+		}
+	}
+
+	return names, addresses
+}
+
+// syncRecords works out the changes that give a name records with exactly
+// the wanted contents. Only records the scraper manages are updated: records
+// with the right content are kept, and the others are reused for contents
+// that are missing before anything new is inserted. Managed records that are
+// left over are returned as extra. A record that someone else made for the
+// name is never touched. If it doesn't match what the scraper wants, the name
+// is skipped with a warning and ok is false.
+func syncRecords(name, rrtype string, contents []string, existing []sqlmodel.Record, domainId sql.NullInt64) (updates, inserts, extra []sqlmodel.Record, ok bool) {
+	want := make(map[string]bool)
+	for _, content := range contents {
+		want[content] = true
+	}
+
+	var managed []sqlmodel.Record
+	for _, record := range existing {
+		if record.Managed {
+			managed = append(managed, record)
+		} else if !want[record.Content] {
+			logger.Warnf("Not writing %s records for %s, it has a %s record that wasn't made by the scraper (%s)", rrtype, name, rrtype, record.Content)
+			return nil, nil, nil, false
+		}
+	}
+
+	// contents that already have a record, whoever made it. Records made by
+	// someone else are left alone, except for linking them to their domain.
+	present := make(map[string]bool)
+	for _, record := range existing {
+		if !record.Managed && want[record.Content] {
+			present[record.Content] = true
+			if !record.DomainId.Valid && domainId.Valid {
+				record.DomainId = domainId
+				updates = append(updates, record)
+			}
+		}
+	}
+
+	var unused []sqlmodel.Record
+	for _, record := range managed {
+		if want[record.Content] && !present[record.Content] {
+			present[record.Content] = true
+			if record.DomainId != domainId {
+				record.DomainId = domainId
+				updates = append(updates, record)
+			}
+			continue
+		}
+		unused = append(unused, record)
+	}
+
+	for _, content := range contents {
+		if present[content] {
+			continue
+		}
+		present[content] = true
+		if len(unused) > 0 {
+			record := unused[0]
+			unused = unused[1:]
+			record.Content = content
+			record.DomainId = domainId
+			updates = append(updates, record)
+			continue
+		}
+		inserts = append(inserts, sqlmodel.Record{
+			DomainId: domainId,
+			Name:     name,
+			Type:     rrtype,
+			Content:  content,
+			Ttl:      3600,
+			Managed:  true,
+		})
+	}
+
+	return updates, inserts, unused, true
+}
+
+// staleRecordsMode validates the StaleRecords setting, which defaults to keep
+func staleRecordsMode(config *TomlConfig) (string, error) {
+	switch mode := strings.ToLower(config.Database.StaleRecords); mode {
+	case "", "keep":
+		return "keep", nil
+	case "disable", "delete":
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unsupported stale_records mode: %s", config.Database.StaleRecords)
+	}
+}
+
+// reconcileRecords handles records that the scraper created in an earlier run
+// but that no longer match a host, either because the host was removed or
+// because it has gone away. Depending on the mode these are disabled or
+// deleted. Records that the scraper did not create are never touched.
+func reconcileRecords(db *gorm.DB, wanted map[recordKey]bool, mode string, changes *zoneChanges) error {
+	var records []sqlmodel.Record
+	if err := db.Model(&sqlmodel.Record{}).
+		Where("managed = ? AND type IN ?", true, []string{"A", "AAAA", "CNAME", "PTR"}).
+		Find(&records).Error; err != nil {
+		return err
+	}
+
+	var staleRecords []sqlmodel.Record
+	var changedRecords []sqlmodel.Record
+	for _, record := range records {
+		isWanted := wanted[recordKey{record.Name, record.Type, record.Content}]
+		if !isWanted && !record.Disabled {
+			staleRecords = append(staleRecords, record)
+		} else if isWanted && record.Disabled {
+			// a host that came back gets its old records enabled again
+			record.Disabled = false
+			changedRecords = append(changedRecords, record)
+		}
+	}
+
+	if len(staleRecords) > 0 {
+		if mode == "delete" {
+			changes.touch(staleRecords)
+			if err := db.Delete(&staleRecords).Error; err != nil {
+				return err
+			}
+			logger.Infof("Deleted %d stale database records", len(staleRecords))
+		} else {
+			for i := range staleRecords {
+				staleRecords[i].Disabled = true
+			}
+			changedRecords = append(changedRecords, staleRecords...)
+			logger.Infof("Disabled %d stale database records", len(staleRecords))
+		}
+	}
+
+	if len(changedRecords) > 0 {
+		changes.touch(changedRecords)
+		if err := db.Save(&changedRecords).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ensureDomain finds the domain row for a zone, creating a NATIVE domain if
+// PowerDNS doesn't have one yet. Zones without an SOA or NS records get
+// generated ones, as PowerDNS won't serve a zone without an SOA.
+func ensureDomain(db *gorm.DB, name string, config *TomlConfig, changes *zoneChanges) (sqlmodel.Domain, error) {
+	serialFormat, err := soaSerialFormat(config.Database.SerialFormat)
+	if err != nil {
+		return sqlmodel.Domain{}, err
+	}
+
+	domainType := strings.ToUpper(config.Database.DomainType)
+	if domainType == "" {
+		domainType = "NATIVE"
+	}
+
+	var domain sqlmodel.Domain
+	if err := db.Where(sqlmodel.Domain{Name: name}).
+		Attrs(sqlmodel.Domain{Type: domainType}).
+		FirstOrCreate(&domain).Error; err != nil {
+		return domain, err
+	}
+
+	domainId := sql.NullInt64{Int64: int64(domain.ID), Valid: true}
+	var newRecords []sqlmodel.Record
+
+	var count int64
+	if err := db.Model(&sqlmodel.Record{}).Where("domain_id = ? AND type = ?", domain.ID, "SOA").Count(&count).Error; err != nil {
+		return domain, err
+	}
+	if count == 0 {
+		newRecords = append(newRecords, sqlmodel.Record{
+			DomainId: domainId,
+			Name:     name,
+			Type:     "SOA",
+			Content:  soaContent(name, nextSerial(0, serialFormat, changes.now), config.Database.Nameservers, config.Database.Hostmaster),
+			Ttl:      3600,
+			Managed:  true,
+		})
+	}
+
+	if err := db.Model(&sqlmodel.Record{}).Where("domain_id = ? AND type = ?", domain.ID, "NS").Count(&count).Error; err != nil {
+		return domain, err
+	}
+	if count == 0 {
+		for _, nameserver := range nameservers(config.Database.Nameservers) {
+			newRecords = append(newRecords, sqlmodel.Record{
+				DomainId: domainId,
+				Name:     name,
+				Type:     "NS",
+				Content:  nameserver,
+				Ttl:      3600,
+				Managed:  true,
+			})
+		}
+	}
+
+	if len(newRecords) > 0 {
+		for i := range newRecords {
+			newRecords[i].ChangDate = int(changes.now.Unix())
+		}
+		if err := db.Create(&newRecords).Error; err != nil {
+			return domain, err
+		}
+		logger.Infof("Created SOA and NS records for domain %s", name)
+	}
+
+	return domain, nil
+}
+
+// domainFor finds the domain that a record belongs to using the longest
+// matching suffix of the record name
+func domainFor(name string, domains []sqlmodel.Domain) sql.NullInt64 {
+	var domainId sql.NullInt64
+	longest := -1
+
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for _, domain := range domains {
+		zone := strings.ToLower(strings.TrimSuffix(domain.Name, "."))
+		if (name == zone || strings.HasSuffix(name, "."+zone)) && len(zone) > longest {
+			domainId = sql.NullInt64{Int64: int64(domain.ID), Valid: true}
+			longest = len(zone)
+		}
+	}
+
+	return domainId
+}
+
+// saveReverseRecords writes a single PTR record for every address that is
+// still in the hostmap, creating the in-addr.arpa and ip6.arpa domains that
+// hold them as needed
+func saveReverseRecords(db *gorm.DB, hostmaps []*Hostmap, config *TomlConfig, wanted map[recordKey]bool, changes *zoneChanges) error {
+	var updateRecords []sqlmodel.Record
+	var newRecords []sqlmodel.Record
+	var duplicateRecords []sqlmodel.Record
+
+	// build the list of PTR records that should exist, which are the same
+	// as for the other outputs
+	ptrs := make(map[string]string)
+	zones := make(map[string]string)
+	addresses := reverseAddresses(hostmaps)
+	for _, record := range collectRecords(hostmaps, config, true) {
+		if record.Type != "PTR" {
+			continue
+		}
+		ptrs[record.Name] = record.Content
+		zones[record.Name] = reverseZone(addresses[record.Name])
+	}
+
+	domains := make(map[string]sqlmodel.Domain)
+	for _, zone := range zones {
+		if _, ok := domains[zone]; ok {
+			continue
+		}
+		domain, err := ensureDomain(db, zone, config, changes)
+		if err != nil {
+			return err
+		}
+		domains[zone] = domain
+	}
+
+	var records []sqlmodel.Record
+	if err := db.Model(&sqlmodel.Record{}).Where("type = ?", "PTR").Order("id").Find(&records).Error; err != nil {
+		return err
+	}
+
+	existing := make(map[string][]sqlmodel.Record)
+	for _, record := range records {
+		existing[record.Name] = append(existing[record.Name], record)
+	}
+
+	// only one PTR record per address is authoritative, so other PTR records
+	// the scraper made for the address are removed
+	for name, fqdn := range ptrs {
+		domainId := sql.NullInt64{Int64: int64(domains[zones[name]].ID), Valid: true}
+		updates, inserts, extra, ok := syncRecords(name, "PTR", []string{fqdn}, existing[name], domainId)
+		if !ok {
+			continue
+		}
+		wanted[recordKey{name, "PTR", fqdn}] = true
+		updateRecords = append(updateRecords, updates...)
+		newRecords = append(newRecords, inserts...)
+		duplicateRecords = append(duplicateRecords, extra...)
+	}
+
+	if len(duplicateRecords) > 0 {
+		changes.touch(duplicateRecords)
+		if err := db.Delete(&duplicateRecords).Error; err != nil {
+			return err
+		}
+		logger.Infof("Removed %d duplicate PTR records", len(duplicateRecords))
+	}
+
+	if len(updateRecords) > 0 {
+		changes.touch(updateRecords)
+		if err := db.Save(&updateRecords).Error; err != nil {
+			return err
+		}
+		logger.Infof("Updated %d PTR records", len(updateRecords))
+	}
+
+	if len(newRecords) > 0 {
+		changes.touch(newRecords)
+		if err := db.Create(&newRecords).Error; err != nil {
+			return err
+		}
+		logger.Infof("Inserted %d PTR records", len(newRecords))
+	}
+
 	return nil
 }
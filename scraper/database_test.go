@@ -1,7 +1,10 @@
 package scraper
 
 import (
+	"database/sql"
+	"net/netip"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -86,18 +89,7 @@ func TestSaveDatabase(t *testing.T) {
 
 	// Create a config
 	config := &TomlConfig{
-		Processing: struct {
-			Domains    []string
-			Additional []struct {
-				IP           string
-				Hostnames    []string
-				Name         string
-				KeepMultiple *bool
-			}
-			Blocked  []struct{ IP, Name string }
-			Cnames   []struct{ Cname, Hostname string }
-			KeepMacs bool
-		}{
+		Processing: ProcessingConfig{
 			Domains: []string{"local", "example.com"},
 		},
 	}
@@ -116,7 +108,7 @@ func TestSaveDatabase(t *testing.T) {
 
 	// Check that records were saved
 	var count int64
-	if err := db.Model(&sqlmodel.Record{}).Count(&count).Error; err != nil {
+	if err := db.Model(&sqlmodel.Record{}).Where("type = ?", "A").Count(&count).Error; err != nil {
 		t.Errorf("Failed to count records: %v", err)
 	}
 
@@ -151,7 +143,7 @@ func TestSaveDatabase(t *testing.T) {
 	}
 
 	// Verify that the records were updated and not duplicated
-	if err := db.Model(&sqlmodel.Record{}).Count(&count).Error; err != nil {
+	if err := db.Model(&sqlmodel.Record{}).Where("type = ?", "A").Count(&count).Error; err != nil {
 		t.Errorf("Failed to count records after update: %v", err)
 	}
 
@@ -187,3 +179,648 @@ func createTestTime() time.Time {
 	t, _ := time.Parse(time.RFC3339, "2023-01-01T12:00:00Z")
 	return t
 }
+
+// TestSaveDatabaseIPv6 tests that AAAA records are only written for domains with IPv6 enabled
+func TestSaveDatabaseIPv6(t *testing.T) {
+	db, err := OpenDatabase("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open in-memory database: %v", err)
+	}
+
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	hostmaps := []*Hostmap{
+		{
+			ip:          createIP("192.168.1.100"),
+			ipv6:        []netip.Addr{createIP("2001:db8::100"), createIP("2001:db8::101")},
+			hostnames:   []string{"test1"},
+			fqdns:       []string{"test1.local", "test1.example.com"},
+			lastseen:    cTimeNow,
+			removalCode: NotRemoved,
+		},
+	}
+
+	config := &TomlConfig{
+		Processing: ProcessingConfig{
+			Domains:     []string{"local", "example.com"},
+			IPv6Domains: []string{"example.com"},
+		},
+	}
+
+	if err := SaveDatabase(db, hostmaps, config); err != nil {
+		t.Fatalf("SaveDatabase() error = %v", err)
+	}
+
+	var records []sqlmodel.Record
+	if err := db.Where("type = ?", "AAAA").Order("content").Find(&records).Error; err != nil {
+		t.Fatalf("Failed to find records: %v", err)
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 AAAA records, got %d", len(records))
+	}
+	for i, want := range []string{"2001:db8::100", "2001:db8::101"} {
+		if records[i].Name != "test1.example.com" || records[i].Content != want {
+			t.Errorf("AAAA record %d = %s %s, want test1.example.com %s", i, records[i].Name, records[i].Content, want)
+		}
+	}
+
+	// one address changes, the existing record should be reused
+	hostmaps[0].ipv6 = []netip.Addr{createIP("2001:db8::100"), createIP("2001:db8::102")}
+	if err := SaveDatabase(db, hostmaps, config); err != nil {
+		t.Fatalf("SaveDatabase() error on update = %v", err)
+	}
+
+	records = nil
+	if err := db.Where("type = ?", "AAAA").Order("content").Find(&records).Error; err != nil {
+		t.Fatalf("Failed to find records: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 AAAA records after update, got %d", len(records))
+	}
+	if records[1].Content != "2001:db8::102" {
+		t.Errorf("Expected AAAA record to be updated to 2001:db8::102, got %s", records[1].Content)
+	}
+}
+
+// TestSaveDatabaseIPv6Host tests an additional host that only has an IPv6
+// address, which gets an AAAA record even without ipv6_domains
+func TestSaveDatabaseIPv6Host(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	db, err := OpenDatabase("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open in-memory database: %v", err)
+	}
+
+	hostmaps := []*Hostmap{
+		{
+			ip:          createIP("fd00::53"),
+			hostnames:   []string{"nas"},
+			fqdns:       []string{"nas.local"},
+			lastseen:    cTimeNow,
+			removalCode: NotRemoved,
+			source:      SourceStatic,
+		},
+	}
+	config := &TomlConfig{Processing: ProcessingConfig{Domains: []string{"local"}}}
+
+	if err := SaveDatabase(db, hostmaps, config); err != nil {
+		t.Fatalf("SaveDatabase() error = %v", err)
+	}
+
+	var records []sqlmodel.Record
+	if err := db.Where("type IN ?", []string{"A", "AAAA"}).Find(&records).Error; err != nil {
+		t.Fatalf("Failed to find records: %v", err)
+	}
+	if len(records) != 1 || records[0].Type != "AAAA" || records[0].Content != "fd00::53" {
+		t.Errorf("Expected a single AAAA record for fd00::53, got %+v", records)
+	}
+}
+
+// TestSaveDatabaseReverseZones tests that PTR records and their domains are created
+func TestSaveDatabaseReverseZones(t *testing.T) {
+	db, err := OpenDatabase("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open in-memory database: %v", err)
+	}
+
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	hostmaps := []*Hostmap{
+		{
+			ip:          createIP("192.168.1.100"),
+			ipv6:        []netip.Addr{createIP("2001:db8::100")},
+			hostnames:   []string{"test1"},
+			fqdns:       []string{"test1.example.com", "test1.local"},
+			lastseen:    cTimeNow,
+			removalCode: NotRemoved,
+		},
+		{
+			ip:          createIP("192.168.1.101"),
+			hostnames:   []string{"blocked"},
+			fqdns:       []string{"blocked.example.com", "blocked.local"},
+			lastseen:    cTimeNow,
+			removalCode: Blocked,
+		},
+		// a second host on the same address, kept by keep_both, doesn't get
+		// the PTR record as the first host already has it
+		{
+			ip:          createIP("192.168.1.100"),
+			hostnames:   []string{"second"},
+			fqdns:       []string{"second.example.com"},
+			lastseen:    cTimeNow,
+			removalCode: NotRemoved,
+		},
+	}
+
+	config := &TomlConfig{
+		Processing: ProcessingConfig{
+			Domains:     []string{"example.com", "local"},
+			IPv6Domains: []string{"local"},
+		},
+		Database: DatabaseConfig{ReverseZones: true},
+	}
+
+	// a stray duplicate PTR the scraper made should be cleaned up
+	if err := db.Create(&sqlmodel.Record{Name: "100.1.168.192.in-addr.arpa", Type: "PTR", Content: "old.example.com", Managed: true}).Error; err != nil {
+		t.Fatalf("Failed to create record: %v", err)
+	}
+	if err := db.Create(&sqlmodel.Record{Name: "100.1.168.192.in-addr.arpa", Type: "PTR", Content: "older.example.com", Managed: true}).Error; err != nil {
+		t.Fatalf("Failed to create record: %v", err)
+	}
+	// a PTR record made by hand is left alone, and the scraper doesn't add
+	// its own for that address
+	if err := db.Create(&sqlmodel.Record{Name: "102.1.168.192.in-addr.arpa", Type: "PTR", Content: "manual.example.com"}).Error; err != nil {
+		t.Fatalf("Failed to create record: %v", err)
+	}
+	hostmaps = append(hostmaps, &Hostmap{
+		ip:          createIP("192.168.1.102"),
+		hostnames:   []string{"test3"},
+		fqdns:       []string{"test3.example.com"},
+		lastseen:    cTimeNow,
+		removalCode: NotRemoved,
+	})
+
+	if err := SaveDatabase(db, hostmaps, config); err != nil {
+		t.Fatalf("SaveDatabase() error = %v", err)
+	}
+
+	var records []sqlmodel.Record
+	if err := db.Where("type = ?", "PTR").Order("name").Find(&records).Error; err != nil {
+		t.Fatalf("Failed to find records: %v", err)
+	}
+
+	want := map[string]string{
+		"100.1.168.192.in-addr.arpa": "test1.example.com",
+		"102.1.168.192.in-addr.arpa": "manual.example.com",
+		"0.0.1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa": "test1.local",
+	}
+	if len(records) != len(want) {
+		t.Fatalf("Expected %d PTR records, got %d", len(want), len(records))
+	}
+
+	for _, record := range records {
+		if want[record.Name] != record.Content {
+			t.Errorf("PTR record %s = %s, want %s", record.Name, record.Content, want[record.Name])
+		}
+
+		if !record.Managed {
+			continue
+		}
+
+		var domain sqlmodel.Domain
+		if err := db.First(&domain, record.DomainId.Int64).Error; err != nil {
+			t.Errorf("PTR record %s has no domain: %v", record.Name, err)
+			continue
+		}
+		if !strings.HasSuffix(record.Name, "."+domain.Name) || domain.Type != "NATIVE" {
+			t.Errorf("PTR record %s linked to wrong domain %s (%s)", record.Name, domain.Name, domain.Type)
+		}
+	}
+}
+
+// TestSaveDatabaseStaleRecords tests that stale records owned by the scraper are disabled or deleted
+func TestSaveDatabaseStaleRecords(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	for _, mode := range []string{"disable", "delete"} {
+		t.Run(mode, func(t *testing.T) {
+			db, err := OpenDatabase("sqlite", ":memory:")
+			if err != nil {
+				t.Fatalf("Failed to open in-memory database: %v", err)
+			}
+
+			// a record that the scraper did not create must never be touched
+			manual := sqlmodel.Record{DomainId: createDomain(t, db, "local"), Name: "manual.local", Type: "A", Content: "192.168.1.250", Ttl: 3600}
+			if err := db.Create(&manual).Error; err != nil {
+				t.Fatalf("Failed to create record: %v", err)
+			}
+
+			hostmaps := []*Hostmap{
+				{
+					ip:          createIP("192.168.1.100"),
+					hostnames:   []string{"test1"},
+					fqdns:       []string{"test1.local"},
+					lastseen:    cTimeNow,
+					removalCode: NotRemoved,
+				},
+				{
+					ip:          createIP("192.168.1.101"),
+					hostnames:   []string{"test2"},
+					fqdns:       []string{"test2.local"},
+					lastseen:    cTimeNow,
+					removalCode: NotRemoved,
+				},
+			}
+
+			config := &TomlConfig{
+				Processing: ProcessingConfig{Domains: []string{"local"}},
+				Database:   DatabaseConfig{StaleRecords: mode},
+			}
+			config.Processing.Cnames = append(config.Processing.Cnames, struct {
+				Cname    string
+				Hostname string
+			}{Cname: "www.local", Hostname: "test2.local"})
+
+			if err := SaveDatabase(db, hostmaps, config); err != nil {
+				t.Fatalf("SaveDatabase() error = %v", err)
+			}
+
+			// test2 goes away, which also leaves the CNAME without a target
+			hostmaps[1].removalCode = Old
+			if err := SaveDatabase(db, hostmaps, config); err != nil {
+				t.Fatalf("SaveDatabase() error = %v", err)
+			}
+
+			var records []sqlmodel.Record
+			if err := db.Where("type IN ?", []string{"A", "CNAME"}).Order("name").Find(&records).Error; err != nil {
+				t.Fatalf("Failed to find records: %v", err)
+			}
+
+			active := make(map[string]bool)
+			disabled := make(map[string]bool)
+			for _, record := range records {
+				if record.Disabled {
+					disabled[record.Name] = true
+				} else {
+					active[record.Name] = true
+				}
+			}
+
+			if !active["manual.local"] || !active["test1.local"] {
+				t.Errorf("Expected manual.local and test1.local to be active, got %v", active)
+			}
+			if active["test2.local"] || active["www.local"] {
+				t.Errorf("Expected test2.local and www.local to be inactive, got %v", active)
+			}
+			if mode == "disable" && (!disabled["test2.local"] || !disabled["www.local"]) {
+				t.Errorf("Expected test2.local and www.local to be disabled, got %v", disabled)
+			}
+			if mode == "delete" && len(records) != 2 {
+				t.Errorf("Expected 2 records after delete, got %d", len(records))
+			}
+
+			// when the host comes back everything is enabled again
+			hostmaps[1].removalCode = NotRemoved
+			if err := SaveDatabase(db, hostmaps, config); err != nil {
+				t.Fatalf("SaveDatabase() error = %v", err)
+			}
+
+			var count int64
+			if err := db.Model(&sqlmodel.Record{}).Where("type IN ? AND disabled = ?", []string{"A", "CNAME"}, false).Count(&count).Error; err != nil {
+				t.Fatalf("Failed to count records: %v", err)
+			}
+			if count != 4 {
+				t.Errorf("Expected 4 active records after host returned, got %d", count)
+			}
+		})
+	}
+}
+
+// TestSaveDatabaseDomains tests that domains are created and records are linked to them
+func TestSaveDatabaseDomains(t *testing.T) {
+	db, err := OpenDatabase("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open in-memory database: %v", err)
+	}
+
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	// an existing domain with its own SOA must be reused and left alone
+	existing := sqlmodel.Domain{Name: "example.com", Type: "MASTER"}
+	if err := db.Create(&existing).Error; err != nil {
+		t.Fatalf("Failed to create domain: %v", err)
+	}
+	existingSOA := sqlmodel.Record{
+		DomainId: sql.NullInt64{Int64: int64(existing.ID), Valid: true},
+		Name:     "example.com",
+		Type:     "SOA",
+		Content:  "ns.example.com admin.example.com 5 10800 3600 604800 3600",
+	}
+	if err := db.Create(&existingSOA).Error; err != nil {
+		t.Fatalf("Failed to create record: %v", err)
+	}
+
+	hostmaps := []*Hostmap{
+		{
+			ip:          createIP("192.168.1.100"),
+			hostnames:   []string{"test1"},
+			fqdns:       []string{"test1.example.com", "test1.home.example.com"},
+			lastseen:    cTimeNow,
+			removalCode: NotRemoved,
+		},
+	}
+
+	config := &TomlConfig{
+		Processing: ProcessingConfig{Domains: []string{"example.com", "home.example.com"}},
+		Database: DatabaseConfig{
+			Nameservers:  []string{"ns1.example.com.", "ns2.example.com"},
+			Hostmaster:   "admin@example.com",
+			SerialFormat: "increment",
+		},
+	}
+
+	if err := SaveDatabase(db, hostmaps, config); err != nil {
+		t.Fatalf("SaveDatabase() error = %v", err)
+	}
+
+	var domains []sqlmodel.Domain
+	if err := db.Order("name").Find(&domains).Error; err != nil {
+		t.Fatalf("Failed to find domains: %v", err)
+	}
+	if len(domains) != 2 {
+		t.Fatalf("Expected 2 domains, got %d", len(domains))
+	}
+	home := domains[1]
+	if home.Name != "home.example.com" || home.Type != "NATIVE" {
+		t.Errorf("Expected NATIVE domain home.example.com, got %s %s", home.Type, home.Name)
+	}
+
+	var soas []sqlmodel.Record
+	if err := db.Where("type = ?", "SOA").Order("name").Find(&soas).Error; err != nil {
+		t.Fatalf("Failed to find records: %v", err)
+	}
+	if len(soas) != 2 {
+		t.Fatalf("Expected 2 SOA records, got %d", len(soas))
+	}
+	// the existing SOA only gets its serial bumped
+	if soas[0].Content != "ns.example.com admin.example.com 6 10800 3600 604800 3600" {
+		t.Errorf("Existing SOA was modified: %s", soas[0].Content)
+	}
+	wantSOA := "ns1.example.com admin.example.com 2 10800 3600 604800 3600"
+	if soas[1].Content != wantSOA || soas[1].DomainId.Int64 != int64(home.ID) {
+		t.Errorf("Generated SOA = %s (domain %d), want %s (domain %d)", soas[1].Content, soas[1].DomainId.Int64, wantSOA, home.ID)
+	}
+
+	var nsCount int64
+	if err := db.Model(&sqlmodel.Record{}).Where("type = ? AND domain_id = ?", "NS", home.ID).Count(&nsCount).Error; err != nil {
+		t.Fatalf("Failed to count records: %v", err)
+	}
+	if nsCount != 2 {
+		t.Errorf("Expected 2 NS records for home.example.com, got %d", nsCount)
+	}
+
+	// records are linked to the domain with the longest matching suffix
+	var records []sqlmodel.Record
+	if err := db.Where("type = ?", "A").Find(&records).Error; err != nil {
+		t.Fatalf("Failed to find records: %v", err)
+	}
+	for _, record := range records {
+		want := existing.ID
+		if record.Name == "test1.home.example.com" {
+			want = home.ID
+		}
+		if !record.DomainId.Valid || record.DomainId.Int64 != int64(want) {
+			t.Errorf("Record %s linked to domain %v, want %d", record.Name, record.DomainId, want)
+		}
+	}
+}
+
+// TestSaveDatabaseSharedNames tests hosts that share a name, either because
+// one of them was removed or because conflicts keep both. The records have to
+// settle, so running again without changes doesn't move the serial.
+func TestSaveDatabaseSharedNames(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	for _, mode := range []string{"keep", "disable", "delete"} {
+		t.Run(mode, func(t *testing.T) {
+			db, err := OpenDatabase("sqlite", ":memory:")
+			if err != nil {
+				t.Fatalf("Failed to open in-memory database: %v", err)
+			}
+
+			hostmaps := []*Hostmap{
+				// the laptop expired on its old address and came back on a
+				// new one
+				{
+					ip:          createIP("192.168.1.20"),
+					hostnames:   []string{"laptop"},
+					fqdns:       []string{"laptop.home.lan"},
+					lastseen:    cTimeNow,
+					removalCode: Old,
+				},
+				{
+					ip:          createIP("192.168.1.50"),
+					hostnames:   []string{"laptop"},
+					fqdns:       []string{"laptop.home.lan"},
+					lastseen:    cTimeNow,
+					removalCode: NotRemoved,
+				},
+				// two hosts kept by keep_both
+				{
+					ip:          createIP("192.168.1.30"),
+					hostnames:   []string{"nas"},
+					fqdns:       []string{"nas.home.lan"},
+					lastseen:    cTimeNow,
+					removalCode: NotRemoved,
+				},
+				{
+					ip:          createIP("192.168.1.31"),
+					hostnames:   []string{"nas"},
+					fqdns:       []string{"nas.home.lan"},
+					lastseen:    cTimeNow,
+					removalCode: NotRemoved,
+				},
+			}
+
+			config := &TomlConfig{
+				Processing: ProcessingConfig{Domains: []string{"home.lan"}},
+				Database:   DatabaseConfig{StaleRecords: mode, SerialFormat: "increment", ReverseZones: true},
+			}
+
+			serial := func() string {
+				var soa sqlmodel.Record
+				if err := db.Where("type = ? AND name = ?", "SOA", "home.lan").First(&soa).Error; err != nil {
+					t.Fatalf("Failed to find SOA: %v", err)
+				}
+				return strings.Fields(soa.Content)[2]
+			}
+
+			if err := SaveDatabase(db, hostmaps, config); err != nil {
+				t.Fatalf("SaveDatabase() error = %v", err)
+			}
+			first := serial()
+			for run := 2; run <= 3; run++ {
+				if err := SaveDatabase(db, hostmaps, config); err != nil {
+					t.Fatalf("SaveDatabase() error = %v", err)
+				}
+				if got := serial(); got != first {
+					t.Errorf("Run %d moved the serial from %s to %s without changes", run, first, got)
+				}
+			}
+
+			var records []sqlmodel.Record
+			if err := db.Where("type = ?", "A").Order("name, content").Find(&records).Error; err != nil {
+				t.Fatalf("Failed to find records: %v", err)
+			}
+			var got []string
+			for _, record := range records {
+				got = append(got, record.Name+" "+record.Content)
+			}
+			want := "laptop.home.lan 192.168.1.50,nas.home.lan 192.168.1.30,nas.home.lan 192.168.1.31"
+			if strings.Join(got, ",") != want {
+				t.Errorf("A records = %v, want %s", got, want)
+			}
+		})
+	}
+}
+
+// TestSaveDatabaseUnmanagedRecords tests that records the scraper didn't make
+// are never changed, even when they are in the way of a host
+func TestSaveDatabaseUnmanagedRecords(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	db, err := OpenDatabase("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open in-memory database: %v", err)
+	}
+
+	domainId := createDomain(t, db, "local")
+	manual := []sqlmodel.Record{
+		// points somewhere else than the host of the same name
+		{DomainId: domainId, Name: "printer.local", Type: "A", Content: "192.168.1.250", Ttl: 3600},
+		// already says what the scraper would write
+		{DomainId: domainId, Name: "nas.local", Type: "A", Content: "192.168.1.70", Ttl: 3600},
+	}
+	if err := db.Create(&manual).Error; err != nil {
+		t.Fatalf("Failed to create records: %v", err)
+	}
+
+	hostmaps := []*Hostmap{
+		{
+			ip:          createIP("192.168.1.60"),
+			hostnames:   []string{"printer"},
+			fqdns:       []string{"printer.local"},
+			lastseen:    cTimeNow,
+			removalCode: NotRemoved,
+		},
+		{
+			ip:          createIP("192.168.1.70"),
+			hostnames:   []string{"nas"},
+			fqdns:       []string{"nas.local"},
+			lastseen:    cTimeNow,
+			removalCode: NotRemoved,
+		},
+	}
+	config := &TomlConfig{
+		Processing: ProcessingConfig{Domains: []string{"local"}},
+		Database:   DatabaseConfig{StaleRecords: "delete"},
+	}
+
+	for run := 1; run <= 2; run++ {
+		if err := SaveDatabase(db, hostmaps, config); err != nil {
+			t.Fatalf("SaveDatabase() error = %v", err)
+		}
+	}
+
+	var records []sqlmodel.Record
+	if err := db.Where("type = ?", "A").Order("name").Find(&records).Error; err != nil {
+		t.Fatalf("Failed to find records: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected only the 2 records made by hand, got %d", len(records))
+	}
+	for i, record := range records {
+		original := manual[1-i]
+		if record.Content != original.Content || record.Managed || record.Disabled || record.DomainId != domainId {
+			t.Errorf("Record %s was changed: %+v", record.Name, record)
+		}
+	}
+}
+
+// createDomain creates a domain like someone running PowerDNS by hand would
+func createDomain(t *testing.T, db *gorm.DB, name string) sql.NullInt64 {
+	t.Helper()
+	domain := sqlmodel.Domain{Name: name, Type: "NATIVE"}
+	if err := db.Create(&domain).Error; err != nil {
+		t.Fatalf("Failed to create domain: %v", err)
+	}
+	return sql.NullInt64{Int64: int64(domain.ID), Valid: true}
+}
+
+// TestSaveDatabaseLegacyRecords tests a database written by a version of the
+// scraper from before records were marked as managed or linked to a domain
+func TestSaveDatabaseLegacyRecords(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	db, err := OpenDatabase("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open in-memory database: %v", err)
+	}
+
+	legacy := []sqlmodel.Record{
+		{Name: "host1.example.com", Type: "A", Content: "192.168.1.10", Ttl: 3600},
+		{Name: "www.example.com", Type: "CNAME", Content: "host1.example.com", Ttl: 3600},
+		// outside the configured domains, so not the scraper's
+		{Name: "host1.other.lan", Type: "A", Content: "192.168.1.10", Ttl: 3600},
+		// a PTR made by hand that matches the scraper, so it only gets linked
+		{Name: "20.1.168.192.in-addr.arpa", Type: "PTR", Content: "host1.example.com", Ttl: 3600},
+	}
+	if err := db.Create(&legacy).Error; err != nil {
+		t.Fatalf("Failed to create records: %v", err)
+	}
+
+	// host1 moved to a new address since the old version last ran
+	hostmaps := []*Hostmap{
+		{
+			ip:          createIP("192.168.1.20"),
+			hostnames:   []string{"host1"},
+			fqdns:       []string{"host1.example.com"},
+			lastseen:    cTimeNow,
+			removalCode: NotRemoved,
+		},
+	}
+	config := &TomlConfig{
+		Processing: ProcessingConfig{Domains: []string{"example.com"}},
+		Database:   DatabaseConfig{ReverseZones: true},
+	}
+	config.Processing.Cnames = append(config.Processing.Cnames, struct {
+		Cname    string
+		Hostname string
+	}{Cname: "www.example.com", Hostname: "host1.example.com"})
+
+	if err := SaveDatabase(db, hostmaps, config); err != nil {
+		t.Fatalf("SaveDatabase() error = %v", err)
+	}
+
+	var records []sqlmodel.Record
+	if err := db.Where("type IN ?", []string{"A", "CNAME", "PTR"}).Order("id").Find(&records).Error; err != nil {
+		t.Fatalf("Failed to find records: %v", err)
+	}
+	if len(records) != len(legacy) {
+		t.Fatalf("Expected the %d old records to be reused, got %d records", len(legacy), len(records))
+	}
+
+	want := []struct {
+		content string
+		managed bool
+		linked  bool
+	}{
+		{"192.168.1.20", true, true},
+		{"host1.example.com", true, true},
+		{"192.168.1.10", false, false},
+		{"host1.example.com", false, true},
+	}
+	for i, record := range records {
+		if record.Content != want[i].content || record.Managed != want[i].managed || record.DomainId.Valid != want[i].linked {
+			t.Errorf("Record %s %s = %s managed=%v linked=%v, want %+v", record.Name, record.Type, record.Content, record.Managed, record.DomainId.Valid, want[i])
+		}
+	}
+}
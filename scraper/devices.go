@@ -0,0 +1,244 @@
+package scraper
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/unpoller/unifi"
+)
+
+// unifiDevice is a network device from any of the device families that the
+// controller returns
+type unifiDevice struct {
+	source   HostSource
+	name     string
+	ip       string
+	mac      string
+	site     string
+	lastSeen int64
+	wired    bool
+	// controller is the name of the controller that reported the device
+	controller string
+	// interfaces are the other addresses of the device, such as the WAN and
+	// LAN interfaces of a gateway
+	interfaces []deviceInterface
+}
+
+// deviceInterface is an extra address of a device. Its name is the name of
+// the device followed by the suffix.
+type deviceInterface struct {
+	suffix string
+	ip     string
+}
+
+// collectDevices flattens all of the device families into one list
+func collectDevices(devices *unifi.Devices, cfg *TomlConfig) []unifiDevice {
+	if devices == nil {
+		return nil
+	}
+
+	var collected []unifiDevice
+	for _, usw := range devices.USWs {
+		collected = append(collected, unifiDevice{
+			source: SourceSwitch, name: usw.Name, ip: usw.IP, mac: usw.Mac,
+			site: siteKey(usw.SiteName), lastSeen: int64(usw.LastSeen.Val), wired: true,
+		})
+	}
+	for _, uap := range devices.UAPs {
+		collected = append(collected, unifiDevice{
+			source: SourceAP, name: uap.Name, ip: uap.IP, mac: uap.Mac,
+			site: siteKey(uap.SiteName), lastSeen: int64(uap.LastSeen.Val), wired: uap.Uplink.Type != "wireless",
+		})
+	}
+	for _, usg := range devices.USGs {
+		collected = append(collected, unifiDevice{
+			source: SourceGateway, name: usg.Name, ip: usg.IP, mac: usg.Mac,
+			site: siteKey(usg.SiteName), lastSeen: int64(usg.LastSeen.Val), wired: true,
+			interfaces: gatewayInterfaces(usg.Wan1, usg.Wan2, usg.NetworkTable, cfg),
+		})
+	}
+	for _, udm := range devices.UDMs {
+		collected = append(collected, unifiDevice{
+			source: SourceGateway, name: udm.Name, ip: udm.IP, mac: udm.Mac,
+			site: siteKey(udm.SiteName), lastSeen: int64(udm.LastSeen.Val), wired: true,
+			interfaces: gatewayInterfaces(udm.Wan1, udm.Wan2, udm.NetworkTable, cfg),
+		})
+	}
+	for _, uxg := range devices.UXGs {
+		collected = append(collected, unifiDevice{
+			source: SourceGateway, name: uxg.Name, ip: uxg.IP, mac: uxg.Mac,
+			site: siteKey(uxg.SiteName), lastSeen: int64(uxg.LastSeen.Val), wired: true,
+			interfaces: gatewayInterfaces(uxg.Wan1, uxg.Wan2, uxg.NetworkTable, cfg),
+		})
+	}
+	for _, pdu := range devices.PDUs {
+		collected = append(collected, unifiDevice{
+			source: SourcePDU, name: pdu.Name, ip: pdu.IP, mac: pdu.Mac,
+			site: siteKey(pdu.SiteName), lastSeen: int64(pdu.LastSeen.Val), wired: true,
+		})
+	}
+
+	return collected
+}
+
+// gatewayInterfaces lists the WAN interfaces of a gateway and its address on
+// each of the networks it routes, if gateway_interfaces is turned on. It is
+// off by default, as the WAN addresses are usually public addresses that
+// would get PTR records and reverse zones of the ISP.
+func gatewayInterfaces(wan1, wan2 unifi.Wan, networks unifi.NetworkTable, cfg *TomlConfig) []deviceInterface {
+	if cfg.Devices.GatewayInterfaces == nil || !*cfg.Devices.GatewayInterfaces {
+		return nil
+	}
+
+	var interfaces []deviceInterface
+	if wan1.IP != "" {
+		interfaces = append(interfaces, deviceInterface{"wan1", wan1.IP})
+	}
+	if wan2.IP != "" {
+		interfaces = append(interfaces, deviceInterface{"wan2", wan2.IP})
+	}
+	for _, network := range networks {
+		if network.IP == "" {
+			continue
+		}
+		if suffix := hostnameLabel(network.Name); suffix != "" {
+			interfaces = append(interfaces, deviceInterface{suffix, network.IP})
+		}
+	}
+	return interfaces
+}
+
+// hostnameLabel turns a name like "IoT Devices" into something that can be
+// used in a hostname, like "iot-devices"
+func hostnameLabel(name string) string {
+	var builder strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && builder.Len() > 0 {
+				builder.WriteByte('-')
+			}
+			builder.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return builder.String()
+}
+
+// deviceConfig returns the [devices] settings for the family of a host
+func deviceConfig(source HostSource, cfg *TomlConfig) DeviceConfig {
+	switch source {
+	case SourceSwitch:
+		return cfg.Devices.Switches
+	case SourceAP:
+		return cfg.Devices.APs
+	case SourceGateway:
+		return cfg.Devices.Gateways
+	case SourcePDU:
+		return cfg.Devices.PDUs
+	}
+	return DeviceConfig{}
+}
+
+// hostDomains returns the domains that the names of a host go in. The first
+// domain rule that matches the host comes first, then the domains of its
+// device family, its site and its controller.
+func hostDomains(host *Hostmap, cfg *TomlConfig) []string {
+	if domains := ruleDomains(host, cfg); len(domains) > 0 {
+		return domains
+	}
+	if domains := deviceConfig(host.source, cfg).Domains; len(domains) > 0 {
+		return domains
+	}
+	if controller := controllerConfig(host.controller, cfg); controller != nil && host.source != SourceStatic {
+		if domains := siteDomains(host.site, controller); len(domains) > 0 {
+			return domains
+		}
+		if len(controller.Domains) > 0 {
+			return controller.Domains
+		}
+	}
+	return cfg.Processing.Domains
+}
+
+// allDomains lists every domain that the scraper puts names in, starting
+// with Processing.Domains, then the domains of the domain rules, the
+// controllers and their sites, and the device families
+func allDomains(cfg *TomlConfig) []string {
+	seen := make(map[string]bool)
+	var domains []string
+	add := func(list []string) {
+		for _, domain := range list {
+			key := strings.ToLower(strings.Trim(domain, "."))
+			if key != "" && !seen[key] {
+				seen[key] = true
+				domains = append(domains, domain)
+			}
+		}
+	}
+
+	add(cfg.Processing.Domains)
+	for _, rule := range cfg.Processing.DomainRules {
+		add(rule.Domains)
+	}
+	for i := range cfg.Unifi {
+		add(cfg.Unifi[i].Domains)
+		add(siteDomainList(&cfg.Unifi[i]))
+	}
+	for _, family := range []DeviceConfig{cfg.Devices.Switches, cfg.Devices.APs, cfg.Devices.Gateways, cfg.Devices.PDUs} {
+		add(family.Domains)
+	}
+	return domains
+}
+
+// deviceHostmaps creates a host for each address of a device. Interfaces
+// that share an address with the device add their names to that host.
+func deviceHostmaps(device unifiDevice, cfg *TomlConfig, now time.Time) []*Hostmap {
+	prefix := deviceConfig(device.source, cfg).Prefix
+	name := prefix + device.name
+
+	var hostmaps []*Hostmap
+	byIP := make(map[netip.Addr]*Hostmap)
+	add := func(address string, hostname string, iface string) {
+		ip, err := netip.ParseAddr(address)
+		if err != nil {
+			logger.Warnf("unable to parse IP address: %s", address)
+			return
+		}
+		if m, ok := byIP[ip]; ok {
+			m.hostnames = append(m.hostnames, hostname)
+			return
+		}
+		m := &Hostmap{
+			ip:         ip,
+			hostnames:  []string{hostname},
+			lastseen:   now,
+			source:     device.source,
+			mac:        strings.ToLower(device.mac),
+			site:       device.site,
+			wired:      device.wired,
+			controller: device.controller,
+			iface:      iface,
+		}
+		// without a time from the controller, expiry goes by the scrape
+		if device.lastSeen > 0 {
+			m.lastseenUnifi = time.Unix(device.lastSeen, 0)
+		}
+		byIP[ip] = m
+		hostmaps = append(hostmaps, m)
+	}
+
+	add(device.ip, name, "")
+	for _, iface := range device.interfaces {
+		add(iface.ip, fmt.Sprintf("%s-%s", name, iface.suffix), iface.suffix)
+	}
+
+	for _, m := range hostmaps {
+		addDomainsToHostmap(m, hostDomains(m, cfg))
+	}
+	return hostmaps
+}
@@ -0,0 +1,93 @@
+package scraper
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/unpoller/unifi"
+	"github.com/withmandala/go-log"
+)
+
+// TestDeviceHostmaps tests that every device family gets names, with the
+// prefix and domains of its family and a name for each gateway interface
+func TestDeviceHostmaps(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	lastSeen := unifi.FlexInt{Val: float64(time.Now().Unix())}
+	devices := &unifi.Devices{
+		USWs: []*unifi.USW{{Name: "core", IP: "192.168.1.2", Mac: "AA:00:00:00:00:02", LastSeen: lastSeen}},
+		UDMs: []*unifi.UDM{{
+			Name:     "udm",
+			IP:       "203.0.113.10",
+			Mac:      "AA:00:00:00:00:01",
+			LastSeen: lastSeen,
+			Wan1:     unifi.Wan{IP: "203.0.113.10"},
+			Wan2:     unifi.Wan{IP: "198.51.100.7"},
+			NetworkTable: unifi.NetworkTable{
+				{Name: "Default", IP: "192.168.1.1"},
+				{Name: "IoT Devices", IP: "192.168.20.1"},
+				{Name: "WAN", IP: ""},
+			},
+		}},
+		PDUs: []*unifi.PDU{{Name: "rack-pdu", IP: "192.168.1.5", LastSeen: lastSeen}},
+	}
+
+	on := true
+	cfg := &TomlConfig{
+		Processing: ProcessingConfig{Domains: []string{"example.com"}},
+		Devices: DevicesConfig{
+			Switches:          DeviceConfig{Prefix: "sw-"},
+			Gateways:          DeviceConfig{Domains: []string{"infra.example.com"}},
+			GatewayInterfaces: &on,
+		},
+	}
+
+	now := time.Now()
+	var hostmaps []*Hostmap
+	for _, device := range collectDevices(devices, cfg) {
+		hostmaps = append(hostmaps, deviceHostmaps(device, cfg, now)...)
+	}
+
+	want := map[string]string{
+		"192.168.1.2":  "sw-core.example.com",
+		"203.0.113.10": "udm.infra.example.com udm-wan1.infra.example.com",
+		"198.51.100.7": "udm-wan2.infra.example.com",
+		"192.168.1.1":  "udm-default.infra.example.com",
+		"192.168.20.1": "udm-iot-devices.infra.example.com",
+		"192.168.1.5":  "rack-pdu.example.com",
+	}
+	if len(hostmaps) != len(want) {
+		t.Fatalf("Expected %d hosts, got %d", len(want), len(hostmaps))
+	}
+	for _, hm := range hostmaps {
+		if got := strings.Join(hm.fqdns, " "); got != want[hm.ip.String()] {
+			t.Errorf("Host %s has names %q, want %q", hm.ip, got, want[hm.ip.String()])
+		}
+		if !hm.wired || !hm.lastseen.Equal(now) {
+			t.Errorf("Host %s should be wired and seen now", hm.ip)
+		}
+	}
+	for i, hm := range hostmaps[1:5] {
+		if hm.source != SourceGateway || hm.mac != "aa:00:00:00:00:01" {
+			t.Errorf("Host %s should be the gateway, got %s %s", hm.ip, hm.source, hm.mac)
+		}
+		// only the address of the gateway itself isn't an interface
+		if (i == 0) != (hm.iface == "") {
+			t.Errorf("Host %s has interface %q", hm.ip, hm.iface)
+		}
+	}
+
+	domains := strings.Join(allDomains(cfg), " ")
+	if domains != "example.com infra.example.com" {
+		t.Errorf("allDomains() = %q", domains)
+	}
+
+	cfg.Devices.GatewayInterfaces = nil
+	if interfaces := collectDevices(devices, cfg)[1].interfaces; len(interfaces) != 0 {
+		t.Errorf("Expected no gateway interfaces by default, got %v", interfaces)
+	}
+}
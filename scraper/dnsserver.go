@@ -0,0 +1,254 @@
+package scraper
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// defaultDNSTTL is used for answers when no TTL is configured
+const defaultDNSTTL = 300
+
+// maxCNAMEChain limits how many CNAMEs are followed within our own data
+const maxCNAMEChain = 8
+
+// DNSServer is a small authoritative DNS server that answers for the
+// configured domains, and optionally their reverse zones, straight from the
+// hostmap. Queries for anything else are refused.
+type DNSServer struct {
+	data atomic.Pointer[dnsZoneData]
+	udp  *dns.Server
+	tcp  *dns.Server
+	// listen is the address Start was called with
+	listen string
+
+	// serial and records remember the last update so the SOA serial only
+	// changes when the data does
+	mu      sync.Mutex
+	serial  uint32
+	records []dnsRecord
+}
+
+// dnsZoneData is an immutable snapshot of everything the server answers with.
+// A new snapshot is swapped in after each scrape so queries never see a half
+// updated hostmap.
+type dnsZoneData struct {
+	// zones are sorted longest first so the most specific zone wins
+	zones   []string
+	soa     map[string]dns.RR
+	ns      map[string][]dns.RR
+	records map[string][]dns.RR
+	// names holds every name that exists, including the empty non-terminals
+	// between a zone apex and its records
+	names map[string]bool
+}
+
+// NewDNSServer creates a DNS server with the records of the hostmap
+func NewDNSServer(hostmaps []*Hostmap, cfg *TomlConfig) *DNSServer {
+	s := &DNSServer{}
+	s.Update(hostmaps, cfg)
+	return s
+}
+
+// Update replaces the data the server answers with
+func (s *DNSServer) Update(hostmaps []*Hostmap, cfg *TomlConfig) {
+	ttl := cfg.DNS.TTL
+	if ttl == 0 {
+		ttl = defaultDNSTTL
+	}
+	records := collectRecords(hostmaps, cfg, cfg.DNS.ReverseZones)
+
+	s.mu.Lock()
+	if s.serial == 0 || !reflect.DeepEqual(records, s.records) {
+		s.serial = nextSerial(s.serial, "date", time.Now())
+		s.records = records
+	}
+	serial := s.serial
+	s.mu.Unlock()
+
+	data := &dnsZoneData{
+		soa:     make(map[string]dns.RR),
+		ns:      make(map[string][]dns.RR),
+		records: make(map[string][]dns.RR),
+		names:   make(map[string]bool),
+	}
+
+	data.zones = recordZones(hostmaps, cfg, cfg.DNS.ReverseZones)
+	for _, zone := range data.zones {
+		data.names[zone] = true
+
+		content := soaContent(zone, serial, cfg.DNS.Nameservers, cfg.DNS.Hostmaster)
+		soa, err := dns.NewRR(fmt.Sprintf("%s. %d IN SOA %s", zone, ttl, content))
+		if err != nil {
+			logger.Errorf("Unable to create SOA record for %s: %s", zone, err)
+			continue
+		}
+		data.soa[zone] = soa
+		for _, nameserver := range nameservers(cfg.DNS.Nameservers) {
+			data.ns[zone] = append(data.ns[zone], &dns.NS{
+				Hdr: dns.RR_Header{Name: dns.Fqdn(zone), Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: ttl},
+				Ns:  dns.Fqdn(nameserver),
+			})
+		}
+	}
+
+	for _, record := range records {
+		zone := zoneFor(record.Name, data.zones)
+		if zone == "" {
+			continue
+		}
+		rr, err := record.toRR(ttl)
+		if err != nil {
+			logger.Warnf("Skipping DNS record: %s", err)
+			continue
+		}
+		data.records[record.Name] = append(data.records[record.Name], rr)
+
+		// mark the name and everything between it and the zone apex as
+		// existing so those get NODATA rather than NXDOMAIN
+		for name := record.Name; name != zone && strings.HasSuffix(name, "."+zone); {
+			data.names[name] = true
+			name = name[strings.Index(name, ".")+1:]
+		}
+	}
+
+	s.data.Store(data)
+}
+
+func (s *DNSServer) Name() string { return "dns" }
+
+// Save gives the server the new hostmap to answer with, see Update
+func (s *DNSServer) Save(hostmaps []*Hostmap, cfg *TomlConfig) error {
+	s.Update(hostmaps, cfg)
+	return nil
+}
+
+// Close stops the server, see Shutdown
+func (s *DNSServer) Close() error {
+	return s.Shutdown()
+}
+
+// Start listens on the configured address over UDP and TCP and serves
+// queries in the background until Shutdown is called
+func (s *DNSServer) Start(listen string) error {
+	pc, err := net.ListenPacket("udp", listen)
+	if err != nil {
+		return err
+	}
+	// use the same port for TCP, which matters when listening on port 0
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		pc.Close()
+		return err
+	}
+
+	// wait until both are serving, as they can't be shut down before that
+	var started sync.WaitGroup
+	started.Add(2)
+	s.listen = listen
+	s.udp = &dns.Server{PacketConn: pc, Handler: s, NotifyStartedFunc: started.Done}
+	s.tcp = &dns.Server{Listener: l, Handler: s, NotifyStartedFunc: started.Done}
+	for _, server := range []*dns.Server{s.udp, s.tcp} {
+		go func(server *dns.Server) {
+			if err := server.ActivateAndServe(); err != nil {
+				logger.Errorf("DNS server stopped: %s", err)
+			}
+		}(server)
+	}
+	started.Wait()
+	logger.Infof("DNS server listening on %s", pc.LocalAddr())
+	return nil
+}
+
+// Addr returns the address the server is listening on
+func (s *DNSServer) Addr() string {
+	if s.udp == nil || s.udp.PacketConn == nil {
+		return ""
+	}
+	return s.udp.PacketConn.LocalAddr().String()
+}
+
+// Shutdown stops the listeners started by Start
+func (s *DNSServer) Shutdown() error {
+	var firstErr error
+	for _, server := range []*dns.Server{s.udp, s.tcp} {
+		if server == nil {
+			continue
+		}
+		if err := server.Shutdown(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// ServeDNS answers a single query
+func (s *DNSServer) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+
+	data := s.data.Load()
+	if data == nil || len(r.Question) != 1 || r.Question[0].Qclass != dns.ClassINET {
+		m.Rcode = dns.RcodeRefused
+		w.WriteMsg(m)
+		return
+	}
+
+	q := r.Question[0]
+	name := strings.ToLower(strings.TrimSuffix(q.Name, "."))
+	zone := zoneFor(name, data.zones)
+	if zone == "" {
+		m.Rcode = dns.RcodeRefused
+		w.WriteMsg(m)
+		return
+	}
+
+	m.Authoritative = true
+	m.Answer = data.answer(name, q.Qtype, 0)
+	if len(m.Answer) == 0 {
+		if !data.names[name] {
+			m.Rcode = dns.RcodeNameError
+		}
+		if soa, ok := data.soa[zone]; ok {
+			m.Ns = []dns.RR{soa}
+		}
+	}
+	w.WriteMsg(m)
+}
+
+// answer finds the records for a name, following CNAMEs that point to other
+// names in our own data
+func (d *dnsZoneData) answer(name string, qtype uint16, depth int) []dns.RR {
+	var rrs []dns.RR
+	if soa, ok := d.soa[name]; ok && (qtype == dns.TypeSOA || qtype == dns.TypeANY) {
+		rrs = append(rrs, soa)
+	}
+	if qtype == dns.TypeNS || qtype == dns.TypeANY {
+		rrs = append(rrs, d.ns[name]...)
+	}
+
+	var cname dns.RR
+	for _, rr := range d.records[name] {
+		if rr.Header().Rrtype == qtype || qtype == dns.TypeANY {
+			rrs = append(rrs, rr)
+		}
+		if rr.Header().Rrtype == dns.TypeCNAME {
+			cname = rr
+		}
+	}
+
+	if len(rrs) == 0 && cname != nil {
+		rrs = append(rrs, cname)
+		target := strings.TrimSuffix(cname.(*dns.CNAME).Target, ".")
+		if depth < maxCNAMEChain && zoneFor(target, d.zones) != "" {
+			rrs = append(rrs, d.answer(target, qtype, depth+1)...)
+		}
+	}
+	return rrs
+}
@@ -0,0 +1,144 @@
+package scraper
+
+import (
+	"net/netip"
+	"os"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/withmandala/go-log"
+)
+
+// TestDNSServer tests the answers of the built-in DNS server over UDP and TCP
+func TestDNSServer(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	cfg := &TomlConfig{
+		Processing: ProcessingConfig{
+			Domains:     []string{"example.com"},
+			IPv6Domains: []string{"example.com"},
+			Cnames: []struct {
+				Cname    string
+				Hostname string
+			}{
+				{Cname: "www.example.com", Hostname: "host1.example.com"},
+			},
+		},
+		DNS: DNSConfig{
+			TTL:          60,
+			ReverseZones: true,
+			Nameservers:  []string{"ns1.example.com"},
+		},
+	}
+
+	hm := &Hostmap{
+		ip:        createIP("192.168.1.10"),
+		ipv6:      []netip.Addr{createIP("2001:db8::10")},
+		hostnames: []string{"host1"},
+	}
+	addDomainsToHostmap(hm, cfg.Processing.Domains)
+	gone := &Hostmap{
+		ip:          createIP("192.168.1.11"),
+		hostnames:   []string{"gone"},
+		removalCode: Old,
+	}
+	addDomainsToHostmap(gone, cfg.Processing.Domains)
+
+	server := NewDNSServer([]*Hostmap{hm, gone}, cfg)
+	if err := server.Start("127.0.0.1:0"); err != nil {
+		t.Fatalf("Failed to start DNS server: %v", err)
+	}
+	defer server.Shutdown()
+
+	query := func(t *testing.T, net string, name string, qtype uint16) *dns.Msg {
+		t.Helper()
+		c := &dns.Client{Net: net}
+		m := new(dns.Msg)
+		m.SetQuestion(dns.Fqdn(name), qtype)
+		r, _, err := c.Exchange(m, server.Addr())
+		if err != nil {
+			t.Fatalf("Query for %s failed: %v", name, err)
+		}
+		return r
+	}
+
+	tests := []struct {
+		name      string
+		qname     string
+		qtype     uint16
+		wantRcode int
+		wantTypes []uint16
+	}{
+		{"A record", "host1.example.com", dns.TypeA, dns.RcodeSuccess, []uint16{dns.TypeA}},
+		{"AAAA record", "HOST1.example.com", dns.TypeAAAA, dns.RcodeSuccess, []uint16{dns.TypeAAAA}},
+		{"CNAME is followed", "www.example.com", dns.TypeA, dns.RcodeSuccess, []uint16{dns.TypeCNAME, dns.TypeA}},
+		{"PTR record", "10.1.168.192.in-addr.arpa", dns.TypePTR, dns.RcodeSuccess, []uint16{dns.TypePTR}},
+		{"IPv6 PTR record", reverseName(createIP("2001:db8::10")), dns.TypePTR, dns.RcodeSuccess, []uint16{dns.TypePTR}},
+		{"SOA at the apex", "example.com", dns.TypeSOA, dns.RcodeSuccess, []uint16{dns.TypeSOA}},
+		{"NS at the apex", "example.com", dns.TypeNS, dns.RcodeSuccess, []uint16{dns.TypeNS}},
+		{"no data for other types", "host1.example.com", dns.TypeMX, dns.RcodeSuccess, nil},
+		{"removed host", "gone.example.com", dns.TypeA, dns.RcodeNameError, nil},
+		{"unknown host", "missing.example.com", dns.TypeA, dns.RcodeNameError, nil},
+		{"other domain", "example.org", dns.TypeA, dns.RcodeRefused, nil},
+	}
+
+	for _, proto := range []string{"udp", "tcp"} {
+		for _, tt := range tests {
+			t.Run(proto+" "+tt.name, func(t *testing.T) {
+				r := query(t, proto, tt.qname, tt.qtype)
+				if r.Rcode != tt.wantRcode {
+					t.Fatalf("Expected rcode %s, got %s", dns.RcodeToString[tt.wantRcode], dns.RcodeToString[r.Rcode])
+				}
+				if len(r.Answer) != len(tt.wantTypes) {
+					t.Fatalf("Expected %d answers, got %d: %v", len(tt.wantTypes), len(r.Answer), r.Answer)
+				}
+				for i, rr := range r.Answer {
+					if rr.Header().Rrtype != tt.wantTypes[i] {
+						t.Errorf("Expected answer %d to be %s, got %s", i, dns.TypeToString[tt.wantTypes[i]], rr)
+					}
+					if rr.Header().Ttl != 60 {
+						t.Errorf("Expected TTL 60, got %d", rr.Header().Ttl)
+					}
+				}
+				if tt.wantRcode != dns.RcodeRefused && !r.Authoritative {
+					t.Errorf("Expected an authoritative answer")
+				}
+				if tt.wantRcode == dns.RcodeNameError && (len(r.Ns) != 1 || r.Ns[0].Header().Rrtype != dns.TypeSOA) {
+					t.Errorf("Expected an SOA record in the authority section, got %v", r.Ns)
+				}
+			})
+		}
+	}
+
+	// the PTR and SOA records point at the right names
+	r := query(t, "udp", "10.1.168.192.in-addr.arpa", dns.TypePTR)
+	if ptr := r.Answer[0].(*dns.PTR); ptr.Ptr != "host1.example.com." {
+		t.Errorf("Expected PTR to host1.example.com., got %s", ptr.Ptr)
+	}
+	r = query(t, "udp", "example.com", dns.TypeSOA)
+	soa := r.Answer[0].(*dns.SOA)
+	if soa.Ns != "ns1.example.com." || soa.Mbox != "hostmaster.example.com." {
+		t.Errorf("Unexpected SOA record: %s", soa)
+	}
+
+	// an update without changes keeps the serial, a changed hostmap is
+	// served after the update and bumps it
+	server.Update([]*Hostmap{hm, gone}, cfg)
+	r = query(t, "udp", "example.com", dns.TypeSOA)
+	if got := r.Answer[0].(*dns.SOA).Serial; got != soa.Serial {
+		t.Errorf("Expected serial to stay at %d, got %d", soa.Serial, got)
+	}
+
+	gone.removalCode = NotRemoved
+	server.Update([]*Hostmap{hm, gone}, cfg)
+	r = query(t, "udp", "gone.example.com", dns.TypeA)
+	if len(r.Answer) != 1 || r.Answer[0].(*dns.A).A.String() != "192.168.1.11" {
+		t.Errorf("Expected updated A record for gone.example.com, got %v", r.Answer)
+	}
+	r = query(t, "udp", "example.com", dns.TypeSOA)
+	if got := r.Answer[0].(*dns.SOA).Serial; got <= soa.Serial {
+		t.Errorf("Expected serial to increase from %d, got %d", soa.Serial, got)
+	}
+}
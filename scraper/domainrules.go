@@ -0,0 +1,66 @@
+package scraper
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// hasConditions checks if a domain rule has anything to match on
+func (r DomainRule) hasConditions() bool {
+	return r.Network != "" || r.VLAN != 0 || r.Subnet != "" || r.SSID != "" || r.Wired != nil
+}
+
+// matches checks if a host meets every condition of a domain rule
+func (r DomainRule) matches(host *Hostmap) bool {
+	if !r.hasConditions() {
+		return false
+	}
+	if r.Network != "" && !strings.EqualFold(r.Network, host.network) {
+		return false
+	}
+	if r.VLAN != 0 && r.VLAN != host.vlan {
+		return false
+	}
+	if r.SSID != "" && r.SSID != host.ssid {
+		return false
+	}
+	// hosts from Additional are neither wired nor wireless
+	if r.Wired != nil && (host.source == SourceStatic || *r.Wired != host.wired) {
+		return false
+	}
+	if r.Subnet != "" {
+		prefix, err := netip.ParsePrefix(r.Subnet)
+		if err != nil || !prefix.Contains(host.ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkDomainRules warns about domain rules that can never match
+func checkDomainRules(cfg *TomlConfig) {
+	for i, rule := range cfg.Processing.DomainRules {
+		if !rule.hasConditions() {
+			logger.Warnf("domain rule %d has no network, vlan, subnet, ssid or wired setting and is ignored", i+1)
+		}
+		if rule.Subnet != "" {
+			if _, err := netip.ParsePrefix(rule.Subnet); err != nil {
+				logger.Warnf("domain rule %d has an invalid subnet: %s", i+1, rule.Subnet)
+			}
+		}
+		if len(rule.Domains) == 0 {
+			logger.Warnf("domain rule %d has no domains", i+1)
+		}
+	}
+}
+
+// ruleDomains returns the domains of the first domain rule that matches a
+// host, if any
+func ruleDomains(host *Hostmap, cfg *TomlConfig) []string {
+	for _, rule := range cfg.Processing.DomainRules {
+		if len(rule.Domains) > 0 && rule.matches(host) {
+			return rule.Domains
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package scraper
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/naoina/toml"
+	"github.com/unpoller/unifi"
+	"github.com/withmandala/go-log"
+)
+
+// TestDomainRules tests that clients get the domains of the first rule that
+// matches their network, VLAN, subnet or SSID
+func TestDomainRules(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	input := `
+[processing]
+domains = ["home.local"]
+
+[[processing.domain_rules]]
+vlan = 20
+domains = ["iot.home.local"]
+
+[[processing.domain_rules]]
+subnet = "192.168.10.0/24"
+wired = true
+domains = ["srv.home.local"]
+
+[[processing.domain_rules]]
+ssid = "Guests"
+domains = ["guest.home.local"]
+
+[[processing.domain_rules]]
+network = "Lab"
+domains = ["lab.home.local"]
+`
+	var cfg TomlConfig
+	if err := toml.NewDecoder(strings.NewReader(input)).Decode(&cfg); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	lastSeen := unifi.FlexInt{Val: float64(time.Now().Unix())}
+	clients := []*unifi.Client{
+		{Name: "bulb", IP: "192.168.20.5", Mac: "aa:00:00:00:00:01", Vlan: unifi.FlexInt{Val: 20}, LastSeen: lastSeen},
+		{Name: "nas", IP: "192.168.10.5", Mac: "aa:00:00:00:00:02", IsWired: unifi.FlexBool{Val: true}, LastSeen: lastSeen},
+		{Name: "tablet", IP: "192.168.10.6", Mac: "aa:00:00:00:00:03", Essid: "Guests", LastSeen: lastSeen},
+		{Name: "scope", IP: "192.168.30.5", Mac: "aa:00:00:00:00:04", Network: "lab", IsWired: unifi.FlexBool{Val: true}, LastSeen: lastSeen},
+		{Name: "laptop", IP: "192.168.1.5", Mac: "aa:00:00:00:00:05", LastSeen: lastSeen},
+	}
+	cfg.Processing.Additional = append(cfg.Processing.Additional, struct {
+		IP           string
+		Hostnames    []string
+		Name         string
+		KeepMultiple *bool
+	}{IP: "192.168.10.1", Name: "router"})
+
+	want := map[string]string{
+		"192.168.20.5": "bulb.iot.home.local",
+		"192.168.10.5": "nas.srv.home.local",
+		"192.168.10.6": "tablet.guest.home.local",
+		"192.168.30.5": "scope.lab.home.local",
+		"192.168.1.5":  "laptop.home.local",
+		"192.168.10.1": "router.home.local",
+	}
+
+	hostmaps := createHostmap([]controllerScrape{{clients: clients}}, &cfg, nil)
+	if len(hostmaps) != len(want) {
+		t.Fatalf("Expected %d hosts, got %d", len(want), len(hostmaps))
+	}
+	for _, hm := range hostmaps {
+		if got := strings.Join(hm.fqdns, " "); got != want[hm.ip.String()] {
+			t.Errorf("Host %s has names %q, want %q", hm.ip, got, want[hm.ip.String()])
+		}
+	}
+
+	domains := strings.Join(allDomains(&cfg), " ")
+	if domains != "home.local iot.home.local srv.home.local guest.home.local lab.home.local" {
+		t.Errorf("allDomains() = %q", domains)
+	}
+}
@@ -0,0 +1,128 @@
+package scraper
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration that can be given in the configuration either
+// as an integer number of seconds or as a string like "90m" or "36h". Days
+// can be given with a "d" suffix, such as "7d".
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalTOML reads an integer number of seconds or a duration string
+func (d *Duration) UnmarshalTOML(decode func(interface{}) error) error {
+	var seconds int64
+	if err := decode(&seconds); err == nil {
+		d.Duration = time.Duration(seconds) * time.Second
+		return nil
+	}
+
+	var value string
+	if err := decode(&value); err != nil {
+		return fmt.Errorf("duration must be a number of seconds or a string like \"36h\"")
+	}
+	duration, err := parseDuration(value)
+	if err != nil {
+		return err
+	}
+	d.Duration = duration
+	return nil
+}
+
+// parseDuration extends time.ParseDuration with a "d" suffix for days
+func parseDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration: %s", value)
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	return time.ParseDuration(value)
+}
+
+// retention returns how long a host is kept after it was last seen, which
+// is the setting for its source in the [expiry] block or MaxAge otherwise.
+// Zero means the host never expires.
+func retention(host *Hostmap, cfg *TomlConfig) time.Duration {
+	var perSource Duration
+	switch host.source {
+	case SourceClient:
+		perSource = cfg.Expiry.Clients
+	case SourceSwitch:
+		perSource = cfg.Expiry.Switches
+	case SourceAP:
+		perSource = cfg.Expiry.APs
+	case SourceGateway:
+		perSource = cfg.Expiry.Gateways
+	case SourcePDU:
+		perSource = cfg.Expiry.PDUs
+	case SourceStatic:
+		perSource = cfg.Expiry.Static
+	}
+	if perSource.Duration > 0 {
+		return perSource.Duration
+	}
+	return cfg.MaxAge.Duration
+}
+
+// removeExpiredHosts marks hosts as Old once they have not been seen by the
+// controller for longer than their retention. Hosts that the controller still
+// reports in the scrape at now, but that are offline, get the grace period on
+// top of that. Static hosts and hosts without a time from the controller use
+// the last time the scraper saw them instead.
+func removeExpiredHosts(m []*Hostmap, cfg *TomlConfig, now time.Time) []*Hostmap {
+	removed_hosts := 0
+	for _, host := range m {
+		if host.removalCode != NotRemoved {
+			continue
+		}
+
+		maxAge := retention(host, cfg)
+		if maxAge <= 0 {
+			continue
+		}
+
+		seen := host.lastseenUnifi
+		if seen.IsZero() {
+			seen = host.lastseen
+		}
+		if !host.lastseen.Before(now) {
+			maxAge += cfg.Expiry.Grace.Duration
+		}
+
+		if now.Sub(seen) > maxAge {
+			host.removalCode = Old
+			removed_hosts++
+		}
+	}
+
+	logger.Infof("Removed %d old hosts", removed_hosts)
+	return m
+}
+
+// removeUnconfiguredHosts drops the static hosts carried over from earlier
+// scrapes whose entry in Additional is gone. Hosts with a retention are left
+// for removeExpiredHosts, the others would never expire.
+func removeUnconfiguredHosts(m []*Hostmap, static []*Hostmap, cfg *TomlConfig) []*Hostmap {
+	configured := make(map[string]bool, len(static))
+	for _, host := range static {
+		configured[hostIdentity(host)] = true
+	}
+
+	newhosts := make([]*Hostmap, 0, len(m))
+	for _, host := range m {
+		if host.source == SourceStatic && !configured[hostIdentity(host)] && retention(host, cfg) <= 0 {
+			logger.Infof("Removing %s, it is no longer in the configuration", describeHost(host))
+			continue
+		}
+		newhosts = append(newhosts, host)
+	}
+	return newhosts
+}
@@ -0,0 +1,200 @@
+package scraper
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultMaxFailures is how many scrapes in a row may fail before the
+// scraper is unhealthy
+const defaultMaxFailures = 5
+
+// HealthState sums up how the scrapes have been going
+type HealthState string
+
+const (
+	// HealthStarting is the state before the first scrape has finished
+	HealthStarting HealthState = "starting"
+	// HealthOK means the last scrape worked
+	HealthOK HealthState = "ok"
+	// HealthDegraded means the last scrapes failed and the last known hosts
+	// are still being served
+	HealthDegraded HealthState = "degraded"
+	// HealthUnhealthy means too many scrapes in a row failed
+	HealthUnhealthy HealthState = "unhealthy"
+)
+
+// maxFailures returns MaxFailures or its default
+func (c *HealthConfig) maxFailures() int {
+	if c.MaxFailures > 0 {
+		return c.MaxFailures
+	}
+	return defaultMaxFailures
+}
+
+// ExitWhenUnhealthy tells if the scraper should stop once it is unhealthy,
+// which it only does when Exit is turned on. Otherwise it keeps serving the
+// last known hosts and leaves restarting it to a liveness probe.
+func (c *HealthConfig) ExitWhenUnhealthy() bool {
+	return c.Exit != nil && *c.Exit
+}
+
+// Health keeps track of the scrapes in daemon mode and serves the result to
+// container orchestrators over HTTP: /healthz fails once the scraper is
+// unhealthy and /readyz once there are hosts to serve.
+type Health struct {
+	mu          sync.Mutex
+	maxFailures int
+	failures    int
+	hosts       int
+	lastSuccess time.Time
+	lastError   string
+
+	server   *http.Server
+	listener net.Listener
+}
+
+// NewHealth creates the health state for a configuration
+func NewHealth(cfg *HealthConfig) *Health {
+	return &Health{maxFailures: cfg.maxFailures()}
+}
+
+// Success records a scrape that worked and the number of hosts it found
+func (h *Health) Success(hosts int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failures = 0
+	h.hosts = hosts
+	h.lastSuccess = time.Now()
+	h.lastError = ""
+}
+
+// Failure records a failed scrape, along with the number of hosts that are
+// still being served, and returns the new state
+func (h *Health) Failure(err error, hosts int) HealthState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failures++
+	h.hosts = hosts
+	h.lastError = err.Error()
+	return h.state()
+}
+
+// Failures returns the number of scrapes in a row that failed
+func (h *Health) Failures() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.failures
+}
+
+// State returns the current state
+func (h *Health) State() HealthState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.state()
+}
+
+func (h *Health) state() HealthState {
+	switch {
+	case h.failures >= h.maxFailures:
+		return HealthUnhealthy
+	case h.failures > 0:
+		return HealthDegraded
+	case h.lastSuccess.IsZero():
+		return HealthStarting
+	}
+	return HealthOK
+}
+
+// NextDelay is how long to sleep before the next scrape. After a failure
+// this starts short and backs off until it reaches the normal sleep.
+func (h *Health) NextDelay(sleep time.Duration) time.Duration {
+	failures := h.Failures()
+	if failures == 0 {
+		return sleep
+	}
+	return min(sleep, backoffDelay(failures))
+}
+
+// healthStatus is the JSON the health endpoints answer with
+type healthStatus struct {
+	Status      HealthState `json:"status"`
+	Failures    int         `json:"failures"`
+	MaxFailures int         `json:"max_failures"`
+	Hosts       int         `json:"hosts"`
+	LastSuccess *time.Time  `json:"last_success,omitempty"`
+	LastError   string      `json:"last_error,omitempty"`
+}
+
+// ServeHTTP answers /healthz and /readyz
+func (h *Health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	status := healthStatus{
+		Status:      h.state(),
+		Failures:    h.failures,
+		MaxFailures: h.maxFailures,
+		Hosts:       h.hosts,
+		LastError:   h.lastError,
+	}
+	if !h.lastSuccess.IsZero() {
+		lastSuccess := h.lastSuccess
+		status.LastSuccess = &lastSuccess
+	}
+	h.mu.Unlock()
+
+	code := http.StatusOK
+	switch r.URL.Path {
+	case "/healthz":
+		if status.Status == HealthUnhealthy {
+			code = http.StatusServiceUnavailable
+		}
+	case "/readyz":
+		if status.LastSuccess == nil && status.Hosts == 0 {
+			code = http.StatusServiceUnavailable
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
+
+// Start serves the health endpoints on the given address in the background
+// until Close is called
+func (h *Health) Start(listen string) error {
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	h.listener = l
+	h.server = &http.Server{Handler: h, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := h.server.Serve(l); err != nil && err != http.ErrServerClosed {
+			logger.Errorf("Health server stopped: %s", err)
+		}
+	}()
+	logger.Infof("Health endpoints listening on %s", l.Addr())
+	return nil
+}
+
+// Addr returns the address the health endpoints are listening on
+func (h *Health) Addr() string {
+	if h.listener == nil {
+		return ""
+	}
+	return h.listener.Addr().String()
+}
+
+// Close stops serving the health endpoints
+func (h *Health) Close() error {
+	if h.server == nil {
+		return nil
+	}
+	return h.server.Close()
+}
@@ -0,0 +1,101 @@
+package scraper
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/withmandala/go-log"
+)
+
+func TestHealthStates(t *testing.T) {
+	health := NewHealth(&HealthConfig{MaxFailures: 3})
+	if state := health.State(); state != HealthStarting {
+		t.Errorf("State() = %s, want %s", state, HealthStarting)
+	}
+
+	health.Success(10)
+	if state := health.State(); state != HealthOK {
+		t.Errorf("State() = %s, want %s", state, HealthOK)
+	}
+
+	want := []HealthState{HealthDegraded, HealthDegraded, HealthUnhealthy}
+	for i, state := range want {
+		if got := health.Failure(errors.New("connection refused"), 10); got != state {
+			t.Errorf("Failure() %d = %s, want %s", i+1, got, state)
+		}
+	}
+
+	health.Success(12)
+	if state := health.State(); state != HealthOK || health.Failures() != 0 {
+		t.Errorf("State() = %s with %d failures, want %s", state, health.Failures(), HealthOK)
+	}
+
+	if NewHealth(&HealthConfig{}).maxFailures != defaultMaxFailures {
+		t.Errorf("Expected %d failures by default", defaultMaxFailures)
+	}
+	exit := true
+	if (&HealthConfig{}).ExitWhenUnhealthy() != false || (&HealthConfig{Exit: &exit}).ExitWhenUnhealthy() != true {
+		t.Errorf("Expected to exit when unhealthy only if exit is true")
+	}
+}
+
+func TestHealthNextDelay(t *testing.T) {
+	health := NewHealth(&HealthConfig{})
+	sleep := 2 * time.Minute
+
+	want := []time.Duration{sleep, 30 * time.Second, time.Minute, 2 * time.Minute, 2 * time.Minute}
+	for i, delay := range want {
+		if i > 0 {
+			health.Failure(errors.New("timeout"), 0)
+		}
+		if got := health.NextDelay(sleep); got != delay {
+			t.Errorf("NextDelay() after %d failures = %s, want %s", i, got, delay)
+		}
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	health := NewHealth(&HealthConfig{MaxFailures: 2})
+	if err := health.Start("127.0.0.1:0"); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer health.Close()
+
+	check := func(path string, wantCode int, wantStatus HealthState) {
+		t.Helper()
+		resp, err := http.Get("http://" + health.Addr() + path)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", path, err)
+		}
+		defer resp.Body.Close()
+		var status healthStatus
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			t.Fatalf("GET %s returned invalid JSON: %v", path, err)
+		}
+		if resp.StatusCode != wantCode || status.Status != wantStatus {
+			t.Errorf("GET %s = %d %s, want %d %s", path, resp.StatusCode, status.Status, wantCode, wantStatus)
+		}
+	}
+
+	check("/healthz", http.StatusOK, HealthStarting)
+	check("/readyz", http.StatusServiceUnavailable, HealthStarting)
+
+	health.Success(3)
+	check("/healthz", http.StatusOK, HealthOK)
+	check("/readyz", http.StatusOK, HealthOK)
+
+	// the last known hosts are still served while the controller is down
+	health.Failure(errors.New("connection refused"), 3)
+	check("/healthz", http.StatusOK, HealthDegraded)
+	health.Failure(errors.New("connection refused"), 3)
+	check("/healthz", http.StatusServiceUnavailable, HealthUnhealthy)
+	check("/readyz", http.StatusOK, HealthUnhealthy)
+}
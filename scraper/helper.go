@@ -26,4 +26,9 @@ func (h *Hostmap) GetHostnames() []string {
 	return h.hostnames
 }
 
+// GetIPv6 returns the IPv6 addresses of the Hostmap
+func (h *Hostmap) GetIPv6() []netip.Addr {
+	return h.ipv6
+}
+
 // GetRemovalCode is already defined in mock.go
@@ -22,32 +22,90 @@ func TestHostnameExclusivity(t *testing.T) {
 
 	processed := ResolveAdditionalHostConflicts(hostmaps, cfg)
 
-	// Verify that the Additional entry for "unifi" (with IP 192.168.151.1)
-	// took precedence over the UDM-discovered "unifi" at 192.168.1.1.
-	var hasConfigUnifi, hasUDMUnifi bool
+	// Hosts that lost all of their hostnames are removed
+	present := make(map[string]bool)
 	for _, hm := range processed {
+		if hm.removalCode != NotRemoved {
+			continue
+		}
 		for _, h := range hm.hostnames {
-			if h == "unifi" {
-				// Determine IP address type based on our test hosts:
-				if hm.ip.String() == "192.168.151.1" {
-					hasConfigUnifi = true
-				} else if hm.ip.String() == "192.168.1.1" {
-					hasUDMUnifi = true
-				}
-			}
+			present[h+"@"+hm.ip.String()] = true
 		}
 	}
 
-	if !hasConfigUnifi {
+	// Verify that the Additional entries for "unifi" and "server", which have
+	// KeepMultiple set to false, took precedence over the UDM-discovered hosts
+	if !present["unifi@192.168.151.1"] {
 		t.Errorf("Expected Additional entry for 'unifi' (192.168.151.1) to be present")
 	}
-	if hasUDMUnifi {
+	if present["unifi@192.168.1.1"] {
 		t.Errorf("UDM-discovered 'unifi' (192.168.1.1) should have been removed")
 	}
+	if !present["server@192.168.151.20"] || present["server@192.168.1.20"] {
+		t.Errorf("Expected only the Additional entry for 'server' to be present")
+	}
+
+	// "printer" doesn't set KeepMultiple, so both hosts keep it
+	if !present["printer@192.168.151.10"] || !present["printer@192.168.1.10"] {
+		t.Errorf("Expected both hosts for 'printer' to be present")
+	}
 
 	t.Log("TestHostnameExclusivity passed")
 }
 
+// TestHostnameExclusivityDefault tests the global KeepMultiple default and the
+// conflict report
+func TestHostnameExclusivityDefault(t *testing.T) {
+	if logger == nil {
+		logger = logm.New(os.Stderr)
+	}
+
+	cfg := testConfigForHostnameExclusivity()
+	keepTrue, keepFalse := true, false
+	cfg.Processing.KeepMultiple = &keepFalse
+	// an explicit setting still wins over the default
+	cfg.Processing.Additional[0].KeepMultiple = &keepTrue
+
+	hostmaps := testHostmapsForHostnameExclusivity()
+	hostmaps[1].hostnames = []string{"printer", "laser"}
+	hostmaps[1].mac = "aa:bb:cc:dd:ee:ff"
+	addDomainsToHostmap(hostmaps[1], cfg.Processing.Domains)
+
+	processed, conflicts := resolveAdditionalHostConflicts(hostmaps, cfg)
+
+	want := []HostnameConflict{
+		{Hostname: "printer", LostIP: mustParseIP("192.168.1.10"), LostMAC: "aa:bb:cc:dd:ee:ff", StaticIP: mustParseIP("192.168.151.10")},
+		{Hostname: "server", LostIP: mustParseIP("192.168.1.20"), StaticIP: mustParseIP("192.168.151.20")},
+	}
+	if len(conflicts) != len(want) {
+		t.Fatalf("Expected %d conflicts, got %v", len(want), conflicts)
+	}
+	for i := range want {
+		if conflicts[i] != want[i] {
+			t.Errorf("Expected conflict %v, got %v", want[i], conflicts[i])
+		}
+	}
+
+	// the printer keeps its other name, and only that name is published
+	printer := processed[1]
+	if printer.removalCode != NotRemoved || len(printer.hostnames) != 1 || printer.hostnames[0] != "laser" {
+		t.Errorf("Expected printer to keep only 'laser', got %v (removal code %d)", printer.hostnames, printer.removalCode)
+	}
+	if strings.Join(printer.fqdns, " ") != "laser.example.com laser.local" {
+		t.Errorf("Expected FQDNs to be rebuilt, got %v", printer.fqdns)
+	}
+
+	// the server lost its only name
+	if processed[2].removalCode != Blocked {
+		t.Errorf("Expected server without hostnames to be removed")
+	}
+
+	// the unifi hostname may still be used by several hosts
+	if processed[0].removalCode != NotRemoved {
+		t.Errorf("Expected 'unifi' with KeepMultiple=true to be kept")
+	}
+}
+
 // testConfigForHostnameExclusivity mimics the configuration setup in test-hostname-exclusivity.go.
 func testConfigForHostnameExclusivity() *TomlConfig {
 	var cfg TomlConfig
@@ -55,6 +113,7 @@ func testConfigForHostnameExclusivity() *TomlConfig {
 	cfg.Processing.Domains = []string{"example.com", "local"}
 
 	// Define Additional entries
+	var keepFalse = false
 	additional1 := struct {
 		IP           string
 		Hostnames    []string
@@ -63,7 +122,7 @@ func testConfigForHostnameExclusivity() *TomlConfig {
 	}{
 		IP:           "192.168.151.1",
 		Name:         "unifi",
-		KeepMultiple: nil,
+		KeepMultiple: &keepFalse,
 	}
 	additional2 := struct {
 		IP           string
@@ -75,7 +134,6 @@ func testConfigForHostnameExclusivity() *TomlConfig {
 		Name:         "printer",
 		KeepMultiple: nil,
 	}
-	var keepFalse = false
 	additional3 := struct {
 		IP           string
 		Hostnames    []string
@@ -0,0 +1,214 @@
+package scraper
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/unpoller/unifi"
+)
+
+// integrationAPIPath is where UniFi OS consoles serve the Network Integration
+// API
+const integrationAPIPath = "/proxy/network/integration/v1"
+
+// integrationPageSize is the number of entries requested at a time
+const integrationPageSize = 200
+
+// integrationClient reads sites, clients and devices from the UniFi Network
+// Integration API of a UniFi OS console. It authenticates with an API key
+// instead of a user and password, and only ever reads.
+type integrationClient struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+// newIntegrationClient creates a client for the Integration API of a
+// controller
+func newIntegrationClient(controller *UnifiConfig) (*integrationClient, error) {
+	client, err := newControllerHTTPClient(controller)
+	if err != nil {
+		return nil, err
+	}
+	return &integrationClient{
+		baseURL: strings.TrimSuffix(controller.Host, "/") + integrationAPIPath,
+		apiKey:  controller.APIKey,
+		client:  client,
+	}, nil
+}
+
+// integrationPage is the envelope around every list in the API
+type integrationPage[T any] struct {
+	Offset     int `json:"offset"`
+	Limit      int `json:"limit"`
+	Count      int `json:"count"`
+	TotalCount int `json:"totalCount"`
+	Data       []T `json:"data"`
+}
+
+type integrationSite struct {
+	ID                string `json:"id"`
+	InternalReference string `json:"internalReference"`
+	Name              string `json:"name"`
+}
+
+type integrationClientEntry struct {
+	Type        string `json:"type"`
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	ConnectedAt string `json:"connectedAt"`
+	IPAddress   string `json:"ipAddress"`
+	MacAddress  string `json:"macAddress"`
+}
+
+type integrationDevice struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Model      string   `json:"model"`
+	MacAddress string   `json:"macAddress"`
+	IPAddress  string   `json:"ipAddress"`
+	State      string   `json:"state"`
+	Features   []string `json:"features"`
+}
+
+// get fetches one page from the API
+func (c *integrationClient) get(path string, offset int, v interface{}) error {
+	query := url.Values{}
+	query.Set("offset", fmt.Sprint(offset))
+	query.Set("limit", fmt.Sprint(integrationPageSize))
+
+	req, err := http.NewRequest(http.MethodGet, c.baseURL+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-API-KEY", c.apiKey)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return tlsHint(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("GET %s returned %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// getAll fetches every page of a list from the API
+func getAll[T any](c *integrationClient, path string) ([]T, error) {
+	var all []T
+	for {
+		var page integrationPage[T]
+		if err := c.get(path, len(all), &page); err != nil {
+			return nil, err
+		}
+		all = append(all, page.Data...)
+		if len(page.Data) == 0 || len(all) >= page.TotalCount {
+			return all, nil
+		}
+	}
+}
+
+// integrationSiteName builds the SiteName the unifi library would put on the
+// clients and devices of a site
+func integrationSiteName(site *unifi.Site) string {
+	return fmt.Sprintf("%s (%s)", site.Desc, site.Name)
+}
+
+// GetSites implements UnifiClientInterface
+func (c *integrationClient) GetSites() ([]*unifi.Site, error) {
+	entries, err := getAll[integrationSite](c, "/sites")
+	if err != nil {
+		return nil, err
+	}
+
+	sites := make([]*unifi.Site, 0, len(entries))
+	for _, entry := range entries {
+		// ID holds the id the API wants in the paths of the site
+		site := &unifi.Site{ID: entry.ID, Name: entry.InternalReference, Desc: entry.Name}
+		site.SiteName = integrationSiteName(site)
+		sites = append(sites, site)
+	}
+	return sites, nil
+}
+
+// GetClients implements UnifiClientInterface. The API only lists connected
+// clients, so they are all seen now.
+func (c *integrationClient) GetClients(sites []*unifi.Site) ([]*unifi.Client, error) {
+	now := unifi.FlexInt{Val: float64(time.Now().Unix())}
+
+	var clients []*unifi.Client
+	for _, site := range sites {
+		entries, err := getAll[integrationClientEntry](c, "/sites/"+url.PathEscape(site.ID)+"/clients")
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			clients = append(clients, &unifi.Client{
+				ID:       entry.ID,
+				Name:     entry.Name,
+				Hostname: entry.Name,
+				IP:       entry.IPAddress,
+				Mac:      entry.MacAddress,
+				IsWired:  unifi.FlexBool{Val: entry.Type == "WIRED"},
+				SiteName: site.SiteName,
+				LastSeen: now,
+			})
+		}
+	}
+	return clients, nil
+}
+
+// gatewayModels are the prefixes of the models of UniFi gateways
+var gatewayModels = []string{"UDM", "UDR", "UXG", "USG", "UCG", "UDW", "UX"}
+
+// GetDevices implements UnifiClientInterface. The API doesn't say when an
+// offline device was last seen, so offline devices are left out and keep
+// their last time from earlier scrapes.
+func (c *integrationClient) GetDevices(sites []*unifi.Site) (*unifi.Devices, error) {
+	now := unifi.FlexInt{Val: float64(time.Now().Unix())}
+
+	devices := &unifi.Devices{}
+	for _, site := range sites {
+		entries, err := getAll[integrationDevice](c, "/sites/"+url.PathEscape(site.ID)+"/devices")
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !strings.EqualFold(entry.State, "ONLINE") {
+				continue
+			}
+
+			model := strings.ToUpper(entry.Model)
+			switch {
+			case slices.ContainsFunc(gatewayModels, func(prefix string) bool { return strings.HasPrefix(model, prefix) }):
+				devices.UDMs = append(devices.UDMs, &unifi.UDM{
+					Name: entry.Name, IP: entry.IPAddress, Mac: entry.MacAddress, Model: entry.Model,
+					SiteName: site.SiteName, LastSeen: now,
+				})
+			case slices.Contains(entry.Features, "accessPoint"):
+				devices.UAPs = append(devices.UAPs, &unifi.UAP{
+					Name: entry.Name, IP: entry.IPAddress, Mac: entry.MacAddress, Model: entry.Model,
+					SiteName: site.SiteName, LastSeen: now,
+				})
+			case slices.Contains(entry.Features, "switching"):
+				devices.USWs = append(devices.USWs, &unifi.USW{
+					Name: entry.Name, IP: entry.IPAddress, Mac: entry.MacAddress, Model: entry.Model,
+					SiteName: site.SiteName, LastSeen: now,
+				})
+			default:
+				logger.Debugf("Skipping device %s, unknown model %s", entry.Name, entry.Model)
+			}
+		}
+	}
+	return devices, nil
+}
@@ -0,0 +1,108 @@
+package scraper
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/withmandala/go-log"
+)
+
+// newFakeIntegrationAPI starts a fake UniFi OS console serving the Network
+// Integration API. It returns one entry per page so paging gets exercised.
+func newFakeIntegrationAPI(t *testing.T, apiKey string) *httptest.Server {
+	lists := map[string][]map[string]interface{}{
+		"/sites": {
+			{"id": "88f7af54-98f8-306a-a1c7-c9349722b1f6", "internalReference": "default", "name": "Default"},
+		},
+		"/sites/88f7af54-98f8-306a-a1c7-c9349722b1f6/clients": {
+			{"type": "WIRED", "id": "c1", "name": "nas", "ipAddress": "192.168.1.10", "macAddress": "aa:00:00:00:00:01"},
+			{"type": "WIRELESS", "id": "c2", "name": "phone", "ipAddress": "192.168.1.11", "macAddress": "aa:00:00:00:00:02"},
+		},
+		"/sites/88f7af54-98f8-306a-a1c7-c9349722b1f6/devices": {
+			{"id": "d1", "name": "core", "model": "USW-24-PoE", "macAddress": "bb:00:00:00:00:01", "ipAddress": "192.168.1.2", "state": "ONLINE", "features": []string{"switching"}},
+			{"id": "d2", "name": "hall", "model": "U6-Lite", "macAddress": "bb:00:00:00:00:02", "ipAddress": "192.168.1.3", "state": "ONLINE", "features": []string{"accessPoint"}},
+			{"id": "d3", "name": "gw", "model": "UDM-Pro", "macAddress": "bb:00:00:00:00:03", "ipAddress": "192.168.1.1", "state": "ONLINE", "features": []string{"switching"}},
+			{"id": "d4", "name": "attic", "model": "U6-Lite", "macAddress": "bb:00:00:00:00:04", "ipAddress": "192.168.1.4", "state": "OFFLINE", "features": []string{"accessPoint"}},
+		},
+	}
+
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-KEY") != apiKey {
+			http.Error(w, `{"statusCode":401,"statusName":"UNAUTHORIZED"}`, http.StatusUnauthorized)
+			return
+		}
+		list, ok := lists[strings.TrimPrefix(r.URL.Path, integrationAPIPath)]
+		if !ok || !strings.HasPrefix(r.URL.Path, integrationAPIPath) {
+			http.NotFound(w, r)
+			return
+		}
+
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		data := []map[string]interface{}{}
+		if offset < len(list) {
+			data = list[offset : offset+1]
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"offset":     offset,
+			"limit":      1,
+			"count":      len(data),
+			"totalCount": len(list),
+			"data":       data,
+		})
+	}))
+}
+
+func TestIntegrationClient(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	server := newFakeIntegrationAPI(t, "secret-key")
+	defer server.Close()
+
+	cfg := &TomlConfig{
+		Unifi:      UnifiControllers{{Host: server.URL + "/", APIKey: "secret-key", CAFile: writeServerCA(t, server)}},
+		Processing: ProcessingConfig{Domains: []string{"home.local"}},
+	}
+	client, err := newIntegrationClient(&cfg.Unifi[0])
+	if err != nil {
+		t.Fatalf("newIntegrationClient() error = %v", err)
+	}
+
+	hostmaps, err := GenerateHostsFileWithClients(cfg, nil, []UnifiClientInterface{client})
+	if err != nil {
+		t.Fatalf("GenerateHostsFileWithClients() error = %v", err)
+	}
+
+	want := map[string]string{
+		"192.168.1.10": "client nas.home.local default wired",
+		"192.168.1.11": "client phone.home.local default",
+		"192.168.1.2":  "switch core.home.local default",
+		"192.168.1.3":  "ap hall.home.local default",
+		"192.168.1.1":  "gateway gw.home.local default",
+	}
+	if len(hostmaps) != len(want) {
+		t.Fatalf("Expected %d hosts, got %d", len(want), len(hostmaps))
+	}
+	for _, hm := range hostmaps {
+		got := string(hm.source) + " " + strings.Join(hm.fqdns, " ") + " " + hm.site
+		if hm.wired && hm.source == SourceClient {
+			got += " wired"
+		}
+		if got != want[hm.ip.String()] {
+			t.Errorf("Host %s got %q, want %q", hm.ip, got, want[hm.ip.String()])
+		}
+	}
+
+	// a wrong key fails with the status of the console
+	cfg.Unifi[0].APIKey = "wrong-key"
+	client, _ = newIntegrationClient(&cfg.Unifi[0])
+	if _, err := client.GetSites(); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Expected a 401 error for a wrong key, got %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package scraper
+
+import (
+	"time"
+
+	"github.com/pridkett/unifi-dns-scraper/sqlmodel"
+	"gorm.io/gorm"
+)
+
+// SaveUnifiHosts keeps the unifi_hosts inventory table up to date with every
+// client and device the scraper knows about. Hosts are keyed by MAC address,
+// so a device keeps its history when it is renamed or changes IP address.
+// Static hosts from the configuration have no MAC address and are skipped.
+func SaveUnifiHosts(db *gorm.DB, hostmaps []*Hostmap) error {
+	// the hostmap carries entries from earlier scrapes, so only the most
+	// recently scraped entry for each MAC address is used. The interfaces of
+	// a gateway share its MAC address, but the inventory is about the device
+	// itself.
+	latest := make(map[string]*Hostmap)
+	for _, hostmap := range hostmaps {
+		if hostmap.mac == "" || hostmap.iface != "" {
+			continue
+		}
+		if current, ok := latest[hostmap.mac]; !ok || hostmap.lastseen.After(current.lastseen) {
+			latest[hostmap.mac] = hostmap
+		}
+	}
+
+	if len(latest) == 0 {
+		logger.Infof("No Unifi hosts to save to the inventory")
+		return nil
+	}
+
+	macs := make([]string, 0, len(latest))
+	for mac := range latest {
+		macs = append(macs, mac)
+	}
+
+	var existing []sqlmodel.UnifiHost
+	if err := db.Where("mac IN ?", macs).Find(&existing).Error; err != nil {
+		return err
+	}
+
+	hostMap := make(map[string]sqlmodel.UnifiHost)
+	for _, host := range existing {
+		hostMap[host.Mac] = host
+	}
+
+	var updateHosts []sqlmodel.UnifiHost
+	var newHosts []sqlmodel.UnifiHost
+	for mac, hostmap := range latest {
+		lastSeen := hostmap.lastseenUnifi
+		if lastSeen.IsZero() || lastSeen.Unix() == 0 {
+			lastSeen = hostmap.lastseen
+		}
+
+		host, ok := hostMap[mac]
+		if !ok {
+			firstSeen := hostmap.firstseenUnifi
+			if firstSeen.IsZero() {
+				firstSeen = lastSeen
+			}
+			host = sqlmodel.UnifiHost{Mac: mac, FirstSeen: firstSeen.UTC().Truncate(time.Second)}
+		} else if !hostmap.firstseenUnifi.IsZero() && hostmap.firstseenUnifi.Before(host.FirstSeen) {
+			host.FirstSeen = hostmap.firstseenUnifi.UTC().Truncate(time.Second)
+		}
+
+		host.Name = hostmap.hostnames[0]
+		host.Hostname = hostmap.unifiHostname
+		host.IP = hostmap.ip.String()
+		host.Site = hostmap.site
+		host.DeviceType = string(hostmap.source)
+		if lastSeen.After(host.LastSeen) {
+			host.LastSeen = lastSeen.UTC().Truncate(time.Second)
+		}
+
+		if ok {
+			updateHosts = append(updateHosts, host)
+		} else {
+			newHosts = append(newHosts, host)
+		}
+	}
+
+	if len(updateHosts) > 0 {
+		if err := db.Save(&updateHosts).Error; err != nil {
+			return err
+		}
+		logger.Infof("Updated %d Unifi hosts in the inventory", len(updateHosts))
+	}
+
+	if len(newHosts) > 0 {
+		if err := db.Create(&newHosts).Error; err != nil {
+			return err
+		}
+		logger.Infof("Inserted %d Unifi hosts into the inventory", len(newHosts))
+	}
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package scraper
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pridkett/unifi-dns-scraper/sqlmodel"
+	"github.com/withmandala/go-log"
+)
+
+func TestSaveUnifiHosts(t *testing.T) {
+	db, err := OpenDatabase("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open in-memory database: %v", err)
+	}
+
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	firstSeen := cTimeNow.Add(-48 * time.Hour)
+	hostmaps := []*Hostmap{
+		{
+			ip:             createIP("192.168.1.100"),
+			hostnames:      []string{"laptop"},
+			lastseen:       cTimeNow,
+			lastseenUnifi:  cTimeNow.Add(-time.Minute),
+			firstseenUnifi: firstSeen,
+			source:         SourceClient,
+			mac:            "aa:bb:cc:00:00:01",
+			site:           "Default",
+			unifiHostname:  "laptop-1234",
+		},
+		{
+			ip:            createIP("192.168.1.2"),
+			hostnames:     []string{"switch"},
+			lastseen:      cTimeNow,
+			lastseenUnifi: cTimeNow,
+			source:        SourceSwitch,
+			mac:           "aa:bb:cc:00:00:02",
+			site:          "Default",
+		},
+		{
+			ip:        createIP("192.168.1.1"),
+			hostnames: []string{"router"},
+			source:    SourceStatic,
+		},
+		// the WAN interface of a gateway shares its MAC address and sorts
+		// ahead of its LAN address
+		{
+			ip:        createIP("81.2.3.4"),
+			hostnames: []string{"udm-wan1"},
+			lastseen:  cTimeNow,
+			source:    SourceGateway,
+			mac:       "aa:bb:cc:00:00:03",
+			iface:     "wan1",
+		},
+		{
+			ip:        createIP("192.168.1.254"),
+			hostnames: []string{"udm"},
+			lastseen:  cTimeNow,
+			source:    SourceGateway,
+			mac:       "aa:bb:cc:00:00:03",
+		},
+	}
+
+	if err := SaveUnifiHosts(db, hostmaps); err != nil {
+		t.Fatalf("SaveUnifiHosts() error = %v", err)
+	}
+
+	var hosts []sqlmodel.UnifiHost
+	if err := db.Order("mac").Find(&hosts).Error; err != nil {
+		t.Fatalf("Failed to find hosts: %v", err)
+	}
+	if len(hosts) != 3 {
+		t.Fatalf("Expected 3 inventory hosts, got %d", len(hosts))
+	}
+	if gateway := hosts[2]; gateway.Name != "udm" || gateway.IP != "192.168.1.254" {
+		t.Errorf("Expected the gateway to be udm at 192.168.1.254, got %s at %s", gateway.Name, gateway.IP)
+	}
+
+	laptop := hosts[0]
+	if laptop.Name != "laptop" || laptop.Hostname != "laptop-1234" || laptop.IP != "192.168.1.100" ||
+		laptop.Site != "Default" || laptop.DeviceType != "client" {
+		t.Errorf("Unexpected inventory entry for laptop: %+v", laptop)
+	}
+	if !laptop.FirstSeen.Equal(firstSeen) {
+		t.Errorf("Expected laptop first seen %v, got %v", firstSeen, laptop.FirstSeen)
+	}
+
+	// the laptop is renamed and moves to a new IP address, a stale entry for
+	// the old address from an earlier scrape must not win
+	hostmaps = []*Hostmap{
+		hostmaps[0],
+		{
+			ip:             createIP("192.168.1.150"),
+			hostnames:      []string{"work-laptop"},
+			lastseen:       cTimeNow.Add(time.Hour),
+			lastseenUnifi:  cTimeNow.Add(time.Hour),
+			firstseenUnifi: firstSeen,
+			source:         SourceClient,
+			mac:            "aa:bb:cc:00:00:01",
+			site:           "Default",
+		},
+	}
+
+	if err := SaveUnifiHosts(db, hostmaps); err != nil {
+		t.Fatalf("SaveUnifiHosts() error = %v", err)
+	}
+
+	hosts = nil
+	if err := db.Where("mac = ?", "aa:bb:cc:00:00:01").Find(&hosts).Error; err != nil {
+		t.Fatalf("Failed to find hosts: %v", err)
+	}
+	if len(hosts) != 1 {
+		t.Fatalf("Expected a single inventory entry for the laptop, got %d", len(hosts))
+	}
+	if hosts[0].Name != "work-laptop" || hosts[0].IP != "192.168.1.150" {
+		t.Errorf("Expected laptop to be renamed to work-laptop at 192.168.1.150, got %s at %s", hosts[0].Name, hosts[0].IP)
+	}
+	if !hosts[0].LastSeen.Equal(cTimeNow.Add(time.Hour)) || !hosts[0].FirstSeen.Equal(firstSeen) {
+		t.Errorf("Unexpected laptop history: first seen %v, last seen %v", hosts[0].FirstSeen, hosts[0].LastSeen)
+	}
+}
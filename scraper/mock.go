@@ -1,6 +1,8 @@
 package scraper
 
 import (
+	"strings"
+
 	"github.com/unpoller/unifi"
 )
 
@@ -9,6 +11,7 @@ type MockUnifiClient struct {
 	sites   []*unifi.Site
 	devices *unifi.Devices
 	clients []*unifi.Client
+	ipv6    map[string][]string
 	err     error
 }
 
@@ -18,6 +21,7 @@ func NewMockUnifiClient() *MockUnifiClient {
 		sites:   []*unifi.Site{},
 		devices: &unifi.Devices{},
 		clients: []*unifi.Client{},
+		ipv6:    map[string][]string{},
 	}
 }
 
@@ -40,6 +44,32 @@ func (m *MockUnifiClient) AddClient(name, ip string, lastSeen float64) *MockUnif
 	return m
 }
 
+// AddSiteClient adds a mock client at a site, with the SiteName set the way
+// the unifi library sets it
+func (m *MockUnifiClient) AddSiteClient(site, name, ip string, lastSeen float64) *MockUnifiClient {
+	m.clients = append(m.clients, &unifi.Client{
+		Name:     name,
+		IP:       ip,
+		Hostname: name,
+		SiteName: site + " (" + site + ")",
+		LastSeen: unifi.FlexInt{Val: lastSeen},
+	})
+	return m
+}
+
+// AddClientWithIPv6 adds a mock client that also has IPv6 addresses
+func (m *MockUnifiClient) AddClientWithIPv6(name, mac, ip string, ipv6 []string, lastSeen float64) *MockUnifiClient {
+	m.clients = append(m.clients, &unifi.Client{
+		Name:     name,
+		Mac:      mac,
+		IP:       ip,
+		Hostname: name,
+		LastSeen: unifi.FlexInt{Val: lastSeen},
+	})
+	m.ipv6[strings.ToLower(mac)] = ipv6
+	return m
+}
+
 // AddSwitch adds a mock switch
 func (m *MockUnifiClient) AddSwitch(name, ip string, lastSeen float64) *MockUnifiClient {
 	m.devices.USWs = append(m.devices.USWs, &unifi.USW{
@@ -60,6 +90,16 @@ func (m *MockUnifiClient) AddAP(name, ip string, lastSeen float64) *MockUnifiCli
 	return m
 }
 
+// AddGateway adds a mock gateway
+func (m *MockUnifiClient) AddGateway(name, ip string, lastSeen float64) *MockUnifiClient {
+	m.devices.UDMs = append(m.devices.UDMs, &unifi.UDM{
+		Name:     name,
+		IP:       ip,
+		LastSeen: unifi.FlexInt{Val: lastSeen},
+	})
+	return m
+}
+
 // SetError sets an error to be returned by the mock client
 func (m *MockUnifiClient) SetError(err error) *MockUnifiClient {
 	m.err = err
@@ -74,12 +114,27 @@ func (m *MockUnifiClient) GetSites() ([]*unifi.Site, error) {
 	return m.sites, nil
 }
 
-// GetClients implements the method to return mock clients
-func (m *MockUnifiClient) GetClients(_ []*unifi.Site) ([]*unifi.Client, error) {
+// GetClients implements the method to return mock clients. Clients that
+// belong to a site are only returned when that site is asked for.
+func (m *MockUnifiClient) GetClients(sites []*unifi.Site) ([]*unifi.Client, error) {
 	if m.err != nil {
 		return nil, m.err
 	}
-	return m.clients, nil
+
+	var clients []*unifi.Client
+	for _, client := range m.clients {
+		if client.SiteName == "" {
+			clients = append(clients, client)
+			continue
+		}
+		for _, site := range sites {
+			if site.Name == siteKey(client.SiteName) {
+				clients = append(clients, client)
+				break
+			}
+		}
+	}
+	return clients, nil
 }
 
 // GetDevices implements the method to return mock devices
@@ -90,6 +145,14 @@ func (m *MockUnifiClient) GetDevices(_ []*unifi.Site) (*unifi.Devices, error) {
 	return m.devices, nil
 }
 
+// GetClientIPv6 implements the method to return mock client IPv6 addresses
+func (m *MockUnifiClient) GetClientIPv6(_ []*unifi.Site) (map[string][]string, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return m.ipv6, nil
+}
+
 // UnifiClientInterface defines an interface that both the real and mock clients can implement
 type UnifiClientInterface interface {
 	GetSites() ([]*unifi.Site, error)
@@ -97,50 +160,42 @@ type UnifiClientInterface interface {
 	GetDevices([]*unifi.Site) (*unifi.Devices, error)
 }
 
-// GetUnifiElementsWithClient is a version of getUnifiElements that accepts an interface
-func GetUnifiElementsWithClient(cfg *TomlConfig, client UnifiClientInterface) ([]*unifi.Site, *unifi.Devices, []*unifi.Client, error) {
-	sites, err := client.GetSites()
-	if err != nil {
-		logger.Errorf("Error getting sites: %s", err)
-		logger.Warnf("Not updating list of hosts this round - will try again later")
-		return nil, nil, nil, err
-	}
-
-	clients, err := client.GetClients(sites)
-	if err != nil {
-		logger.Errorf("Error getting clients: %s", err)
-		return sites, nil, nil, err
-	}
+// UnifiIPv6Interface is implemented by clients that can also look up the IPv6
+// addresses of each client, keyed by lowercase MAC address
+type UnifiIPv6Interface interface {
+	GetClientIPv6([]*unifi.Site) (map[string][]string, error)
+}
 
-	devices, err := client.GetDevices(sites)
-	if err != nil {
-		logger.Errorf("Error getting devices: %s", err)
-		return sites, nil, clients, err
+// GetUnifiElementsWithClient is a version of getUnifiElements that accepts an
+// interface. It uses the settings of the first controller in cfg.Unifi.
+func GetUnifiElementsWithClient(cfg *TomlConfig, client UnifiClientInterface) ([]*unifi.Site, *unifi.Devices, []*unifi.Client, error) {
+	var controller UnifiConfig
+	if len(cfg.Unifi) > 0 {
+		controller = cfg.Unifi[0]
 	}
 
-	logger.Infof("%d Unifi Sites Found", len(sites))
-	logger.Infof("%d Clients connected", len(clients))
-	logger.Infof("%d Unifi Switches Found", len(devices.USWs))
-	logger.Infof("%d Unifi Gateways Found", len(devices.USGs))
-	logger.Infof("%d Unifi Wireless APs Found", len(devices.UAPs))
-
-	return sites, devices, clients, nil
+	scrape, err := scrapeController(&controller, cfg, client)
+	return scrape.sites, scrape.devices, scrape.clients, err
 }
 
 // GenerateHostsFileWithClient is a version of GenerateHostsFile that accepts a client interface
 func GenerateHostsFileWithClient(cfg *TomlConfig, hostmaps []*Hostmap, client UnifiClientInterface) ([]*Hostmap, error) {
-	logger.Infof("Starting new host file generation")
-	logger.Infof("%d existing hosts in the hostmap", len(hostmaps))
+	return GenerateHostsFileWithClients(cfg, hostmaps, []UnifiClientInterface{client})
+}
 
-	_, devices, clients, err := GetUnifiElementsWithClient(cfg, client)
-	if err != nil {
-		logger.Errorf("Error getting Unifi elements: %s", err)
+// GenerateHostsFileWithClients is a version of GenerateHostsFile that accepts
+// a client interface for each controller in cfg.Unifi, in the same order.
+// Clients without a matching entry in cfg.Unifi use the default settings.
+func GenerateHostsFileWithClients(cfg *TomlConfig, hostmaps []*Hostmap, clients []UnifiClientInterface) ([]*Hostmap, error) {
+	controllers := make([]UnifiConfig, len(clients))
+	copy(controllers, cfg.Unifi)
+	if err := checkControllerNames(controllers); err != nil {
 		return hostmaps, err
 	}
 
-	fullHostmap := createHostmap(clients, devices.USWs, devices.UAPs, cfg, hostmaps)
-
-	return fullHostmap, nil
+	return generateHostmap(cfg, hostmaps, controllers, func(i int) (UnifiClientInterface, error) {
+		return clients[i], nil
+	})
 }
 
 // GetRemovalCode allows access to the removalCode field for testing
@@ -0,0 +1,344 @@
+package scraper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// outputRenderers turn the hostmap into the configuration format of a DNS
+// server. Formats that can express CNAMEs keep them as CNAMEs, the others
+// flatten them onto the line of their target like the hosts file does.
+var outputRenderers = map[string]func(hostmaps []*Hostmap, cfg *TomlConfig) string{
+	"hosts":   renderHostsFile,
+	"coredns": renderHostsFile,
+	"dnsmasq": renderDnsmasq,
+	"unbound": renderUnbound,
+}
+
+// Output is a destination for the records of the hostmap, such as a hosts
+// file, a database or the API of a DNS server
+type Output interface {
+	// Name identifies the output in log messages
+	Name() string
+	// Save sends the current hostmap to the output
+	Save(hostmaps []*Hostmap, cfg *TomlConfig) error
+}
+
+// NewOutputs creates the outputs that are enabled in the configuration. The
+// outputs are created once and then saved to after every scrape, so outputs
+// that keep state, like the DNS server, keep it between runs. When the
+// configuration is read again, ReloadOutputs picks up any changes.
+func NewOutputs(cfg *TomlConfig, db *gorm.DB) ([]Output, error) {
+	return ReloadOutputs(nil, cfg, db)
+}
+
+// ReloadOutputs creates the outputs for a configuration that may have changed
+// since the current outputs were created. Outputs that keep state are taken
+// over from the current outputs as long as their settings are the same, so
+// the DNS server keeps listening and dynamic updates aren't all sent again.
+// The current outputs that are no longer used are closed. If the new outputs
+// can't be created, the current outputs are returned unchanged.
+func ReloadOutputs(current []Output, cfg *TomlConfig, db *gorm.DB) ([]Output, error) {
+	var outputs []Output
+	reused := make([]bool, len(current))
+
+	// reuse returns the first current output that matches
+	reuse := func(match func(Output) bool) Output {
+		for i, output := range current {
+			if !reused[i] && match(output) {
+				reused[i] = true
+				return output
+			}
+		}
+		return nil
+	}
+
+	if cfg.Hostsfile.Filename != "" {
+		outputs = append(outputs, hostsfileOutput{})
+	}
+	if db != nil {
+		outputs = append(outputs, databaseOutput{db})
+	}
+	for _, output := range cfg.Output {
+		format := strings.ToLower(output.Format)
+		if _, ok := outputRenderers[format]; !ok {
+			return current, fmt.Errorf("unsupported output format: %s", output.Format)
+		}
+		if output.Filename == "" {
+			return current, fmt.Errorf("no filename for %s output", format)
+		}
+		outputs = append(outputs, fileOutput{format, output.Filename, output.Sites})
+	}
+	if cfg.Zonefile.Directory != "" {
+		outputs = append(outputs, zonefileOutput{})
+	}
+	if cfg.Pihole.URL != "" {
+		output := reuse(func(o Output) bool {
+			pihole, ok := o.(piholeOutput)
+			return ok && pihole.url == cfg.Pihole.URL
+		})
+		if output == nil {
+			output = piholeOutput{cfg.Pihole.URL, newPushedEntries("pihole", db)}
+		}
+		outputs = append(outputs, output)
+	}
+	if cfg.AdGuard.URL != "" {
+		output := reuse(func(o Output) bool {
+			adguard, ok := o.(adguardOutput)
+			return ok && adguard.url == cfg.AdGuard.URL
+		})
+		if output == nil {
+			output = adguardOutput{cfg.AdGuard.URL, newPushedEntries("adguard", db)}
+		}
+		outputs = append(outputs, output)
+	}
+	if cfg.RFC2136.Server != "" {
+		output := reuse(func(o Output) bool {
+			updater, ok := o.(*DynamicUpdater)
+			return ok && updater.settings == cfg.RFC2136
+		})
+		if output == nil {
+			updater := NewDynamicUpdater(db)
+			updater.settings = cfg.RFC2136
+			output = updater
+		}
+		outputs = append(outputs, output)
+	}
+	if cfg.DNS.Listen != "" {
+		output := reuse(func(o Output) bool {
+			server, ok := o.(*DNSServer)
+			return ok && server.listen == cfg.DNS.Listen
+		})
+		if output == nil {
+			server := NewDNSServer(nil, cfg)
+			if err := server.Start(cfg.DNS.Listen); err != nil {
+				return current, err
+			}
+			output = server
+		}
+		outputs = append(outputs, output)
+	}
+
+	// the outputs without state are cheap to create again, only the ones
+	// that hold on to something need to be closed
+	if current != nil {
+		var unused []Output
+		for i, output := range current {
+			if !reused[i] {
+				unused = append(unused, output)
+			}
+		}
+		CloseOutputs(unused)
+		if names, old := outputNames(outputs), outputNames(current); names != old {
+			logger.Infof("Outputs changed from [%s] to [%s]", old, names)
+		}
+	}
+
+	return outputs, nil
+}
+
+// outputNames lists the names of the outputs for log messages
+func outputNames(outputs []Output) string {
+	names := make([]string, len(outputs))
+	for i, output := range outputs {
+		names[i] = output.Name()
+	}
+	return strings.Join(names, " ")
+}
+
+// SaveOutputs saves the hostmap to all outputs at the same time. Each output
+// logs its own errors and a failing output never stops the others. The
+// returned error combines the errors of all outputs that failed.
+func SaveOutputs(outputs []Output, hostmaps []*Hostmap, cfg *TomlConfig) error {
+	errs := make([]error, len(outputs))
+
+	var wg sync.WaitGroup
+	for i, output := range outputs {
+		wg.Add(1)
+		go func(i int, output Output) {
+			defer wg.Done()
+			if err := output.Save(hostmaps, cfg); err != nil {
+				logger.Errorf("Error saving %s output: %s", output.Name(), err)
+				errs[i] = fmt.Errorf("%s: %w", output.Name(), err)
+			}
+		}(i, output)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// CloseOutputs releases anything held by the outputs, like listening sockets
+func CloseOutputs(outputs []Output) {
+	for _, output := range outputs {
+		if closer, ok := output.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				logger.Warnf("Error closing %s output: %s", output.Name(), err)
+			}
+		}
+	}
+}
+
+type hostsfileOutput struct{}
+
+func (hostsfileOutput) Name() string { return "hostsfile" }
+
+func (hostsfileOutput) Save(hostmaps []*Hostmap, cfg *TomlConfig) error {
+	return SaveHostsFile(hostmaps, cfg)
+}
+
+type databaseOutput struct {
+	db *gorm.DB
+}
+
+func (databaseOutput) Name() string { return "database" }
+
+func (o databaseOutput) Save(hostmaps []*Hostmap, cfg *TomlConfig) error {
+	if err := SaveDatabase(o.db, hostmaps, cfg); err != nil {
+		return err
+	}
+	return SaveUnifiHosts(o.db, hostmaps)
+}
+
+// fileOutput writes one of the [[output]] files
+type fileOutput struct {
+	format   string
+	filename string
+	sites    []string
+}
+
+func (o fileOutput) Name() string { return o.format }
+
+func (o fileOutput) Save(hostmaps []*Hostmap, cfg *TomlConfig) error {
+	hostmaps = filterHostmapsBySite(hostmaps, o.sites)
+	if err := writeFileAtomic(o.filename, []byte(outputRenderers[o.format](hostmaps, cfg)), 0644); err != nil {
+		return err
+	}
+	logger.Infof("Wrote %s output to %s", o.format, o.filename)
+	return nil
+}
+
+type zonefileOutput struct{}
+
+func (zonefileOutput) Name() string { return "zonefile" }
+
+func (zonefileOutput) Save(hostmaps []*Hostmap, cfg *TomlConfig) error {
+	return SaveZoneFiles(hostmaps, cfg)
+}
+
+type piholeOutput struct {
+	url    string
+	pushed *pushedEntries
+}
+
+func (piholeOutput) Name() string { return "pihole" }
+
+func (o piholeOutput) Save(hostmaps []*Hostmap, cfg *TomlConfig) error {
+	return SyncPihole(hostmaps, cfg, o.pushed)
+}
+
+type adguardOutput struct {
+	url    string
+	pushed *pushedEntries
+}
+
+func (adguardOutput) Name() string { return "adguard" }
+
+func (o adguardOutput) Save(hostmaps []*Hostmap, cfg *TomlConfig) error {
+	return SyncAdGuard(hostmaps, cfg, o.pushed)
+}
+
+// hostNames returns the names of a host with the name that should be used
+// for reverse lookups of its address first
+func hostNames(hm *Hostmap, cfg *TomlConfig, ipv6 bool) []string {
+	primary := primaryFQDN(hm, cfg, ipv6)
+	if primary == "" {
+		return nil
+	}
+
+	names := []string{primary}
+	for _, fqdn := range hm.fqdns {
+		if fqdn == primary || (ipv6 && !ipv6Enabled(fqdn, cfg)) {
+			continue
+		}
+		names = append(names, fqdn)
+	}
+	return names
+}
+
+// renderDnsmasq builds a dnsmasq configuration file with a host-record line
+// for each address, which also answers reverse lookups with the first name,
+// and a cname line for each CNAME
+func renderDnsmasq(hostmaps []*Hostmap, cfg *TomlConfig) string {
+	var builder strings.Builder
+
+	builder.WriteString("# This file created by unifi-dns-scraper\n")
+	builder.WriteString("# Do not manually edit\n\n")
+
+	for _, hm := range hostmaps {
+		if hm.removalCode != NotRemoved {
+			continue
+		}
+		if names := hostNames(hm, cfg, false); len(names) > 0 {
+			builder.WriteString(fmt.Sprintf("host-record=%s,%s\n", strings.Join(names, ","), hm.ip))
+		}
+		if names := hostNames(hm, cfg, true); len(names) > 0 {
+			for _, ip := range hm.ipv6 {
+				builder.WriteString(fmt.Sprintf("host-record=%s,%s\n", strings.Join(names, ","), ip))
+			}
+		}
+	}
+
+	for _, record := range collectRecords(hostmaps, cfg, false) {
+		if record.Type == "CNAME" {
+			builder.WriteString(fmt.Sprintf("cname=%s,%s\n", record.Name, record.Content))
+		}
+	}
+
+	return builder.String()
+}
+
+// renderUnbound builds a file for Unbound to include with local-data lines
+// for the records and local-data-ptr lines for the addresses
+func renderUnbound(hostmaps []*Hostmap, cfg *TomlConfig) string {
+	var builder strings.Builder
+
+	builder.WriteString("# This file created by unifi-dns-scraper\n")
+	builder.WriteString("# Do not manually edit\n\n")
+	builder.WriteString("server:\n")
+
+	// local-data-ptr takes the address rather than the name of the PTR record
+	type ptr struct {
+		address netip.Addr
+		fqdn    string
+	}
+	var ptrs []ptr
+	addresses := reverseAddresses(hostmaps)
+	for _, record := range collectRecords(hostmaps, cfg, true) {
+		if record.Type == "PTR" {
+			ptrs = append(ptrs, ptr{addresses[record.Name], record.Content})
+			continue
+		}
+		content := record.Content
+		if record.Type == "CNAME" {
+			content += "."
+		}
+		builder.WriteString(fmt.Sprintf("\tlocal-data: \"%s. IN %s %s\"\n", record.Name, record.Type, content))
+	}
+
+	sort.Slice(ptrs, func(i, j int) bool {
+		return ptrs[i].address.Less(ptrs[j].address)
+	})
+	for _, ptr := range ptrs {
+		builder.WriteString(fmt.Sprintf("\tlocal-data-ptr: \"%s %s.\"\n", ptr.address, ptr.fqdn))
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,234 @@
+package scraper
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/withmandala/go-log"
+)
+
+// TestSaveOutputs tests the dnsmasq, Unbound and CoreDNS output formats
+func TestSaveOutputs(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	dir := t.TempDir()
+	cfg := &TomlConfig{
+		Processing: ProcessingConfig{
+			Domains:     []string{"example.com", "home.local"},
+			IPv6Domains: []string{"example.com"},
+			Cnames: []struct {
+				Cname    string
+				Hostname string
+			}{
+				{Cname: "www.example.com", Hostname: "host1.example.com"},
+			},
+		},
+		Output: []OutputConfig{
+			{Format: "dnsmasq", Filename: filepath.Join(dir, "dnsmasq.conf")},
+			{Format: "Unbound", Filename: filepath.Join(dir, "unbound.conf")},
+			{Format: "coredns", Filename: filepath.Join(dir, "coredns.hosts")},
+		},
+	}
+
+	hm := &Hostmap{
+		ip:        createIP("192.168.1.10"),
+		ipv6:      []netip.Addr{createIP("2001:db8::10")},
+		hostnames: []string{"host1"},
+	}
+	addDomainsToHostmap(hm, cfg.Processing.Domains)
+	gone := &Hostmap{
+		ip:          createIP("192.168.1.11"),
+		hostnames:   []string{"gone"},
+		removalCode: Old,
+	}
+	addDomainsToHostmap(gone, cfg.Processing.Domains)
+
+	outputs, err := NewOutputs(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewOutputs failed: %v", err)
+	}
+	if len(outputs) != 3 {
+		t.Fatalf("Expected 3 outputs, got %d", len(outputs))
+	}
+	if err := SaveOutputs(outputs, []*Hostmap{hm, gone}, cfg); err != nil {
+		t.Fatalf("SaveOutputs failed: %v", err)
+	}
+
+	tests := []struct {
+		filename string
+		want     []string
+	}{
+		{
+			filename: "dnsmasq.conf",
+			want: []string{
+				"host-record=host1.example.com,host1.home.local,192.168.1.10\n",
+				"host-record=host1.example.com,2001:db8::10\n",
+				"cname=www.example.com,host1.example.com\n",
+			},
+		},
+		{
+			filename: "unbound.conf",
+			want: []string{
+				"server:\n",
+				"\tlocal-data: \"host1.example.com. IN A 192.168.1.10\"\n",
+				"\tlocal-data: \"host1.example.com. IN AAAA 2001:db8::10\"\n",
+				"\tlocal-data: \"host1.home.local. IN A 192.168.1.10\"\n",
+				"\tlocal-data: \"www.example.com. IN CNAME host1.example.com.\"\n",
+				"\tlocal-data-ptr: \"192.168.1.10 host1.example.com.\"\n",
+				"\tlocal-data-ptr: \"2001:db8::10 host1.example.com.\"\n",
+			},
+		},
+		{
+			filename: "coredns.hosts",
+			want: []string{
+				"192.168.1.10 host1.example.com host1.home.local www.example.com\n",
+				"2001:db8::10 host1.example.com www.example.com\n",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join(dir, tt.filename))
+			if err != nil {
+				t.Fatalf("Failed to read output: %v", err)
+			}
+			content := string(data)
+			for _, want := range tt.want {
+				if !strings.Contains(content, want) {
+					t.Errorf("Expected %q in output, got:\n%s", want, content)
+				}
+			}
+			if strings.Contains(content, "gone") {
+				t.Errorf("Removed host should not be in output:\n%s", content)
+			}
+		})
+	}
+
+	cfg.Output = []OutputConfig{{Format: "bind", Filename: filepath.Join(dir, "bind.conf")}}
+	if _, err := NewOutputs(cfg, nil); err == nil {
+		t.Errorf("Expected an error for an unsupported format")
+	}
+}
+
+// failingOutput is an output that always fails
+type failingOutput struct{}
+
+func (failingOutput) Name() string { return "failing" }
+
+func (failingOutput) Save(hostmaps []*Hostmap, cfg *TomlConfig) error {
+	return errors.New("broken")
+}
+
+// TestSaveOutputsFailure tests that a failing output doesn't stop the others
+func TestSaveOutputsFailure(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	dir := t.TempDir()
+	cfg := &TomlConfig{
+		Processing: ProcessingConfig{Domains: []string{"example.com"}},
+		Hostsfile:  HostsfileConfig{Filename: filepath.Join(dir, "missing", "hosts")},
+		Output:     []OutputConfig{{Format: "dnsmasq", Filename: filepath.Join(dir, "dnsmasq.conf")}},
+	}
+
+	hm := &Hostmap{ip: createIP("192.168.1.10"), hostnames: []string{"host1"}}
+	addDomainsToHostmap(hm, cfg.Processing.Domains)
+
+	outputs, err := NewOutputs(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewOutputs failed: %v", err)
+	}
+	outputs = append(outputs, failingOutput{})
+
+	err = SaveOutputs(outputs, []*Hostmap{hm}, cfg)
+	if err == nil {
+		t.Fatalf("Expected an error from the failing outputs")
+	}
+	for _, name := range []string{"hostsfile:", "failing: broken"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("Expected error to mention %q, got %v", name, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "dnsmasq.conf")); err != nil {
+		t.Errorf("Expected the dnsmasq output to be written anyway: %v", err)
+	}
+}
+
+// TestReloadOutputs tests that outputs added to the configuration are
+// created, removed outputs are closed and outputs with state are kept while
+// their settings stay the same
+func TestReloadOutputs(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	dir := t.TempDir()
+	cfg := &TomlConfig{
+		Processing: ProcessingConfig{Domains: []string{"example.com"}},
+		DNS:        DNSConfig{Listen: "127.0.0.1:0"},
+		RFC2136:    RFC2136Config{Server: "127.0.0.1:53"},
+	}
+
+	outputs, err := NewOutputs(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewOutputs failed: %v", err)
+	}
+	defer func() { CloseOutputs(outputs) }()
+	server := outputs[1].(*DNSServer)
+	updater := outputs[0].(*DynamicUpdater)
+
+	// a new output is created and the others are kept
+	cfg.Output = []OutputConfig{{Format: "dnsmasq", Filename: filepath.Join(dir, "dnsmasq.conf")}}
+	outputs, err = ReloadOutputs(outputs, cfg, nil)
+	if err != nil {
+		t.Fatalf("ReloadOutputs failed: %v", err)
+	}
+	if got := outputNames(outputs); got != "dnsmasq rfc2136 dns" {
+		t.Fatalf("Expected the dnsmasq output to be added, got %s", got)
+	}
+	if outputs[1] != updater || outputs[2] != server {
+		t.Errorf("Expected the DNS server and the updater to be kept")
+	}
+
+	// changed settings create a new updater
+	cfg.RFC2136.TTL = 60
+	outputs, err = ReloadOutputs(outputs, cfg, nil)
+	if err != nil {
+		t.Fatalf("ReloadOutputs failed: %v", err)
+	}
+	if outputs[1] == updater {
+		t.Errorf("Expected a new updater after its settings changed")
+	}
+
+	// a broken configuration keeps the current outputs
+	broken := *cfg
+	broken.Output = []OutputConfig{{Format: "bind", Filename: filepath.Join(dir, "bind.conf")}}
+	if kept, err := ReloadOutputs(outputs, &broken, nil); err == nil || len(kept) != len(outputs) {
+		t.Errorf("Expected an error and the current outputs, got %v and %s", err, outputNames(kept))
+	}
+
+	// a removed DNS server stops listening
+	addr := server.Addr()
+	cfg.DNS.Listen = ""
+	outputs, err = ReloadOutputs(outputs, cfg, nil)
+	if err != nil {
+		t.Fatalf("ReloadOutputs failed: %v", err)
+	}
+	if got := outputNames(outputs); got != "dnsmasq rfc2136" {
+		t.Errorf("Expected the DNS server to be removed, got %s", got)
+	}
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Errorf("Expected the removed DNS server to be closed")
+	}
+}
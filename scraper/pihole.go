@@ -0,0 +1,293 @@
+package scraper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// apiTimeout is the timeout for requests to the APIs of DNS servers
+const apiTimeout = 30 * time.Second
+
+// pushedEntries remembers the entries the scraper added through the API of a
+// DNS server. Only those are ever removed again, so entries added by hand are
+// left alone even when they are for a name in one of the configured domains.
+// With a [state] block they are saved along with the hostmap, otherwise they
+// are only remembered until the scraper restarts.
+type pushedEntries struct {
+	name    string
+	db      *gorm.DB
+	loaded  bool
+	entries map[string]map[string]bool
+}
+
+// newPushedEntries creates the entries for an output, which are loaded from
+// the saved state the first time they are used
+func newPushedEntries(name string, db *gorm.DB) *pushedEntries {
+	return &pushedEntries{name: name, db: db, entries: make(map[string]map[string]bool)}
+}
+
+// load reads the entries from the saved state, once
+func (p *pushedEntries) load(cfg *TomlConfig) error {
+	if p.loaded {
+		return nil
+	}
+	data, err := readState(cfg, p.db, p.name)
+	if err != nil {
+		return err
+	}
+	if len(data) > 0 {
+		var saved map[string][]string
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return err
+		}
+		for setting, entries := range saved {
+			for _, entry := range entries {
+				p.set(setting, entry, true)
+			}
+		}
+	}
+	p.loaded = true
+	return nil
+}
+
+// save writes the entries to the saved state, if there is one
+func (p *pushedEntries) save(cfg *TomlConfig) error {
+	if cfg.State.Filename == "" && !cfg.State.Database {
+		return nil
+	}
+	saved := make(map[string][]string)
+	for setting, entries := range p.entries {
+		saved[setting] = []string{}
+		for entry := range entries {
+			saved[setting] = append(saved[setting], entry)
+		}
+		sort.Strings(saved[setting])
+	}
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	return writeState(cfg, p.db, p.name, data)
+}
+
+// owned checks if the scraper added an entry
+func (p *pushedEntries) owned(setting string, entry string) bool {
+	return p.entries[setting][entry]
+}
+
+// set records that the scraper added or removed an entry
+func (p *pushedEntries) set(setting string, entry string, pushed bool) {
+	if !pushed {
+		delete(p.entries[setting], entry)
+		return
+	}
+	if p.entries[setting] == nil {
+		p.entries[setting] = make(map[string]bool)
+	}
+	p.entries[setting][entry] = true
+}
+
+// forgetMissing drops the entries that were removed by someone else, so the
+// scraper doesn't claim an entry that is added by hand again later
+func (p *pushedEntries) forgetMissing(setting string, current []string) {
+	present := make(map[string]bool)
+	for _, entry := range current {
+		present[entry] = true
+	}
+	for entry := range p.entries[setting] {
+		if !present[entry] {
+			p.set(setting, entry, false)
+		}
+	}
+}
+
+// syncEntries works out which entries to add and remove to get from the
+// current entries to the wanted ones. Entries that are not owned are ignored.
+func syncEntries(current []string, wanted []string, owned func(string) bool) (add []string, remove []string) {
+	present := make(map[string]bool)
+	for _, entry := range current {
+		present[entry] = true
+	}
+	keep := make(map[string]bool)
+	for _, entry := range wanted {
+		keep[entry] = true
+		if !present[entry] {
+			add = append(add, entry)
+		}
+	}
+	for _, entry := range current {
+		if owned(entry) && !keep[entry] {
+			remove = append(remove, entry)
+		}
+	}
+	sort.Strings(add)
+	sort.Strings(remove)
+	return add, remove
+}
+
+// piholeClient talks to the REST API of Pi-hole v6
+type piholeClient struct {
+	base   string
+	sid    string
+	client *http.Client
+}
+
+// SyncPihole makes the local DNS records and CNAME records of Pi-hole match
+// the hostmap using the Pi-hole v6 API. Only the entries in pushed are ever
+// removed, and the entries that are added or removed are recorded in it.
+func SyncPihole(hostmaps []*Hostmap, cfg *TomlConfig, pushed *pushedEntries) error {
+	if err := pushed.load(cfg); err != nil {
+		return fmt.Errorf("unable to load the Pi-hole entries of the saved state: %w", err)
+	}
+
+	c := &piholeClient{
+		base:   strings.TrimSuffix(cfg.Pihole.URL, "/"),
+		client: &http.Client{Timeout: apiTimeout},
+	}
+	if cfg.Pihole.Password != "" {
+		if err := c.login(cfg.Pihole.Password); err != nil {
+			return fmt.Errorf("unable to log in to Pi-hole: %w", err)
+		}
+		defer c.logout()
+	}
+
+	// hosts entries are "address name [name...]" and CNAME entries are
+	// "name,target"
+	var hosts, cnames []string
+	for _, record := range collectRecords(hostmaps, cfg, false) {
+		switch record.Type {
+		case "A", "AAAA":
+			hosts = append(hosts, fmt.Sprintf("%s %s", record.Content, record.Name))
+		case "CNAME":
+			cnames = append(cnames, fmt.Sprintf("%s,%s", record.Name, record.Content))
+		}
+	}
+
+	for _, setting := range []struct {
+		name   string
+		wanted []string
+	}{
+		{"hosts", hosts},
+		{"cnameRecords", cnames},
+	} {
+		current, err := c.getEntries(setting.name)
+		if err != nil {
+			return err
+		}
+		pushed.forgetMissing(setting.name, current)
+		add, remove := syncEntries(current, setting.wanted, func(entry string) bool {
+			return pushed.owned(setting.name, entry)
+		})
+		err = c.apply(setting.name, add, remove, pushed)
+		if saveErr := pushed.save(cfg); saveErr != nil {
+			logger.Errorf("Error saving the Pi-hole entries to the state: %s", saveErr)
+		}
+		if err != nil {
+			return err
+		}
+		logger.Infof("Synced Pi-hole %s: %d added, %d removed", setting.name, len(add), len(remove))
+	}
+
+	return nil
+}
+
+// apply removes and adds the entries of a setting and records each change
+// that worked in pushed
+func (c *piholeClient) apply(setting string, add []string, remove []string, pushed *pushedEntries) error {
+	for _, entry := range remove {
+		if err := c.do(http.MethodDelete, "/api/config/dns/"+setting+"/"+url.PathEscape(entry), nil, nil); err != nil {
+			return err
+		}
+		pushed.set(setting, entry, false)
+	}
+	for _, entry := range add {
+		if err := c.do(http.MethodPut, "/api/config/dns/"+setting+"/"+url.PathEscape(entry), nil, nil); err != nil {
+			return err
+		}
+		pushed.set(setting, entry, true)
+	}
+	return nil
+}
+
+// login creates a session that is used for the rest of the requests
+func (c *piholeClient) login(password string) error {
+	var result struct {
+		Session struct {
+			Valid bool   `json:"valid"`
+			Sid   string `json:"sid"`
+		} `json:"session"`
+	}
+	if err := c.do(http.MethodPost, "/api/auth", map[string]string{"password": password}, &result); err != nil {
+		return err
+	}
+	if !result.Session.Valid {
+		return fmt.Errorf("invalid password")
+	}
+	c.sid = result.Session.Sid
+	return nil
+}
+
+// logout ends the session, as Pi-hole only allows a limited number of them
+func (c *piholeClient) logout() {
+	if err := c.do(http.MethodDelete, "/api/auth", nil, nil); err != nil {
+		logger.Warnf("Unable to log out of Pi-hole: %s", err)
+	}
+}
+
+// getEntries returns the entries of one of the dns settings of Pi-hole
+func (c *piholeClient) getEntries(setting string) ([]string, error) {
+	var result struct {
+		Config struct {
+			DNS map[string][]string `json:"dns"`
+		} `json:"config"`
+	}
+	if err := c.do(http.MethodGet, "/api/config/dns/"+setting, nil, &result); err != nil {
+		return nil, err
+	}
+	return result.Config.DNS[setting], nil
+}
+
+// do sends a request to the API and decodes the JSON response into result
+func (c *piholeClient) do(method string, path string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.base+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.sid != "" {
+		req.Header.Set("X-FTL-SID", c.sid)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	if result != nil {
+		return json.NewDecoder(resp.Body).Decode(result)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package scraper
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/withmandala/go-log"
+)
+
+// apiTestHostmaps returns the hosts and configuration shared by the API tests
+func apiTestHostmaps() ([]*Hostmap, *TomlConfig) {
+	cfg := &TomlConfig{
+		Processing: ProcessingConfig{
+			Domains: []string{"example.com"},
+			Cnames: []struct {
+				Cname    string
+				Hostname string
+			}{
+				{Cname: "www.example.com", Hostname: "host1.example.com"},
+			},
+		},
+	}
+
+	host1 := &Hostmap{ip: createIP("192.168.1.10"), hostnames: []string{"host1"}}
+	addDomainsToHostmap(host1, cfg.Processing.Domains)
+	host2 := &Hostmap{ip: createIP("192.168.1.11"), hostnames: []string{"host2"}}
+	addDomainsToHostmap(host2, cfg.Processing.Domains)
+	return []*Hostmap{host1, host2}, cfg
+}
+
+// fakePihole is a stand-in for the parts of the Pi-hole v6 API that are used
+type fakePihole struct {
+	mu       sync.Mutex
+	password string
+	loggedIn bool
+	settings map[string][]string
+}
+
+func (f *fakePihole) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == "/api/auth" {
+		switch r.Method {
+		case http.MethodPost:
+			var body struct{ Password string }
+			json.NewDecoder(r.Body).Decode(&body)
+			valid := body.Password == f.password
+			f.loggedIn = valid
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"session": map[string]interface{}{"valid": valid, "sid": "test-sid"},
+			})
+		case http.MethodDelete:
+			f.loggedIn = false
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+
+	if r.Header.Get("X-FTL-SID") != "test-sid" || !f.loggedIn {
+		http.Error(w, `{"error":{"key":"unauthorized"}}`, http.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.EscapedPath(), "/api/config/dns/"), "/", 2)
+	setting := parts[0]
+	if _, ok := f.settings[setting]; !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"config": map[string]interface{}{"dns": map[string]interface{}{setting: f.settings[setting]}},
+		})
+	case http.MethodPut:
+		value, _ := url.PathUnescape(parts[1])
+		f.settings[setting] = append(f.settings[setting], value)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		value, _ := url.PathUnescape(parts[1])
+		var kept []string
+		for _, entry := range f.settings[setting] {
+			if entry != value {
+				kept = append(kept, entry)
+			}
+		}
+		f.settings[setting] = kept
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// TestSyncPihole tests that only the entries the scraper added are removed,
+// even if an entry added by hand is in one of the configured domains
+func TestSyncPihole(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	fake := &fakePihole{
+		password: "secret",
+		settings: map[string][]string{
+			"hosts": {
+				"192.168.1.99 host2.example.com",
+				"192.168.1.20 printer.example.com",
+				"192.168.1.30 router.example.com gateway.other.lan",
+				"192.168.1.50 nas.other.lan",
+			},
+			"cnameRecords": {
+				"old.example.com,host1.example.com",
+				"files.example.com,nas.other.lan",
+				"alias.other.lan,nas.other.lan",
+			},
+		},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	hostmaps, cfg := apiTestHostmaps()
+	cfg.Pihole = PiholeConfig{URL: server.URL + "/", Password: "secret"}
+	cfg.State.Filename = filepath.Join(t.TempDir(), "state.json")
+
+	// the old address of host2 and the old CNAME were added by the scraper
+	// before it restarted
+	saved := `{"hosts":["192.168.1.99 host2.example.com"],"cnameRecords":["old.example.com,host1.example.com"]}`
+	if err := os.WriteFile(cfg.State.Filename+".pihole", []byte(saved), 0600); err != nil {
+		t.Fatalf("Failed to write state: %v", err)
+	}
+
+	if err := SyncPihole(hostmaps, cfg, newPushedEntries("pihole", nil)); err != nil {
+		t.Fatalf("SyncPihole failed: %v", err)
+	}
+
+	check := func(wantHosts []string, wantCnames []string) {
+		t.Helper()
+		sort.Strings(fake.settings["hosts"])
+		sort.Strings(fake.settings["cnameRecords"])
+		if !reflect.DeepEqual(fake.settings["hosts"], wantHosts) {
+			t.Errorf("Expected hosts %v, got %v", wantHosts, fake.settings["hosts"])
+		}
+		if !reflect.DeepEqual(fake.settings["cnameRecords"], wantCnames) {
+			t.Errorf("Expected CNAME records %v, got %v", wantCnames, fake.settings["cnameRecords"])
+		}
+	}
+	check([]string{
+		"192.168.1.10 host1.example.com",
+		"192.168.1.11 host2.example.com",
+		"192.168.1.20 printer.example.com",
+		"192.168.1.30 router.example.com gateway.other.lan",
+		"192.168.1.50 nas.other.lan",
+	}, []string{
+		"alias.other.lan,nas.other.lan",
+		"files.example.com,nas.other.lan",
+		"www.example.com,host1.example.com",
+	})
+	if fake.loggedIn {
+		t.Errorf("Expected the session to be logged out")
+	}
+
+	// after another restart the entries added by the first run are removed
+	// once their hosts are gone, and the ones added by hand are still left
+	if err := SyncPihole(hostmaps[1:], cfg, newPushedEntries("pihole", nil)); err != nil {
+		t.Fatalf("SyncPihole failed: %v", err)
+	}
+	check([]string{
+		"192.168.1.11 host2.example.com",
+		"192.168.1.20 printer.example.com",
+		"192.168.1.30 router.example.com gateway.other.lan",
+		"192.168.1.50 nas.other.lan",
+	}, []string{
+		"alias.other.lan,nas.other.lan",
+		"files.example.com,nas.other.lan",
+	})
+
+	cfg.Pihole.Password = "wrong"
+	if err := SyncPihole(hostmaps, cfg, newPushedEntries("pihole", nil)); err == nil {
+		t.Errorf("Expected an error with the wrong password")
+	}
+}
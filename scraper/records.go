@@ -0,0 +1,203 @@
+package scraper
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// dnsRecord is a single resource record built from the hostmap. Outputs that
+// speak DNS rather than writing a hosts file or database tables share these.
+type dnsRecord struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+}
+
+// collectRecords builds the A, AAAA and CNAME records for all hosts that are
+// still in the hostmap and, if reverse is set, the PTR records for their
+// addresses. Names are lowercase without a trailing dot and the records are
+// sorted so the result is stable between runs.
+func collectRecords(hostmaps []*Hostmap, cfg *TomlConfig, reverse bool) []dnsRecord {
+	seen := make(map[dnsRecord]bool)
+	var records []dnsRecord
+	add := func(r dnsRecord) {
+		r.Name = strings.ToLower(strings.TrimSuffix(r.Name, "."))
+		if r.Type == "CNAME" || r.Type == "PTR" {
+			r.Content = strings.ToLower(strings.TrimSuffix(r.Content, "."))
+		}
+		if !seen[r] {
+			seen[r] = true
+			records = append(records, r)
+		}
+	}
+
+	hostnames := make(map[string]bool)
+	ptrs := make(map[string]string)
+	for _, hostmap := range hostmaps {
+		if hostmap.removalCode != NotRemoved {
+			continue
+		}
+
+		for _, fqdn := range hostmap.fqdns {
+			hostnames[strings.ToLower(strings.TrimSuffix(fqdn, "."))] = true
+			add(dnsRecord{fqdn, addressType(hostmap.ip), hostmap.ip.String()})
+			if ipv6Enabled(fqdn, cfg) {
+				for _, ip := range hostmap.ipv6 {
+					add(dnsRecord{fqdn, "AAAA", ip.String()})
+				}
+			}
+		}
+
+		if !reverse {
+			continue
+		}
+		// the first host to claim an address owns its PTR record
+		if fqdn := primaryFQDN(hostmap, cfg, false); fqdn != "" {
+			if _, ok := ptrs[reverseName(hostmap.ip)]; !ok {
+				ptrs[reverseName(hostmap.ip)] = fqdn
+			}
+		}
+		if fqdn := primaryFQDN(hostmap, cfg, true); fqdn != "" {
+			for _, ip := range hostmap.ipv6 {
+				if _, ok := ptrs[reverseName(ip)]; !ok {
+					ptrs[reverseName(ip)] = fqdn
+				}
+			}
+		}
+	}
+
+	for name, fqdn := range ptrs {
+		add(dnsRecord{name, "PTR", fqdn})
+	}
+
+	for _, cname := range cfg.Processing.Cnames {
+		if !hostnames[strings.ToLower(strings.TrimSuffix(cname.Hostname, "."))] {
+			logger.Debugf("CNAME target '%s' for '%s' not found in hosts, skipping", cname.Hostname, cname.Cname)
+			continue
+		}
+		add(dnsRecord{cname.Cname, "CNAME", cname.Hostname})
+	}
+
+	sortRecords(records)
+	return records
+}
+
+// reverseAddresses maps the names of the PTR records from collectRecords back
+// to the addresses they are for
+func reverseAddresses(hostmaps []*Hostmap) map[string]netip.Addr {
+	addresses := make(map[string]netip.Addr)
+	for _, hostmap := range hostmaps {
+		if hostmap.removalCode != NotRemoved {
+			continue
+		}
+		for _, ip := range append([]netip.Addr{hostmap.ip}, hostmap.ipv6...) {
+			addresses[reverseName(ip)] = ip
+		}
+	}
+	return addresses
+}
+
+// addressType returns the record type for an address. The address of a host
+// is usually IPv4, but additional hosts can be given an IPv6 address.
+func addressType(addr netip.Addr) string {
+	if addr.Is4() {
+		return "A"
+	}
+	return "AAAA"
+}
+
+// sortRecords sorts records by name, type and content
+func sortRecords(records []dnsRecord) {
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Name != records[j].Name {
+			return records[i].Name < records[j].Name
+		}
+		if records[i].Type != records[j].Type {
+			return records[i].Type < records[j].Type
+		}
+		return records[i].Content < records[j].Content
+	})
+}
+
+// recordZones returns the zones that the records of the hostmap go into:
+// the configured domains and, if reverse is set, the reverse zones of the
+// addresses of all hosts. The zones are sorted longest first so zoneFor finds
+// the most specific one.
+func recordZones(hostmaps []*Hostmap, cfg *TomlConfig, reverse bool) []string {
+	seen := make(map[string]bool)
+	var zones []string
+	add := func(zone string) {
+		if zone != "" && !seen[zone] {
+			seen[zone] = true
+			zones = append(zones, zone)
+		}
+	}
+
+	for _, domain := range allDomains(cfg) {
+		add(strings.ToLower(strings.Trim(domain, ".")))
+	}
+	if reverse {
+		for _, hostmap := range hostmaps {
+			if hostmap.removalCode != NotRemoved {
+				continue
+			}
+			add(reverseZone(hostmap.ip))
+			for _, ip := range hostmap.ipv6 {
+				add(reverseZone(ip))
+			}
+		}
+	}
+
+	sort.Slice(zones, func(i, j int) bool {
+		if len(zones[i]) != len(zones[j]) {
+			return len(zones[i]) > len(zones[j])
+		}
+		return zones[i] < zones[j]
+	})
+	return zones
+}
+
+// zoneFor returns the zone from a list sorted by recordZones that holds a
+// name, or an empty string if none of them do
+func zoneFor(name string, zones []string) string {
+	for _, zone := range zones {
+		if name == zone || strings.HasSuffix(name, "."+zone) {
+			return zone
+		}
+	}
+	return ""
+}
+
+// toRR converts a record into its wire format representation
+func (r dnsRecord) toRR(ttl uint32) (dns.RR, error) {
+	hdr := dns.RR_Header{Name: dns.Fqdn(r.Name), Class: dns.ClassINET, Ttl: ttl}
+	switch r.Type {
+	case "A":
+		ip := net.ParseIP(r.Content).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IPv4 address for %s: %s", r.Name, r.Content)
+		}
+		hdr.Rrtype = dns.TypeA
+		return &dns.A{Hdr: hdr, A: ip}, nil
+	case "AAAA":
+		ip := net.ParseIP(r.Content)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IPv6 address for %s: %s", r.Name, r.Content)
+		}
+		hdr.Rrtype = dns.TypeAAAA
+		return &dns.AAAA{Hdr: hdr, AAAA: ip}, nil
+	case "CNAME":
+		hdr.Rrtype = dns.TypeCNAME
+		return &dns.CNAME{Hdr: hdr, Target: dns.Fqdn(r.Content)}, nil
+	case "PTR":
+		hdr.Rrtype = dns.TypePTR
+		return &dns.PTR{Hdr: hdr, Ptr: dns.Fqdn(r.Content)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported record type %s for %s", r.Type, r.Name)
+	}
+}
@@ -0,0 +1,86 @@
+package scraper
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// reverse zones are created on the classful-ish boundaries that most home and
+// small office networks use: a /24 for IPv4 and a /64 for IPv6
+const (
+	reverseZoneBitsIPv4 = 24
+	reverseZoneBitsIPv6 = 64
+)
+
+// reverseName returns the in-addr.arpa or ip6.arpa name for an address
+func reverseName(addr netip.Addr) string {
+	return reverseLabels(addr, addr.BitLen())
+}
+
+// reverseZone returns the name of the reverse zone that holds an address
+func reverseZone(addr netip.Addr) string {
+	if addr.Is4() {
+		return reverseLabels(addr, reverseZoneBitsIPv4)
+	}
+	return reverseLabels(addr, reverseZoneBitsIPv6)
+}
+
+// build the reverse name for the first bits of an address. IPv4 addresses use
+// one label per octet and IPv6 addresses use one label per nibble.
+func reverseLabels(addr netip.Addr, bits int) string {
+	var labels []string
+	if addr.Is4() {
+		octets := addr.As4()
+		for i := bits/8 - 1; i >= 0; i-- {
+			labels = append(labels, fmt.Sprintf("%d", octets[i]))
+		}
+		return strings.Join(append(labels, "in-addr", "arpa"), ".")
+	}
+
+	octets := addr.As16()
+	for i := bits/4 - 1; i >= 0; i-- {
+		nibble := octets[i/2]
+		if i%2 == 0 {
+			nibble >>= 4
+		}
+		labels = append(labels, fmt.Sprintf("%x", nibble&0x0f))
+	}
+	return strings.Join(append(labels, "ip6", "arpa"), ".")
+}
+
+// primaryFQDN picks the name that a PTR record for a host should point to.
+// This is the first hostname of the host in the first entry of
+// Processing.Domains, followed by the domains from the [devices] block, or
+// the first domain with IPv6 enabled for IPv6 addresses. If no such name
+// exists, the first FQDN of the host is used.
+func primaryFQDN(hm *Hostmap, cfg *TomlConfig, ipv6 bool) string {
+	if len(hm.fqdns) == 0 {
+		return ""
+	}
+
+	for _, domain := range allDomains(cfg) {
+		if ipv6 && !ipv6Enabled(domain, cfg) {
+			continue
+		}
+		for _, hostname := range hm.hostnames {
+			fqdn := fmt.Sprintf("%s.%s", strings.ToLower(hostname), strings.ToLower(domain))
+			for _, candidate := range hm.fqdns {
+				if candidate == fqdn {
+					return fqdn
+				}
+			}
+		}
+	}
+
+	if ipv6 {
+		for _, fqdn := range hm.fqdns {
+			if ipv6Enabled(fqdn, cfg) {
+				return fqdn
+			}
+		}
+		return ""
+	}
+
+	return hm.fqdns[0]
+}
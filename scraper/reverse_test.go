@@ -0,0 +1,63 @@
+package scraper
+
+import (
+	"testing"
+)
+
+func TestReverseName(t *testing.T) {
+	tests := []struct {
+		ip       string
+		wantName string
+		wantZone string
+	}{
+		{
+			ip:       "192.168.1.100",
+			wantName: "100.1.168.192.in-addr.arpa",
+			wantZone: "1.168.192.in-addr.arpa",
+		},
+		{
+			ip:       "2001:db8::1",
+			wantName: "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa",
+			wantZone: "0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			ip := createIP(tt.ip)
+			if got := reverseName(ip); got != tt.wantName {
+				t.Errorf("reverseName() = %s, want %s", got, tt.wantName)
+			}
+			if got := reverseZone(ip); got != tt.wantZone {
+				t.Errorf("reverseZone() = %s, want %s", got, tt.wantZone)
+			}
+		})
+	}
+}
+
+func TestPrimaryFQDN(t *testing.T) {
+	cfg := &TomlConfig{
+		Processing: ProcessingConfig{
+			Domains:     []string{"example.com", "Local"},
+			IPv6Domains: []string{"local"},
+		},
+	}
+
+	hm := &Hostmap{
+		ip:        createIP("192.168.1.1"),
+		hostnames: []string{"Host1", "alias1"},
+	}
+	addDomainsToHostmap(hm, cfg.Processing.Domains)
+
+	if got := primaryFQDN(hm, cfg, false); got != "host1.example.com" {
+		t.Errorf("primaryFQDN() = %s, want host1.example.com", got)
+	}
+	if got := primaryFQDN(hm, cfg, true); got != "host1.local" {
+		t.Errorf("primaryFQDN() for IPv6 = %s, want host1.local", got)
+	}
+
+	cfg.Processing.IPv6Domains = nil
+	if got := primaryFQDN(hm, cfg, true); got != "" {
+		t.Errorf("primaryFQDN() for IPv6 without IPv6 domains = %s, want empty", got)
+	}
+}
@@ -0,0 +1,230 @@
+package scraper
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"gorm.io/gorm"
+)
+
+// DynamicUpdater sends the records of the hostmap to a primary nameserver
+// such as BIND or Knot using RFC 2136 dynamic updates. It remembers what it
+// sent before so each run only sends the records that were added or removed.
+// With a [state] block the records it sent are saved along with the hostmap,
+// so records that went away while the scraper was stopped are removed too.
+type DynamicUpdater struct {
+	// sent holds the records the server is known to have. Without a saved
+	// state it is empty after a restart, so the first run sends everything
+	// again.
+	sent   map[dnsRecord]bool
+	db     *gorm.DB
+	loaded bool
+	// settings are the settings the records were sent with, the outputs
+	// only keep the updater while these stay the same
+	settings RFC2136Config
+}
+
+// NewDynamicUpdater creates an updater that has not sent anything yet. The
+// database is only used to keep the saved state, and may be nil.
+func NewDynamicUpdater(db *gorm.DB) *DynamicUpdater {
+	return &DynamicUpdater{sent: make(map[dnsRecord]bool), db: db}
+}
+
+func (u *DynamicUpdater) Name() string { return "rfc2136" }
+
+// Save sends the changes to the hostmap, see Update
+func (u *DynamicUpdater) Save(hostmaps []*Hostmap, cfg *TomlConfig) error {
+	return u.Update(hostmaps, cfg)
+}
+
+// Update sends one UPDATE message per zone with the changes since the last
+// successful update of that zone. Zones that fail are retried on the next
+// run.
+func (u *DynamicUpdater) Update(hostmaps []*Hostmap, cfg *TomlConfig) error {
+	if cfg.RFC2136.Server == "" {
+		return fmt.Errorf("no server configured for dynamic updates")
+	}
+	if err := u.load(cfg); err != nil {
+		return fmt.Errorf("unable to load the dynamic updates of the saved state: %w", err)
+	}
+
+	ttl := cfg.RFC2136.TTL
+	if ttl == 0 {
+		ttl = defaultDNSTTL
+	}
+
+	zones := recordZones(hostmaps, cfg, cfg.RFC2136.ReverseZones)
+	records := collectRecords(hostmaps, cfg, cfg.RFC2136.ReverseZones)
+
+	current := make(map[dnsRecord]bool)
+	added := make(map[string][]dnsRecord)
+	for _, record := range records {
+		current[record] = true
+		if zone := zoneFor(record.Name, zones); zone != "" && !u.sent[record] {
+			added[zone] = append(added[zone], record)
+		}
+	}
+
+	var stale []dnsRecord
+	for record := range u.sent {
+		if !current[record] {
+			stale = append(stale, record)
+		}
+	}
+	sortRecords(stale)
+
+	removed := make(map[string][]dnsRecord)
+	for _, record := range stale {
+		zone := zoneFor(record.Name, zones)
+		if zone == "" {
+			// the zone no longer has any hosts, but still holds the records
+			// that were sent to it before
+			zone = zoneOfSent(record)
+			zones = append(zones, zone)
+		}
+		removed[zone] = append(removed[zone], record)
+	}
+
+	var errs []string
+	for _, zone := range zones {
+		if len(added[zone]) == 0 && len(removed[zone]) == 0 {
+			continue
+		}
+		if err := u.send(zone, added[zone], removed[zone], ttl, cfg); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", zone, err))
+			continue
+		}
+
+		for _, record := range removed[zone] {
+			delete(u.sent, record)
+		}
+		for _, record := range added[zone] {
+			u.sent[record] = true
+		}
+		logger.Infof("Sent dynamic update for %s: %d added, %d removed", zone, len(added[zone]), len(removed[zone]))
+	}
+
+	if err := u.save(cfg); err != nil {
+		errs = append(errs, fmt.Sprintf("unable to save the state: %s", err))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("dynamic update failed for %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// load reads the records that were sent from the saved state, once
+func (u *DynamicUpdater) load(cfg *TomlConfig) error {
+	if u.loaded {
+		return nil
+	}
+	data, err := readState(cfg, u.db, u.Name())
+	if err != nil {
+		return err
+	}
+	if len(data) > 0 {
+		var saved []dnsRecord
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return err
+		}
+		for _, record := range saved {
+			u.sent[record] = true
+		}
+	}
+	u.loaded = true
+	return nil
+}
+
+// save writes the records that were sent to the saved state, if there is one
+func (u *DynamicUpdater) save(cfg *TomlConfig) error {
+	if cfg.State.Filename == "" && !cfg.State.Database {
+		return nil
+	}
+	saved := make([]dnsRecord, 0, len(u.sent))
+	for record := range u.sent {
+		saved = append(saved, record)
+	}
+	sortRecords(saved)
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	return writeState(cfg, u.db, u.Name(), data)
+}
+
+// send builds, signs and sends a single UPDATE message for a zone
+func (u *DynamicUpdater) send(zone string, added []dnsRecord, removed []dnsRecord, ttl uint32, cfg *TomlConfig) error {
+	m := new(dns.Msg)
+	m.SetUpdate(dns.Fqdn(zone))
+
+	var remove []dns.RR
+	for _, record := range removed {
+		rr, err := record.toRR(ttl)
+		if err != nil {
+			return err
+		}
+		remove = append(remove, rr)
+	}
+	var insert []dns.RR
+	for _, record := range added {
+		rr, err := record.toRR(ttl)
+		if err != nil {
+			return err
+		}
+		insert = append(insert, rr)
+	}
+	// removals go first so a name can switch between a CNAME and addresses
+	// in a single update
+	m.Remove(remove)
+	m.Insert(insert)
+
+	network := strings.ToLower(cfg.RFC2136.Net)
+	if network == "" {
+		network = "tcp"
+	}
+	client := &dns.Client{Net: network, Timeout: 10 * time.Second}
+
+	if cfg.RFC2136.TsigName != "" {
+		name := dns.Fqdn(strings.ToLower(cfg.RFC2136.TsigName))
+		algorithm := dns.HmacSHA256
+		if cfg.RFC2136.TsigAlgorithm != "" {
+			algorithm = dns.Fqdn(strings.ToLower(cfg.RFC2136.TsigAlgorithm))
+		}
+		client.TsigSecret = map[string]string{name: cfg.RFC2136.TsigSecret}
+		m.SetTsig(name, algorithm, 300, time.Now().Unix())
+	}
+
+	r, _, err := client.Exchange(m, cfg.RFC2136.Server)
+	if err != nil {
+		return err
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("server returned %s", dns.RcodeToString[r.Rcode])
+	}
+	return nil
+}
+
+// zoneOfSent finds the zone for a record that was sent before but whose zone
+// no longer has any hosts, which only happens for reverse zones and domains
+// that were removed from the configuration
+func zoneOfSent(record dnsRecord) string {
+	if record.Type == "PTR" {
+		labels := strings.Split(record.Name, ".")
+		// strip the host part of the address to get the zone, see
+		// reverseZoneBitsIPv4 and reverseZoneBitsIPv6
+		if strings.HasSuffix(record.Name, ".in-addr.arpa") && len(labels) == 6 {
+			return strings.Join(labels[(32-reverseZoneBitsIPv4)/8:], ".")
+		}
+		if strings.HasSuffix(record.Name, ".ip6.arpa") && len(labels) == 34 {
+			return strings.Join(labels[(128-reverseZoneBitsIPv6)/4:], ".")
+		}
+	}
+	// fall back to the parent of the name
+	if i := strings.Index(record.Name, "."); i >= 0 {
+		return record.Name[i+1:]
+	}
+	return record.Name
+}
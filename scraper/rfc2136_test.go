@@ -0,0 +1,237 @@
+package scraper
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/withmandala/go-log"
+)
+
+// updateStub is a nameserver that records the dynamic updates it receives
+type updateStub struct {
+	mu       sync.Mutex
+	updates  []*dns.Msg
+	tsigErrs []error
+	rcode    int
+}
+
+func (s *updateStub) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+	if r.IsTsig() != nil {
+		if err := w.TsigStatus(); err != nil {
+			s.tsigErrs = append(s.tsigErrs, err)
+			m.Rcode = dns.RcodeNotAuth
+		} else {
+			tsig := r.IsTsig()
+			m.SetTsig(tsig.Hdr.Name, tsig.Algorithm, 300, int64(tsig.TimeSigned))
+		}
+	}
+	if m.Rcode == dns.RcodeSuccess {
+		m.Rcode = s.rcode
+		s.updates = append(s.updates, r)
+	}
+	w.WriteMsg(m)
+}
+
+// startUpdateStub runs the stub on a local TCP port
+func startUpdateStub(t *testing.T, secret string) (*updateStub, string) {
+	stub := &updateStub{}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := &dns.Server{
+		Listener:   l,
+		Handler:    stub,
+		TsigSecret: map[string]string{"scraper.": secret},
+		// the default only accepts queries and notifies
+		MsgAcceptFunc: func(dh dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+	}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+	return stub, l.Addr().String()
+}
+
+// rrStrings returns the update section of a message as strings
+func rrStrings(m *dns.Msg) []string {
+	var rrs []string
+	for _, rr := range m.Ns {
+		rrs = append(rrs, rr.String())
+	}
+	return rrs
+}
+
+// TestDynamicUpdater tests that only the differences are sent and signed
+func TestDynamicUpdater(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	secret := "c2VjcmV0IGZvciB0ZXN0aW5nIG9ubHk="
+	stub, addr := startUpdateStub(t, secret)
+
+	cfg := &TomlConfig{
+		Processing: ProcessingConfig{
+			Domains: []string{"example.com"},
+		},
+		RFC2136: RFC2136Config{
+			Server:       addr,
+			TsigName:     "scraper",
+			TsigSecret:   secret,
+			TTL:          60,
+			ReverseZones: true,
+		},
+	}
+
+	host1 := &Hostmap{ip: createIP("192.168.1.10"), hostnames: []string{"host1"}}
+	addDomainsToHostmap(host1, cfg.Processing.Domains)
+	host2 := &Hostmap{ip: createIP("192.168.1.11"), hostnames: []string{"host2"}}
+	addDomainsToHostmap(host2, cfg.Processing.Domains)
+
+	updater := NewDynamicUpdater(nil)
+	if err := updater.Update([]*Hostmap{host1, host2}, cfg); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if len(stub.tsigErrs) > 0 {
+		t.Fatalf("TSIG verification failed: %v", stub.tsigErrs)
+	}
+
+	// one update for the forward zone and one for the reverse zone
+	if len(stub.updates) != 2 {
+		t.Fatalf("Expected 2 updates, got %d", len(stub.updates))
+	}
+	zones := map[string][]string{}
+	for _, m := range stub.updates {
+		zones[m.Question[0].Name] = rrStrings(m)
+	}
+	if got := zones["example.com."]; len(got) != 2 ||
+		got[0] != "host1.example.com.\t60\tIN\tA\t192.168.1.10" ||
+		got[1] != "host2.example.com.\t60\tIN\tA\t192.168.1.11" {
+		t.Errorf("Unexpected update for example.com: %v", got)
+	}
+	if got := zones["1.168.192.in-addr.arpa."]; len(got) != 2 {
+		t.Errorf("Expected 2 PTR records for the reverse zone, got %v", got)
+	}
+
+	// nothing changed, so nothing is sent
+	stub.updates = nil
+	if err := updater.Update([]*Hostmap{host1, host2}, cfg); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if len(stub.updates) != 0 {
+		t.Errorf("Expected no updates without changes, got %d", len(stub.updates))
+	}
+
+	// host2 moves to a new address and host1 goes away entirely
+	host1.removalCode = Old
+	host2.ip = createIP("192.168.2.11")
+	stub.updates = nil
+	if err := updater.Update([]*Hostmap{host1, host2}, cfg); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	zones = map[string][]string{}
+	for _, m := range stub.updates {
+		zones[m.Question[0].Name] = rrStrings(m)
+	}
+	want := []string{
+		"host1.example.com.\t0\tNONE\tA\t192.168.1.10",
+		"host2.example.com.\t0\tNONE\tA\t192.168.1.11",
+		"host2.example.com.\t60\tIN\tA\t192.168.2.11",
+	}
+	if got := zones["example.com."]; len(got) != len(want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	} else {
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("Expected %s, got %s", want[i], got[i])
+			}
+		}
+	}
+	// the old reverse zone no longer has hosts but its records are removed
+	if got := zones["1.168.192.in-addr.arpa."]; len(got) != 2 {
+		t.Errorf("Expected 2 PTR removals for the old reverse zone, got %v", got)
+	}
+	if got := zones["2.168.192.in-addr.arpa."]; len(got) != 1 {
+		t.Errorf("Expected 1 PTR record for the new reverse zone, got %v", got)
+	}
+}
+
+// TestDynamicUpdaterRetry tests that failed updates are sent again
+func TestDynamicUpdaterRetry(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	stub, addr := startUpdateStub(t, "")
+	stub.rcode = dns.RcodeRefused
+
+	cfg := &TomlConfig{
+		Processing: ProcessingConfig{Domains: []string{"example.com"}},
+		RFC2136:    RFC2136Config{Server: addr},
+	}
+	host := &Hostmap{ip: createIP("192.168.1.10"), hostnames: []string{"host1"}}
+	addDomainsToHostmap(host, cfg.Processing.Domains)
+
+	updater := NewDynamicUpdater(nil)
+	if err := updater.Update([]*Hostmap{host}, cfg); err == nil {
+		t.Fatalf("Expected an error when the server refuses the update")
+	}
+
+	stub.rcode = dns.RcodeSuccess
+	stub.updates = nil
+	if err := updater.Update([]*Hostmap{host}, cfg); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if len(stub.updates) != 1 || len(stub.updates[0].Ns) != 1 {
+		t.Errorf("Expected the refused record to be sent again, got %v", stub.updates)
+	}
+}
+
+// TestDynamicUpdaterState tests that the records sent before a restart are
+// removed once their host moves, when the state is saved
+func TestDynamicUpdaterState(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	stub, addr := startUpdateStub(t, "")
+
+	cfg := &TomlConfig{
+		Processing: ProcessingConfig{Domains: []string{"example.com"}},
+		RFC2136:    RFC2136Config{Server: addr, TTL: 60},
+		State:      StateConfig{Filename: filepath.Join(t.TempDir(), "state.json")},
+	}
+	host := &Hostmap{ip: createIP("192.168.1.10"), hostnames: []string{"host1"}}
+	addDomainsToHostmap(host, cfg.Processing.Domains)
+
+	if err := NewDynamicUpdater(nil).Update([]*Hostmap{host}, cfg); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	// the scraper restarts and the host has moved in the meantime
+	host.ip = createIP("192.168.1.20")
+	stub.updates = nil
+	if err := NewDynamicUpdater(nil).Update([]*Hostmap{host}, cfg); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	want := []string{
+		"host1.example.com.\t0\tNONE\tA\t192.168.1.10",
+		"host1.example.com.\t60\tIN\tA\t192.168.1.20",
+	}
+	if len(stub.updates) != 1 {
+		t.Fatalf("Expected 1 update, got %d", len(stub.updates))
+	}
+	if got := rrStrings(stub.updates[0]); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
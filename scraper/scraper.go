@@ -1,6 +1,7 @@
 package scraper
 
 import (
+	"errors"
 	"fmt"
 	"net/netip"
 	"os"
@@ -12,6 +13,42 @@ import (
 	"github.com/withmandala/go-log"
 )
 
+type UnifiConfig struct {
+	// Name identifies the controller when there is more than one. Defaults
+	// to Host.
+	Name     string
+	Host     string
+	User     string
+	Password string
+	// CAFile is a PEM bundle with the CA that signed the certificate of the
+	// controller, for controllers with a private CA
+	CAFile string
+	// Fingerprint pins the SHA-256 fingerprint of the certificate of the
+	// controller, which is enough for a self-signed certificate
+	Fingerprint string
+	// Insecure turns off certificate verification when true and turns it on
+	// when false. When it isn't set, the certificate is only verified if
+	// CAFile or Fingerprint is set, like older versions that never verified
+	// it at all.
+	Insecure *bool
+	// ClientCert and ClientKey are PEM files with a client certificate
+	ClientCert string
+	ClientKey  string
+	// APIKey switches to the Network Integration API of UniFi OS consoles,
+	// which takes a read-only API key instead of User and Password
+	APIKey string
+	// Domains replaces Processing.Domains for the hosts of this controller
+	Domains []string
+	// Sites limits scraping to these sites, given by name or description.
+	// Every site is scraped when this is empty.
+	Sites []string
+	// ExcludeSites skips these sites
+	ExcludeSites []string
+	// SiteDomains replaces Processing.Domains for the hosts of a site, keyed
+	// by the name of the site
+	SiteDomains map[string][]string
+}
+
 type HostsfileConfig struct {
 	Filename string
 }
@@ -19,37 +56,217 @@ type HostsfileConfig struct {
 type DatabaseConfig struct {
 	Driver string
 	DSN    string
+	// ReverseZones enables PTR records in in-addr.arpa and ip6.arpa zones
+	ReverseZones bool
+	// StaleRecords controls what happens to records the scraper created for
+	// hosts that are gone: "keep" (the default), "disable" or "delete"
+	StaleRecords string
+	// Nameservers and Hostmaster are used for the SOA and NS records of the
+	// domains that the scraper creates
+	Nameservers []string
+	Hostmaster  string
+	// DomainType is the type of the domains that the scraper creates,
+	// NATIVE by default or MASTER to have PowerDNS notify secondaries
+	DomainType string
+	// SerialFormat is either "date" (YYYYMMDDnn, the default) or "increment"
+	SerialFormat string
+}
+
+type StateConfig struct {
+	// Filename is a local file to keep the hostmap in between restarts
+	Filename string
+	// Database keeps the hostmap in the configured database
+	Database bool
+}
+
+type DNSConfig struct {
+	// Listen is the address for the built-in DNS server, which answers on
+	// both UDP and TCP, for example ":53" or "127.0.0.1:5353"
+	Listen string
+	// TTL is the time to live of the answers, 300 seconds by default
+	TTL uint32
+	// ReverseZones answers PTR queries for the addresses of the hosts
+	ReverseZones bool
+	// Nameservers and Hostmaster are used for the SOA and NS records of the
+	// zones the server is authoritative for
+	Nameservers []string
+	Hostmaster  string
+}
+
+type RFC2136Config struct {
+	// Server is the primary nameserver that receives the updates, such as
+	// "ns1.example.com:53"
+	Server string
+	// Net is the transport for the updates, "tcp" (the default) or "udp"
+	Net string
+	// TsigName, TsigSecret (base64) and TsigAlgorithm sign the updates,
+	// the algorithm defaults to hmac-sha256
+	TsigName      string
+	TsigSecret    string
+	TsigAlgorithm string
+	// TTL is the time to live of the records, 300 seconds by default
+	TTL uint32
+	// ReverseZones also sends PTR records to the reverse zones of the hosts
+	ReverseZones bool
+}
+
+type ZonefileConfig struct {
+	// Directory is where the zone files are written, one <zone>.zone file
+	// for each domain
+	Directory string
+	// TTL is the default time to live of the records, 300 seconds by default
+	TTL uint32
+	// ReverseZones also writes the in-addr.arpa and ip6.arpa zones
+	ReverseZones bool
+	// Nameservers, Hostmaster and SerialFormat work as they do for the
+	// domains created in the database
+	Nameservers  []string
+	Hostmaster   string
+	SerialFormat string
+}
+
+type OutputConfig struct {
+	// Format is one of "hosts", "dnsmasq", "unbound" or "coredns"
+	Format   string
+	Filename string
+	// Sites limits the output to the hosts from these Unifi sites
+	Sites []string
+}
+
+type PiholeConfig struct {
+	// URL is the address of the Pi-hole web interface, for example
+	// "http://pi.hole"
+	URL      string
+	Password string
+}
+
+type AdGuardConfig struct {
+	// URL is the address of the AdGuard Home web interface, for example
+	// "http://192.168.1.2:3000"
+	URL      string
+	User     string
+	Password string
+}
+
+// HealthConfig controls how the daemon deals with failed scrapes
+type HealthConfig struct {
+	// Listen is the address for the /healthz and /readyz endpoints, for
+	// example ":8080"
+	Listen string
+	// MaxFailures is how many scrapes in a row may fail before the scraper
+	// is unhealthy, 5 by default
+	MaxFailures int
+	// Exit stops the scraper once it is unhealthy, which is off by default
+	Exit *bool
+}
+
+// DeviceConfig changes the names of one family of Unifi devices
+type DeviceConfig struct {
+	// Prefix is put in front of the name of each device, like "sw-"
+	Prefix string
+	// Domains replaces Processing.Domains for these devices
+	Domains []string
+}
+
+type DevicesConfig struct {
+	Switches DeviceConfig
+	APs      DeviceConfig
+	// Gateways covers USGs, UDMs and UXGs
+	Gateways DeviceConfig
+	PDUs     DeviceConfig
+	// GatewayInterfaces adds names for the WAN interfaces of gateways and
+	// their address on each network. Defaults to false.
+	GatewayInterfaces *bool
+}
+
+type ExpiryConfig struct {
+	// Clients, Switches, APs, Gateways, PDUs and Static override MaxAge for
+	// hosts from that source
+	Clients  Duration
+	Switches Duration
+	APs      Duration
+	Gateways Duration
+	PDUs     Duration
+	Static   Duration
+	// Grace is added to the retention of devices that are offline but still
+	// reported by the controller
+	Grace Duration
+}
+
+type ConflictsConfig struct {
+	// SameName is the policy for hosts with the same name on different IP
+	// addresses and SameIP for hosts with different names on the same IP
+	// address. One of "newest" (the default), "static", "wired" or
+	// "keep_both".
+	SameName string
+	SameIP   string
+}
+
+// DomainRule puts the names of the hosts that match every condition that is
+// set into other domains
+type DomainRule struct {
+	// Network is the name of the network of a client in the controller
+	Network string
+	// VLAN is the VLAN ID of a client
+	VLAN int
+	// Subnet matches hosts with an address in it, like "192.168.20.0/24"
+	Subnet string
+	// SSID is the name of the wireless network a client is connected to
+	SSID string
+	// Wired matches only wired or only wireless hosts
+	Wired *bool
+	// Domains replaces Processing.Domains for matching hosts
+	Domains []string
+}
+
+type ProcessingConfig struct {
+	Domains    []string
+	Additional []struct {
+		IP           string
+		Hostnames    []string
+		Name         string
+		KeepMultiple *bool
+	}
+	Blocked []struct {
+		IP   string
+		Name string
+	}
+	Cnames []struct {
+		Cname    string
+		Hostname string
+	}
+	KeepMacs bool
+	// KeepMultiple is the default for Additional entries that don't set it.
+	// When false, the hostnames of Additional entries are removed from any
+	// other hosts. Defaults to true.
+	KeepMultiple *bool
+	// IPv6Domains lists the entries from Domains that should also get IPv6
+	// (AAAA) records for hosts that have IPv6 addresses
+	IPv6Domains []string
+	// DomainRules pick the domains of hosts by their network, VLAN, subnet
+	// or SSID. The first rule that matches a host is used.
+	DomainRules []DomainRule
 }
 
 type TomlConfig struct {
-	Daemonize bool
-	Sleep     int
-	MaxAge    int
-	Unifi     struct {
-		Host     string
-		User     string
-		Password string
-	}
-	Processing struct {
-		Domains    []string
-		Additional []struct {
-			IP           string
-			Hostnames    []string
-			Name         string
-			KeepMultiple *bool
-		}
-		Blocked []struct {
-			IP   string
-			Name string
-		}
-		Cnames []struct {
-			Cname    string
-			Hostname string
-		}
-		KeepMacs bool
-	}
-	Hostsfile HostsfileConfig
-	Database  DatabaseConfig
+	Daemonize  bool
+	Sleep      int
+	MaxAge     Duration
+	Health     HealthConfig
+	Unifi      UnifiControllers
+	Processing ProcessingConfig
+	Devices    DevicesConfig
+	Expiry     ExpiryConfig
+	Conflicts  ConflictsConfig
+	Hostsfile  HostsfileConfig
+	Database   DatabaseConfig
+	State      StateConfig
+	DNS        DNSConfig
+	RFC2136    RFC2136Config
+	Zonefile   ZonefileConfig
+	Output     []OutputConfig
+	Pihole     PiholeConfig
+	AdGuard    AdGuardConfig
 }
 
 type RemovalCode int
@@ -61,13 +278,44 @@ const (
 	Old
 )
 
+// HostSource is where the scraper learned about a host
+type HostSource string
+
+const (
+	SourceStatic  HostSource = "static"
+	SourceClient  HostSource = "client"
+	SourceSwitch  HostSource = "switch"
+	SourceAP      HostSource = "ap"
+	SourceGateway HostSource = "gateway"
+	SourcePDU     HostSource = "pdu"
+)
+
 type Hostmap struct {
-	ip            netip.Addr
-	hostnames     []string
-	fqdns         []string
-	lastseen      time.Time
-	lastseenUnifi time.Time
-	removalCode   RemovalCode
+	ip             netip.Addr
+	ipv6           []netip.Addr
+	hostnames      []string
+	fqdns          []string
+	lastseen       time.Time
+	lastseenUnifi  time.Time
+	firstseenUnifi time.Time
+	removalCode    RemovalCode
+	source         HostSource
+	mac            string
+	site           string
+	// unifiHostname is the hostname the device reported to the controller,
+	// which may differ from the name given to it in the controller
+	unifiHostname string
+	// wired is true for hosts with a wired connection to the network
+	wired bool
+	// network, vlan and ssid describe where a client is connected
+	network string
+	vlan    int
+	ssid    string
+	// controller is the name of the Unifi controller that found the host
+	controller string
+	// iface is the name of the interface of a device this host is for, such
+	// as wan1, and empty for the device itself
+	iface string
 }
 
 // set up a global logger...
@@ -81,118 +329,179 @@ func SetLogger(l *log.Logger) {
 // UpdateConfigFromEnv checks for environment variables and updates the config accordingly.
 // If an environment variable is set, it will override the corresponding value in the TomlConfig.
 // This function also logs a warning if both the TomlConfig and environment variable are set.
+// The environment variables only apply to the first Unifi controller.
 func UpdateConfigFromEnv(cfg *TomlConfig) {
+	envUser := os.Getenv("SCRAPER_UNIFI_USER")
+	envPassword := os.Getenv("SCRAPER_UNIFI_PASSWORD")
+	envHost := os.Getenv("SCRAPER_UNIFI_HOST")
+	envAPIKey := os.Getenv("SCRAPER_UNIFI_API_KEY")
+	if envUser == "" && envPassword == "" && envHost == "" && envAPIKey == "" {
+		return
+	}
+
+	if len(cfg.Unifi) == 0 {
+		cfg.Unifi = append(cfg.Unifi, UnifiConfig{})
+	} else if len(cfg.Unifi) > 1 && logger != nil {
+		logger.Warnf("SCRAPER_UNIFI_* environment variables only apply to the first Unifi controller (%s)", controllerName(&cfg.Unifi[0]))
+	}
+	controller := &cfg.Unifi[0]
+
 	// Check for SCRAPER_UNIFI_USER
-	if envUser := os.Getenv("SCRAPER_UNIFI_USER"); envUser != "" {
-		if cfg.Unifi.User != "" && cfg.Unifi.User != envUser && logger != nil {
+	if envUser != "" {
+		if controller.User != "" && controller.User != envUser && logger != nil {
 			logger.Warnf("Unifi.User is defined in both TOML config (%s) and environment variable SCRAPER_UNIFI_USER (%s). Using environment variable.",
-				cfg.Unifi.User, envUser)
+				controller.User, envUser)
 		}
-		cfg.Unifi.User = envUser
+		controller.User = envUser
 	}
 
 	// Check for SCRAPER_UNIFI_PASSWORD
-	if envPassword := os.Getenv("SCRAPER_UNIFI_PASSWORD"); envPassword != "" {
-		if cfg.Unifi.Password != "" && cfg.Unifi.Password != envPassword && logger != nil {
+	if envPassword != "" {
+		if controller.Password != "" && controller.Password != envPassword && logger != nil {
 			logger.Warnf("Unifi.Password is defined in both TOML config and environment variable SCRAPER_UNIFI_PASSWORD. Using environment variable.")
 		}
-		cfg.Unifi.Password = envPassword
+		controller.Password = envPassword
 	}
 
 	// Check for SCRAPER_UNIFI_HOST
-	if envHost := os.Getenv("SCRAPER_UNIFI_HOST"); envHost != "" {
-		if cfg.Unifi.Host != "" && cfg.Unifi.Host != envHost && logger != nil {
+	if envHost != "" {
+		if controller.Host != "" && controller.Host != envHost && logger != nil {
 			logger.Warnf("Unifi.Host is defined in both TOML config (%s) and environment variable SCRAPER_UNIFI_HOST (%s). Using environment variable.",
-				cfg.Unifi.Host, envHost)
+				controller.Host, envHost)
 		}
-		cfg.Unifi.Host = envHost
-	}
-}
-
-func getUnifiElements(cfg *TomlConfig) ([]*unifi.Site, *unifi.Devices, []*unifi.Client, error) {
-	c := &unifi.Config{
-		User:     cfg.Unifi.User,
-		Pass:     cfg.Unifi.Password,
-		URL:      cfg.Unifi.Host,
-		ErrorLog: logger.Errorf,
-		DebugLog: logger.Debugf,
+		controller.Host = envHost
 	}
 
-	uni, err := unifi.NewUnifi(c)
-	if err != nil {
-		logger.Errorf("Error conncting to Unifi: %s", err)
-		logger.Warnf("Not updating list of hosts this round - will try again later")
-		return nil, nil, nil, err
+	// Check for SCRAPER_UNIFI_API_KEY
+	if envAPIKey != "" {
+		if controller.APIKey != "" && controller.APIKey != envAPIKey && logger != nil {
+			logger.Warnf("Unifi.APIKey is defined in both TOML config and environment variable SCRAPER_UNIFI_API_KEY. Using environment variable.")
+		}
+		controller.APIKey = envAPIKey
 	}
+}
 
-	sites, err := uni.GetSites()
-	if err != nil {
-		logger.Errorf("Error getting sites: %s", err)
-		logger.Warnf("Not updating list of hosts this round - will try again later")
-		return nil, nil, nil, err
-	}
+// controllerClient wraps a logged in connection to a Unifi controller so it
+// can provide the data that the unifi library does not decode for us
+type controllerClient struct {
+	*unifi.Unifi
+}
 
-	clients, err := uni.GetClients(sites)
+// connectUnifi logs into a controller. The login is done here rather than by
+// unifi.NewUnifi, which builds its own HTTP clients and so can't use the TLS
+// settings of the controller. UniFi OS consoles serve the Network application
+// below /proxy/network, which becomes part of the URL the library sees.
+func connectUnifi(controller *UnifiConfig) (*controllerClient, error) {
+	client, err := newControllerHTTPClient(controller)
 	if err != nil {
-		logger.Errorf("Error getting clients: %s", err)
-		return sites, nil, nil, err
+		logger.Errorf("Error setting up the connection to Unifi: %s", err)
+		return nil, err
+	}
+
+	host := strings.TrimRight(controller.Host, "/")
+	uni := &unifi.Unifi{
+		Client: client,
+		Config: &unifi.Config{
+			User:     controller.User,
+			Pass:     controller.Password,
+			URL:      host,
+			ErrorLog: logger.Errorf,
+			DebugLog: logger.Debugf,
+			Timeout:  apiTimeout,
+		},
+	}
+
+	unifiOS, err := isUniFiOS(client, host)
+	if err == nil {
+		if unifiOS {
+			uni.URL = host + unifi.APIPrefixNew
+			err = loginController(client, controller, unifi.APILoginPathNew)
+		} else {
+			err = loginController(client, controller, unifi.APILoginPath)
+		}
 	}
-
-	devices, err := uni.GetDevices(sites)
 	if err != nil {
-		logger.Errorf("Error getting devices: %s", err)
-		return sites, nil, clients, err
+		logger.Errorf("Error conncting to Unifi: %s", err)
+		logger.Warnf("Not updating list of hosts this round - will try again later")
+		return nil, err
 	}
 
-	logger.Infof("%d Unifi Sites Found", len(sites))
-	// for i, site := range sites {
-	// 	logger.Infof("%d, %s %s", i+1, site.Name)
-	// }
-
-	logger.Infof("%d Clients connected", len(clients))
-	// for i, client := range clients {
-	// 	logger.Infof("%d, %s %s %s %s %d", i+1, client.ID, client.Hostname, client.IP, client.Name, client.LastSeen)
-	// }
+	return &controllerClient{uni}, nil
+}
 
-	logger.Infof("%d Unifi Switches Found", len(devices.USWs))
-	// for i, usw := range devices.USWs {
-	// 	logger.Infof("%d %s %s", i+1, usw.Name, usw.IP)
-	// }
+// GetClientIPv6 returns the IPv6 addresses the controller has recorded for
+// each client, keyed by the lowercase MAC address of the client. The unifi
+// library does not decode the ipv6_addresses field, so this makes a second
+// request against the client list.
+func (c *controllerClient) GetClientIPv6(sites []*unifi.Site) (map[string][]string, error) {
+	addresses := make(map[string][]string)
+
+	for _, site := range sites {
+		var response struct {
+			Data []struct {
+				Mac           string   `json:"mac"`
+				IPv6Addresses []string `json:"ipv6_addresses"`
+			} `json:"data"`
+		}
 
-	logger.Infof("%d Unifi Gateways Found", len(devices.USGs))
+		if err := c.GetData(fmt.Sprintf(unifi.APIClientPath, site.Name), &response); err != nil {
+			return nil, err
+		}
 
-	logger.Infof("%d Unifi Wireless APs Found", len(devices.UAPs))
-	// for i, uap := range devices.UAPs {
-	// 	logger.Infof("%d %s %s", i+1, uap.Name, uap.IP)
-	// }
+		for _, client := range response.Data {
+			if len(client.IPv6Addresses) > 0 {
+				mac := strings.ToLower(client.Mac)
+				addresses[mac] = append(addresses[mac], client.IPv6Addresses...)
+			}
+		}
+	}
 
-	return sites, devices, clients, nil
+	return addresses, nil
 }
 
+// GenerateHostsFile scrapes every controller in cfg.Unifi and merges what
+// they found into the hostmap
 func GenerateHostsFile(cfg *TomlConfig, hostmaps []*Hostmap) ([]*Hostmap, error) {
-
-	logger.Infof("Starting new host file generation")
-	logger.Infof("%d existing hosts in the hostmap", len(hostmaps))
-
-	_, devices, clients, err := getUnifiElements(cfg)
-	if err != nil {
-		logger.Errorf("Error getting Unifi elements: %s", err)
+	if len(cfg.Unifi) == 0 {
+		return hostmaps, errors.New("no Unifi controller configured")
+	}
+	if err := checkControllerNames(cfg.Unifi); err != nil {
 		return hostmaps, err
 	}
 
-	fullHostmap := createHostmap(clients, devices.USWs, devices.UAPs, cfg, hostmaps)
-
-	return fullHostmap, nil
+	// the sessions outlive this call, so in daemon mode each controller is
+	// only logged into again when its session expires
+	return generateHostmap(cfg, hostmaps, cfg.Unifi, func(i int) (UnifiClientInterface, error) {
+		controller := cfg.Unifi[i]
+		return sessionFor(&controller, func() (UnifiClientInterface, error) {
+			if controller.APIKey != "" {
+				return newIntegrationClient(&controller)
+			}
+			return connectUnifi(&controller)
+		})
+	})
 }
 
 func SaveHostsFile(hostmaps []*Hostmap, cfg *TomlConfig) error {
-	var builder strings.Builder
-
 	if cfg.Hostsfile.Filename == "" {
 		logger.Warn("Hostfile output filename is nil - skipping")
 		return nil
 	}
 
+	err := os.WriteFile(cfg.Hostsfile.Filename, []byte(renderHostsFile(hostmaps, cfg)), 0666)
+	if err != nil {
+		return err
+	}
+	logger.Infof("Wrote %d hosts to %s", len(hostmaps), cfg.Hostsfile.Filename)
+
+	return nil
+}
+
+// renderHostsFile builds the contents of a hosts file. CNAMEs are flattened
+// onto the line of their target as the format has no way to express them.
+func renderHostsFile(hostmaps []*Hostmap, cfg *TomlConfig) string {
+	var builder strings.Builder
+
 	builder.WriteString("# This file created by unifi-dns-scraper\n")
 	builder.WriteString("# Do not manually edit\n\n")
 
@@ -238,101 +547,64 @@ func SaveHostsFile(hostmaps []*Hostmap, cfg *TomlConfig) error {
 		allNames = append(allNames, additionalNames...)
 
 		builder.WriteString(fmt.Sprintf("%s %s\n", hm.ip, strings.Join(allNames, " ")))
-	}
 
-	err := os.WriteFile(cfg.Hostsfile.Filename, []byte(builder.String()), 0666)
-	if err != nil {
-		logger.Fatal(err)
-		return err
+		// IPv6 addresses only get the names from domains with IPv6 enabled
+		var ipv6Names []string
+		for _, name := range allNames {
+			if ipv6Enabled(name, cfg) {
+				ipv6Names = append(ipv6Names, name)
+			}
+		}
+		if len(ipv6Names) == 0 {
+			continue
+		}
+		for _, ip := range hm.ipv6 {
+			builder.WriteString(fmt.Sprintf("%s %s\n", ip, strings.Join(ipv6Names, " ")))
+		}
 	}
-	logger.Infof("Wrote %d hosts to %s", len(hostmaps), cfg.Hostsfile.Filename)
 
-	return nil
+	return builder.String()
 }
 
-// iterate over the hostmap and add all the FQDNs to each host on the hostmap
-func addDomainsToHostmap(m *Hostmap, domains []string) *Hostmap {
-	for _, domain := range domains {
-		for _, hostname := range m.hostnames {
-			m.fqdns = append(m.fqdns, fmt.Sprintf("%s.%s", strings.ToLower(hostname), strings.ToLower(domain)))
+// parse the IPv6 addresses reported for a client, skipping anything that
+// isn't a routable IPv6 address. Link-local addresses are useless in DNS.
+func parseIPv6Addresses(addresses []string) []netip.Addr {
+	var parsed []netip.Addr
+	seen := make(map[netip.Addr]bool)
+	for _, address := range addresses {
+		ip, err := netip.ParseAddr(address)
+		if err != nil || !ip.Is6() || ip.Is4In6() || ip.IsLinkLocalUnicast() {
+			logger.Debugf("skipping IPv6 address: %s", address)
+			continue
 		}
-	}
-	return m
-}
-
-// given a hostmap, remove entries that are older than the lastseen time
-// this iterates over all of the hosts and if we have different IP addresses
-// for the same hostname, then we will only keep the most recent one
-func removeOldHosts(m []*Hostmap) []*Hostmap {
-	// create a dictionary for hosts
-	hosts := make(map[string]*Hostmap)
-
-	for _, host := range m {
-		// check if host is in the dictionary
-		if _, ok := hosts[host.ip.String()]; ok {
-			// if it is, then check if the lastseen time is newer
-			if host.lastseen.After(hosts[host.hostnames[0]].lastseen) {
-				// if it is, then replace the existing entry
-				hosts[host.hostnames[0]] = host
-			}
-		} else {
-			// if it isn't, then add it
-			hosts[host.hostnames[0]] = host
+		if !seen[ip] {
+			seen[ip] = true
+			parsed = append(parsed, ip)
 		}
 	}
-
-	// create a new slices of hostsmaps from the dictionary
-	var newhosts []*Hostmap
-	for _, host := range hosts {
-		newhosts = append(newhosts, host)
-	}
-
-	return newhosts
+	return parsed
 }
 
-// given a hostmap, remove entires that share the same IP address
-// this iterates over all of the hosts and if two share the same
-// IP address - it keeps only the IP address that is the most recent
-func removeDuplicateHosts(m []*Hostmap) []*Hostmap {
-	// create a dictionary for hosts
-	hosts := make(map[string]*Hostmap)
-	duplicate_count := 0
-	for _, host := range m {
-		// check if host is in the dictionary
-		if _, ok := hosts[host.ip.String()]; ok {
-			duplicate_count++
-			// if it is, then check if the lastseen time is newer
-			if host.lastseen.After(hosts[host.ip.String()].lastseen) {
-				// if it is, then replace the existing entry
-				hosts[host.ip.String()] = host
-			}
-		} else {
-			// if it isn't, then add it
-			hosts[host.ip.String()] = host
+// check to see if a name falls under one of the domains that have IPv6
+// records enabled
+func ipv6Enabled(name string, cfg *TomlConfig) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for _, domain := range cfg.Processing.IPv6Domains {
+		domain = strings.ToLower(strings.Trim(domain, "."))
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
 		}
 	}
-
-	// create a new slices of hostsmaps from the dictionary
-	var newhosts []*Hostmap
-	for _, host := range hosts {
-		newhosts = append(newhosts, host)
-	}
-
-	logger.Infof("Removed %d duplicate hosts", duplicate_count)
-	return newhosts
+	return false
 }
 
-// remove all hosts from the hostmap that have not been seen in d seconds
-func removeOldHostsByTime(m []*Hostmap, d time.Duration) []*Hostmap {
-	removed_hosts := 0
-	for _, host := range m {
-		if time.Since(host.lastseen) > d {
-			host.removalCode = Old
-			removed_hosts++
+// iterate over the hostmap and add all the FQDNs to each host on the hostmap
+func addDomainsToHostmap(m *Hostmap, domains []string) *Hostmap {
+	for _, domain := range domains {
+		for _, hostname := range m.hostnames {
+			m.fqdns = append(m.fqdns, fmt.Sprintf("%s.%s", strings.ToLower(hostname), strings.ToLower(domain)))
 		}
 	}
-
-	logger.Infof("Removed %d old hosts", removed_hosts)
 	return m
 }
 
@@ -419,104 +691,136 @@ func checkBlocked(h *Hostmap, cfg *TomlConfig) bool {
 	return false
 }
 
+// HostnameConflict records a hostname that a host lost to an Additional entry
+// with KeepMultiple set to false
+type HostnameConflict struct {
+	Hostname string
+	// LostIP and LostMAC identify the host that had the hostname removed
+	LostIP  netip.Addr
+	LostMAC string
+	// StaticIP is the address of the Additional entry that owns the hostname
+	StaticIP netip.Addr
+}
+
+// keepMultiple checks if a hostname from an Additional entry may also be used
+// by other hosts. Entries without KeepMultiple use Processing.KeepMultiple,
+// which defaults to true.
+func keepMultiple(value *bool, cfg *TomlConfig) bool {
+	if value != nil {
+		return *value
+	}
+	if cfg.Processing.KeepMultiple != nil {
+		return *cfg.Processing.KeepMultiple
+	}
+	return true
+}
+
 // ResolveAdditionalHostConflicts handles conflicts between Additional entries and
 // other hosts with the same hostname but different IPs.
-// If an Additional entry's hostname should be exclusive, only that entry's hostname will
-// be kept and any other hosts with the same hostname but different IPs are removed
+// If an Additional entry has KeepMultiple set to false, only that entry keeps its
+// hostnames and they are removed from any other hosts with different IPs. Each
+// hostname that is removed is listed in a conflict report in the log.
 func ResolveAdditionalHostConflicts(hostmaps []*Hostmap, cfg *TomlConfig) []*Hostmap {
+	hostmaps, conflicts := resolveAdditionalHostConflicts(hostmaps, cfg)
+
+	if len(conflicts) > 0 {
+		logger.Infof("Removed %d hostnames due to Additional entry exclusivity (KeepMultiple=false):", len(conflicts))
+		for _, conflict := range conflicts {
+			lost := conflict.LostIP.String()
+			if conflict.LostMAC != "" {
+				lost = fmt.Sprintf("%s (%s)", lost, conflict.LostMAC)
+			}
+			logger.Infof("  %s: removed from %s, belongs to %s", conflict.Hostname, lost, conflict.StaticIP)
+		}
+	}
+
+	return hostmaps
+}
+
+// resolveAdditionalHostConflicts does the work of ResolveAdditionalHostConflicts
+// and returns the hostnames that were removed
+func resolveAdditionalHostConflicts(hostmaps []*Hostmap, cfg *TomlConfig) ([]*Hostmap, []HostnameConflict) {
 	// Create a map to track which hostnames should be exclusive to Additional entries
 	exclusiveHostnames := make(map[string]netip.Addr)
 
-	// First, identify hostnames from Additional entries that should be exclusive
 	for _, additional := range cfg.Processing.Additional {
-		// Since we can't use KeepMultiple from the struct directly in the existing tests,
-		// we'll use a heuristic to determine if an entry should be exclusive.
-
-		// For this implementation, we'll make Additional hostnames with "unifi" exclusive
-		keepMultiple := true // Default to true for backward compatibility
-
-		// Check if this is a special hostname that should be exclusive
-		hostname := ""
-		if len(additional.Hostnames) > 0 {
-			hostname = additional.Hostnames[0]
-		} else {
-			hostname = additional.Name
+		if keepMultiple(additional.KeepMultiple, cfg) {
+			continue
 		}
 
-		// By default, make the "unifi" hostname be exclusive (hostname from sample config)
-		if strings.Contains(strings.ToLower(hostname), "unifi") {
-			keepMultiple = false
+		// This hostname should only resolve to the IP in the Additional entry
+		ip, err := netip.ParseAddr(additional.IP)
+		if err != nil {
+			continue // Skip invalid IPs - already logged in createHostmap
 		}
 
-		if !keepMultiple {
-			// This hostname should only resolve to the IP in the Additional entry
-			ip, err := netip.ParseAddr(additional.IP)
-			if err != nil {
-				continue // Skip invalid IPs - already logged in createHostmap
-			}
-
-			// Add the hostname and its variants to our exclusive map
-			if len(additional.Hostnames) > 0 {
-				for _, hostname := range additional.Hostnames {
-					exclusiveHostnames[strings.ToLower(hostname)] = ip
-				}
-			} else {
-				exclusiveHostnames[strings.ToLower(additional.Name)] = ip
+		if len(additional.Hostnames) > 0 {
+			for _, hostname := range additional.Hostnames {
+				exclusiveHostnames[strings.ToLower(hostname)] = ip
 			}
+		} else {
+			exclusiveHostnames[strings.ToLower(additional.Name)] = ip
 		}
 	}
 
 	// If there are no exclusive hostnames, return unchanged
 	if len(exclusiveHostnames) == 0 {
-		return hostmaps
+		return hostmaps, nil
 	}
 
-	// Count entries we'll need to modify
-	conflictCount := 0
-
-	// Now check all hostmaps for these exclusive hostnames
+	var conflicts []HostnameConflict
 	for _, host := range hostmaps {
-		for i, hostname := range host.hostnames {
-			lowercaseName := strings.ToLower(hostname)
-
-			// Check if this hostname is supposed to be exclusive to an Additional entry
-			if exclusiveIP, exists := exclusiveHostnames[lowercaseName]; exists {
-				// If the IP doesn't match, this is a conflict
-				if host.ip.Compare(exclusiveIP) != 0 { // Compare returns 0 when equal
-					// Remove this hostname from this host's hostnames slice
-					host.hostnames = append(host.hostnames[:i], host.hostnames[i+1:]...)
-					conflictCount++
-
-					// If this host has no more hostnames, mark it for removal
-					if len(host.hostnames) == 0 {
-						host.removalCode = Blocked // Using Blocked code for now
-					}
-
-					// We modified the slice, so break to avoid index issues
-					// We'll handle this host in the next pass if there are more hostnames
-					break
-				}
+		var hostnames []string
+		for _, hostname := range host.hostnames {
+			exclusiveIP, exists := exclusiveHostnames[strings.ToLower(hostname)]
+			if !exists || host.ip.Compare(exclusiveIP) == 0 {
+				hostnames = append(hostnames, hostname)
+				continue
 			}
+			conflicts = append(conflicts, HostnameConflict{
+				Hostname: hostname,
+				LostIP:   host.ip,
+				LostMAC:  host.mac,
+				StaticIP: exclusiveIP,
+			})
 		}
-	}
 
-	if conflictCount > 0 {
-		logger.Infof("Removed %d hostnames due to Additional entry exclusivity (KeepMultiple=false)", conflictCount)
+		if len(hostnames) == len(host.hostnames) {
+			continue
+		}
+		if len(hostnames) == 0 {
+			// keep the hostnames so the host can still be identified, but
+			// don't use it anymore
+			host.removalCode = Blocked
+			continue
+		}
+		host.hostnames = hostnames
+		host.fqdns = nil
+		addDomainsToHostmap(host, hostDomains(host, cfg))
 	}
 
-	return hostmaps
+	return hostmaps, conflicts
 }
 
-func createHostmap(clients []*unifi.Client, switches []*unifi.USW, aps []*unifi.UAP, cfg *TomlConfig, hostmaps []*Hostmap) []*Hostmap {
+func createHostmap(scrapes []controllerScrape, cfg *TomlConfig, hostmaps []*Hostmap) []*Hostmap {
 
 	if hostmaps == nil {
 		hostmaps = []*Hostmap{}
 	}
 
+	// every host in this scrape gets the same time, which tells them apart
+	// from the hosts that were carried over from earlier scrapes
+	now := time.Now()
+
+	checkDomainRules(cfg)
+
 	// add in any of the statically defined hosts
+	var static []*Hostmap
 	for _, additional := range cfg.Processing.Additional {
 		var m Hostmap
 		var err error
+		m.lastseen = now
+		m.source = SourceStatic
 		m.ip, err = netip.ParseAddr(additional.IP)
 		if err != nil {
 			logger.Warnf("unable to parse IP address: %s", additional.IP)
@@ -527,55 +831,45 @@ func createHostmap(clients []*unifi.Client, switches []*unifi.USW, aps []*unifi.
 		} else {
 			m.hostnames = append(m.hostnames, additional.Name)
 		}
-		hostmaps = append(hostmaps, addDomainsToHostmap(&m, cfg.Processing.Domains))
+		static = append(static, addDomainsToHostmap(&m, hostDomains(&m, cfg)))
 	}
+	hostmaps = append(removeUnconfiguredHosts(hostmaps, static, cfg), static...)
 
-	for i, client := range clients {
-		// logger.Infof("%d, %s %s %s %s %d", i+1, client.ID, client.Hostname, client.IP, client.Name, client.LastSeen)
-		var m Hostmap
-		var err error
-		m.lastseenUnifi = time.Unix(int64(client.LastSeen.Val), 0)
-		m.lastseen = time.Now()
-		m.ip, err = netip.ParseAddr(client.IP)
-		if err != nil {
-			logger.Warnf("Error Parsing Record: line=%d, ID=%s, hostname=%s, IP=%s, name=%s, lastseen=%f", i+1, client.ID, client.Hostname, client.IP, client.Name, client.LastSeen.Val)
-			continue
-		}
-		m.hostnames = append(m.hostnames, client.Name)
-		hostmaps = append(hostmaps, addDomainsToHostmap(&m, cfg.Processing.Domains))
-	}
-
-	for _, usw := range switches {
-		var m Hostmap
-		var err error
-		m.ip, err = netip.ParseAddr(usw.IP)
-
-		m.lastseenUnifi = time.Unix(int64(usw.LastSeen.Val), 0)
-		m.lastseen = time.Now()
-
-		if err != nil {
-			logger.Warnf("unable to parse IP address: %s", usw.IP)
-			continue
+	for _, scrape := range scrapes {
+		for i, client := range scrape.clients {
+			// logger.Infof("%d, %s %s %s %s %d", i+1, client.ID, client.Hostname, client.IP, client.Name, client.LastSeen)
+			var m Hostmap
+			var err error
+			if client.LastSeen.Val > 0 {
+				m.lastseenUnifi = time.Unix(int64(client.LastSeen.Val), 0)
+			}
+			m.lastseen = now
+			m.ip, err = netip.ParseAddr(client.IP)
+			if err != nil {
+				logger.Warnf("Error Parsing Record: line=%d, ID=%s, hostname=%s, IP=%s, name=%s, lastseen=%f", i+1, client.ID, client.Hostname, client.IP, client.Name, client.LastSeen.Val)
+				continue
+			}
+			m.ipv6 = parseIPv6Addresses(scrape.clientIPv6[strings.ToLower(client.Mac)])
+			m.source = SourceClient
+			m.controller = scrape.controller
+			m.mac = strings.ToLower(client.Mac)
+			m.site = siteKey(client.SiteName)
+			m.unifiHostname = client.Hostname
+			m.wired = client.IsWired.Val
+			m.network = client.Network
+			m.vlan = int(client.Vlan.Val)
+			m.ssid = client.Essid
+			if client.FirstSeen.Val > 0 {
+				m.firstseenUnifi = time.Unix(int64(client.FirstSeen.Val), 0)
+			}
+			m.hostnames = append(m.hostnames, client.Name)
+			hostmaps = append(hostmaps, addDomainsToHostmap(&m, hostDomains(&m, cfg)))
 		}
 
-		m.hostnames = append(m.hostnames, usw.Name)
-		hostmaps = append(hostmaps, addDomainsToHostmap(&m, cfg.Processing.Domains))
-	}
-
-	for _, ap := range aps {
-		var m Hostmap
-		var err error
-		m.ip, err = netip.ParseAddr(ap.IP)
-		m.lastseenUnifi = time.Unix(int64(ap.LastSeen.Val), 0)
-		m.lastseen = time.Now()
-
-		if err != nil {
-			logger.Warnf("unable to parse IP address: %s", ap.IP)
-			continue
+		for _, device := range collectDevices(scrape.devices, cfg) {
+			device.controller = scrape.controller
+			hostmaps = append(hostmaps, deviceHostmaps(device, cfg, now)...)
 		}
-
-		m.hostnames = append(m.hostnames, ap.Name)
-		hostmaps = append(hostmaps, addDomainsToHostmap(&m, cfg.Processing.Domains))
 	}
 
 	// Process entries in specific order:
@@ -589,15 +883,13 @@ func createHostmap(clients []*unifi.Client, switches []*unifi.USW, aps []*unifi.
 	// 3. Apply hostname exclusivity for Additional entries
 	hostmaps = ResolveAdditionalHostConflicts(hostmaps, cfg)
 
-	// 4. Remove duplicates and old entries
-	hostmaps = removeDuplicateHosts(hostmaps)
-	hostmaps = removeOldHosts(hostmaps)
+	// 4. Expire old entries
+	hostmaps = removeExpiredHosts(hostmaps, cfg, now)
 
-	if cfg.MaxAge > 0 {
-		hostmaps = removeOldHostsByTime(hostmaps, time.Duration(cfg.MaxAge))
-	}
+	// 5. Resolve hosts that share an IP address or a name
+	hostmaps = resolveConflicts(hostmaps, cfg)
 
-	sort.Slice(hostmaps, func(i, j int) bool {
+	sort.SliceStable(hostmaps, func(i, j int) bool {
 		return hostmaps[i].ip.Less(hostmaps[j].ip)
 	})
 
@@ -2,6 +2,7 @@ package scraper
 
 import (
 	"fmt"
+	"os"
 	"testing"
 	"time"
 
@@ -16,21 +17,11 @@ func TestGenerateHostsFileWithMock(t *testing.T) {
 	mock.AddClient("client2", "192.168.1.101", float64(time.Now().Unix()))
 	mock.AddSwitch("switch1", "192.168.1.2", float64(time.Now().Unix()))
 	mock.AddAP("ap1", "192.168.1.3", float64(time.Now().Unix()))
+	mock.AddGateway("udm", "192.168.1.254", float64(time.Now().Unix()))
 
 	// Create a test config
 	config := &TomlConfig{
-		Processing: struct {
-			Domains    []string
-			Additional []struct {
-				IP           string
-				Hostnames    []string
-				Name         string
-				KeepMultiple *bool
-			}
-			Blocked  []struct{ IP, Name string }
-			Cnames   []struct{ Cname, Hostname string }
-			KeepMacs bool
-		}{
+		Processing: ProcessingConfig{
 			Domains: []string{"test.local"},
 			Additional: []struct {
 				IP           string
@@ -57,7 +48,7 @@ func TestGenerateHostsFileWithMock(t *testing.T) {
 	}
 
 	// Verify the results
-	expectedHosts := 5 // 2 clients + 1 switch + 1 AP + 1 additional
+	expectedHosts := 6 // 2 clients + 1 switch + 1 AP + 1 gateway + 1 additional
 	validHosts := 0
 	for _, host := range hostmaps {
 		if host.removalCode == NotRemoved {
@@ -123,18 +114,7 @@ func TestErrorHandlingWithMock(t *testing.T) {
 
 	// Create a test config
 	config := &TomlConfig{
-		Processing: struct {
-			Domains    []string
-			Additional []struct {
-				IP           string
-				Hostnames    []string
-				Name         string
-				KeepMultiple *bool
-			}
-			Blocked  []struct{ IP, Name string }
-			Cnames   []struct{ Cname, Hostname string }
-			KeepMacs bool
-		}{
+		Processing: ProcessingConfig{
 			Domains: []string{"test.local"},
 		},
 	}
@@ -169,3 +149,125 @@ func TestErrorHandlingWithMock(t *testing.T) {
 		})
 	}
 }
+
+func TestGenerateHostsFileWithMockIPv6(t *testing.T) {
+	mock := NewMockUnifiClient()
+	mock.AddSite("Default")
+	mock.AddClientWithIPv6("client1", "AA:BB:CC:00:00:01", "192.168.1.100",
+		[]string{"2001:db8::100", "fe80::1", "not-an-ip"}, float64(time.Now().Unix()))
+	mock.AddClient("client2", "192.168.1.101", float64(time.Now().Unix()))
+
+	config := &TomlConfig{
+		Processing: ProcessingConfig{
+			Domains:     []string{"test.local"},
+			IPv6Domains: []string{"test.local"},
+		},
+	}
+
+	if logger == nil {
+		logger = log.New(nil)
+	}
+
+	hostmaps, err := GenerateHostsFileWithClient(config, nil, mock)
+	if err != nil {
+		t.Fatalf("GenerateHostsFileWithClient() error = %v", err)
+	}
+
+	for _, host := range hostmaps {
+		switch host.hostnames[0] {
+		case "client1":
+			if len(host.ipv6) != 1 || host.ipv6[0].String() != "2001:db8::100" {
+				t.Errorf("Expected client1 to have only 2001:db8::100, got %v", host.ipv6)
+			}
+		case "client2":
+			if len(host.ipv6) != 0 {
+				t.Errorf("Expected client2 to have no IPv6 addresses, got %v", host.ipv6)
+			}
+		}
+	}
+}
+
+// TestGenerateHostsFileWithoutLastSeen tests that clients and devices the
+// controller gives no last seen time expire by the time of the scrape
+func TestGenerateHostsFileWithoutLastSeen(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	mock := NewMockUnifiClient()
+	mock.AddSite("Default")
+	mock.AddClient("client1", "192.168.1.100", 0)
+	mock.AddSwitch("switch1", "192.168.1.2", 0)
+
+	config := &TomlConfig{
+		Processing: ProcessingConfig{Domains: []string{"test.local"}},
+		MaxAge:     Duration{time.Hour},
+	}
+
+	hostmaps, err := GenerateHostsFileWithClient(config, nil, mock)
+	if err != nil {
+		t.Fatalf("GenerateHostsFileWithClient() error = %v", err)
+	}
+	if len(hostmaps) != 2 {
+		t.Fatalf("Expected 2 hosts, got %d", len(hostmaps))
+	}
+	for _, host := range hostmaps {
+		if host.removalCode != NotRemoved || !host.lastseenUnifi.IsZero() {
+			t.Errorf("Host %v removal code %v, last seen by Unifi %s, want it kept without a time", host.hostnames, host.removalCode, host.lastseenUnifi)
+		}
+	}
+}
+
+// TestGenerateHostsFileRemovedAdditional tests that a host from Additional
+// goes away once its entry is removed from the configuration, unless the
+// static retention keeps it for a while
+func TestGenerateHostsFileRemovedAdditional(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	mock := NewMockUnifiClient()
+	mock.AddSite("Default")
+
+	config := &TomlConfig{
+		Processing: ProcessingConfig{
+			Domains: []string{"test.local"},
+			Additional: []struct {
+				IP           string
+				Hostnames    []string
+				Name         string
+				KeepMultiple *bool
+			}{
+				{IP: "192.168.1.10", Name: "nas"},
+				{IP: "192.168.1.11", Name: "printer"},
+			},
+		},
+	}
+
+	hostmaps, err := GenerateHostsFileWithClient(config, nil, mock)
+	if err != nil {
+		t.Fatalf("GenerateHostsFileWithClient() error = %v", err)
+	}
+	if len(hostmaps) != 2 {
+		t.Fatalf("Expected 2 hosts, got %d", len(hostmaps))
+	}
+
+	config.Processing.Additional = config.Processing.Additional[:1]
+	config.Expiry.Static = Duration{time.Hour}
+	kept, err := GenerateHostsFileWithClient(config, hostmaps, mock)
+	if err != nil {
+		t.Fatalf("GenerateHostsFileWithClient() error = %v", err)
+	}
+	if len(kept) != 2 || kept[1].removalCode != NotRemoved {
+		t.Fatalf("Expected the printer to be kept for the static retention, got %d hosts", len(kept))
+	}
+
+	config.Expiry.Static = Duration{}
+	removed, err := GenerateHostsFileWithClient(config, kept, mock)
+	if err != nil {
+		t.Fatalf("GenerateHostsFileWithClient() error = %v", err)
+	}
+	if len(removed) != 1 || removed[0].hostnames[0] != "nas" {
+		t.Errorf("Expected only nas after printer was removed from the configuration, got %d hosts", len(removed))
+	}
+}
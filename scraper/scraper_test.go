@@ -2,8 +2,13 @@ package scraper
 
 import (
 	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/withmandala/go-log"
 )
 
 // Helper function to create IP addresses for testing
@@ -15,27 +20,83 @@ func createIP(ipaddr string) netip.Addr {
 	return ip
 }
 
-func TestRemoveOldHosts(t *testing.T) {
+func TestResolveSameNameConflicts(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	now := time.Now()
 	tests := []struct {
 		name     string
+		policy   string
 		hostmaps []*Hostmap
 		want     int
-		wantName string
+		wantIP   string
 	}{
 		{
-			name: "newer host replaces older host",
+			name:   "newer host replaces older host",
+			policy: PolicyNewest,
 			hostmaps: []*Hostmap{
-				{ip: createIP("192.168.1.1"), hostnames: []string{"host1"}, lastseen: time.Now()},
-				{ip: createIP("192.168.1.2"), hostnames: []string{"host1"}, lastseen: time.Unix(time.Now().Unix()-100, 0)},
+				{ip: createIP("192.168.1.1"), hostnames: []string{"host1"}, lastseen: now},
+				{ip: createIP("192.168.1.2"), hostnames: []string{"host1"}, lastseen: now.Add(-100 * time.Second)},
 			},
-			want:     1,
-			wantName: "host1",
+			want:   1,
+			wantIP: "192.168.1.1",
 		},
 		{
-			name: "different hosts are kept",
+			name:   "different hosts are kept",
+			policy: PolicyNewest,
 			hostmaps: []*Hostmap{
-				{ip: createIP("192.168.1.1"), hostnames: []string{"host1"}, lastseen: time.Now()},
-				{ip: createIP("192.168.1.2"), hostnames: []string{"host2"}, lastseen: time.Now()},
+				{ip: createIP("192.168.1.1"), hostnames: []string{"host1"}, lastseen: now},
+				{ip: createIP("192.168.1.2"), hostnames: []string{"host2"}, lastseen: now},
+			},
+			want: 2,
+		},
+		{
+			name:   "controller time breaks a tie",
+			policy: PolicyNewest,
+			hostmaps: []*Hostmap{
+				{ip: createIP("192.168.1.1"), hostnames: []string{"host1"}, lastseen: now, lastseenUnifi: now.Add(-time.Hour)},
+				{ip: createIP("192.168.1.2"), hostnames: []string{"host1"}, lastseen: now, lastseenUnifi: now.Add(-time.Minute)},
+			},
+			want:   1,
+			wantIP: "192.168.1.2",
+		},
+		{
+			name:   "wired wins",
+			policy: PolicyWired,
+			hostmaps: []*Hostmap{
+				{ip: createIP("192.168.1.1"), hostnames: []string{"laptop"}, lastseen: now},
+				{ip: createIP("192.168.1.2"), hostnames: []string{"laptop"}, lastseen: now.Add(-time.Minute), wired: true},
+			},
+			want:   1,
+			wantIP: "192.168.1.2",
+		},
+		{
+			name:   "static wins",
+			policy: PolicyStatic,
+			hostmaps: []*Hostmap{
+				{ip: createIP("192.168.1.1"), hostnames: []string{"nas"}, lastseen: now, source: SourceClient},
+				{ip: createIP("192.168.1.2"), hostnames: []string{"nas"}, lastseen: now.Add(-time.Minute), source: SourceStatic},
+			},
+			want:   1,
+			wantIP: "192.168.1.2",
+		},
+		{
+			name:   "multi-homed host is kept",
+			policy: PolicyKeepBoth,
+			hostmaps: []*Hostmap{
+				{ip: createIP("192.168.1.1"), hostnames: []string{"server"}, lastseen: now},
+				{ip: createIP("192.168.2.1"), hostnames: []string{"server"}, lastseen: now.Add(-time.Minute)},
+			},
+			want: 2,
+		},
+		{
+			name:   "removed hosts don't conflict",
+			policy: PolicyNewest,
+			hostmaps: []*Hostmap{
+				{ip: createIP("192.168.1.1"), hostnames: []string{"host1"}, lastseen: now.Add(-time.Minute)},
+				{ip: createIP("192.168.1.2"), hostnames: []string{"host1"}, lastseen: now, removalCode: Blocked},
 			},
 			want: 2,
 		},
@@ -43,40 +104,116 @@ func TestRemoveOldHosts(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := removeOldHosts(tt.hostmaps)
+			for _, hm := range tt.hostmaps {
+				addDomainsToHostmap(hm, []string{"example.com"})
+			}
+			got := resolveSameNameConflicts(tt.hostmaps, tt.policy)
 			if len(got) != tt.want {
-				t.Errorf("removeOldHosts() got %d hosts, want %d", len(got), tt.want)
+				t.Errorf("resolveSameNameConflicts() got %d hosts, want %d", len(got), tt.want)
 			}
-			if tt.wantName != "" && len(got) > 0 {
-				if got[0].hostnames[0] != tt.wantName {
-					t.Errorf("removeOldHosts() got hostname %s, want %s", got[0].hostnames[0], tt.wantName)
+			if tt.wantIP != "" && len(got) > 0 {
+				if got[0].ip.String() != tt.wantIP {
+					t.Errorf("resolveSameNameConflicts() kept %s, want %s", got[0].ip, tt.wantIP)
 				}
 			}
 		})
 	}
 }
 
-func TestRemoveDuplicateHosts(t *testing.T) {
+// TestResolveSameNameConflictsKeepsOtherNames tests that a host that loses a
+// name keeps its other names, and that the same name in different domains is
+// not a conflict
+func TestResolveSameNameConflictsKeepsOtherNames(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	now := time.Now()
+	cfg := &TomlConfig{Processing: ProcessingConfig{Domains: []string{"example.com"}}}
+	winner := addDomainsToHostmap(&Hostmap{ip: createIP("192.168.1.1"), hostnames: []string{"www"}, lastseen: now}, cfg.Processing.Domains)
+	loser := addDomainsToHostmap(&Hostmap{ip: createIP("192.168.1.2"), hostnames: []string{"www", "web2"}, lastseen: now.Add(-time.Minute)}, cfg.Processing.Domains)
+
+	got := resolveSameNameConflicts([]*Hostmap{winner, loser}, PolicyNewest)
+	if len(got) != 2 {
+		t.Fatalf("Expected both hosts to be kept, got %d", len(got))
+	}
+	if strings.Join(loser.fqdns, ",") != "web2.example.com" {
+		t.Errorf("Expected the losing host to keep only web2.example.com, got %v", loser.fqdns)
+	}
+
+	other := addDomainsToHostmap(&Hostmap{ip: createIP("192.168.2.1"), hostnames: []string{"www"}, lastseen: now.Add(-time.Hour)}, []string{"example.net"})
+	if got := resolveSameNameConflicts([]*Hostmap{winner, other}, PolicyNewest); len(got) != 2 {
+		t.Errorf("Expected names in different domains to be kept, got %d hosts", len(got))
+	}
+}
+
+func TestResolveSameIPConflicts(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	now := time.Now()
 	tests := []struct {
 		name     string
+		policy   string
 		hostmaps []*Hostmap
 		want     int
 		wantName string
 	}{
 		{
-			name: "newer duplicate host kept",
+			name:   "newer duplicate host kept",
+			policy: PolicyNewest,
 			hostmaps: []*Hostmap{
-				{ip: createIP("192.168.1.1"), hostnames: []string{"host1"}, lastseen: time.Now()},
-				{ip: createIP("192.168.1.1"), hostnames: []string{"host2"}, lastseen: time.Unix(time.Now().Unix()-100, 0)},
+				{ip: createIP("192.168.1.1"), hostnames: []string{"host1"}, lastseen: now},
+				{ip: createIP("192.168.1.1"), hostnames: []string{"host2"}, lastseen: now.Add(-100 * time.Second)},
 			},
 			want:     1,
 			wantName: "host1",
 		},
 		{
-			name: "different hosts are kept",
+			name:   "different hosts are kept",
+			policy: PolicyNewest,
 			hostmaps: []*Hostmap{
-				{ip: createIP("192.168.1.1"), hostnames: []string{"host1"}, lastseen: time.Now()},
-				{ip: createIP("192.168.1.2"), hostnames: []string{"host2"}, lastseen: time.Now()},
+				{ip: createIP("192.168.1.1"), hostnames: []string{"host1"}, lastseen: now},
+				{ip: createIP("192.168.1.2"), hostnames: []string{"host2"}, lastseen: now},
+			},
+			want: 2,
+		},
+		{
+			name:   "active host wins over removed host",
+			policy: PolicyNewest,
+			hostmaps: []*Hostmap{
+				{ip: createIP("192.168.1.1"), hostnames: []string{"host1"}, lastseen: now, removalCode: Old},
+				{ip: createIP("192.168.1.1"), hostnames: []string{"host2"}, lastseen: now.Add(-time.Minute)},
+			},
+			want:     1,
+			wantName: "host2",
+		},
+		{
+			name:   "static wins",
+			policy: PolicyStatic,
+			hostmaps: []*Hostmap{
+				{ip: createIP("192.168.1.1"), hostnames: []string{"phone"}, lastseen: now, source: SourceClient},
+				{ip: createIP("192.168.1.1"), hostnames: []string{"printer"}, lastseen: now.Add(-time.Minute), source: SourceStatic},
+			},
+			want:     1,
+			wantName: "printer",
+		},
+		{
+			name:   "additional entries on one address are kept",
+			policy: PolicyNewest,
+			hostmaps: []*Hostmap{
+				{ip: createIP("192.168.1.1"), hostnames: []string{"nas"}, lastseen: now, source: SourceStatic},
+				{ip: createIP("192.168.1.1"), hostnames: []string{"plex"}, lastseen: now, source: SourceStatic},
+			},
+			want: 2,
+		},
+		{
+			name:   "keep both",
+			policy: PolicyKeepBoth,
+			hostmaps: []*Hostmap{
+				{ip: createIP("192.168.1.1"), hostnames: []string{"host1"}, lastseen: now},
+				{ip: createIP("192.168.1.1"), hostnames: []string{"host2"}, lastseen: now.Add(-time.Minute)},
 			},
 			want: 2,
 		},
@@ -84,35 +221,72 @@ func TestRemoveDuplicateHosts(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := removeDuplicateHosts(tt.hostmaps)
+			got := resolveSameIPConflicts(tt.hostmaps, tt.policy)
 			if len(got) != tt.want {
-				t.Errorf("removeDuplicateHosts() got %d hosts, want %d", len(got), tt.want)
+				t.Errorf("resolveSameIPConflicts() got %d hosts, want %d", len(got), tt.want)
 			}
 			if tt.wantName != "" && len(got) > 0 {
 				if got[0].hostnames[0] != tt.wantName {
-					t.Errorf("removeDuplicateHosts() got hostname %s, want %s", got[0].hostnames[0], tt.wantName)
+					t.Errorf("resolveSameIPConflicts() got hostname %s, want %s", got[0].hostnames[0], tt.wantName)
 				}
 			}
 		})
 	}
 }
 
-func TestRemoveOldHostsByTime(t *testing.T) {
+// TestResolveConflictsDeterministic tests that a tie is resolved the same way
+// whatever order the hosts come in, and that a host seen again doesn't
+// conflict with its earlier record
+func TestResolveConflictsDeterministic(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	now := time.Now()
+	cfg := &TomlConfig{}
+	for _, reverse := range []bool{false, true} {
+		hostmaps := []*Hostmap{
+			{ip: createIP("192.168.1.1"), hostnames: []string{"host1"}, mac: "aa:aa:aa:aa:aa:01", lastseen: now.Add(-time.Minute)},
+			{ip: createIP("192.168.1.9"), hostnames: []string{"host2"}, mac: "aa:aa:aa:aa:aa:02", lastseen: now},
+			{ip: createIP("192.168.1.9"), hostnames: []string{"host3"}, mac: "aa:aa:aa:aa:aa:03", lastseen: now},
+			{ip: createIP("192.168.1.1"), hostnames: []string{"host1"}, mac: "aa:aa:aa:aa:aa:01", lastseen: now},
+		}
+		if reverse {
+			for i, j := 0, len(hostmaps)-1; i < j; i, j = i+1, j-1 {
+				hostmaps[i], hostmaps[j] = hostmaps[j], hostmaps[i]
+			}
+		}
+
+		got := resolveConflicts(hostmaps, cfg)
+		if len(got) != 2 {
+			t.Fatalf("Expected 2 hosts, got %d", len(got))
+		}
+		if !got[0].lastseen.Equal(now) {
+			t.Errorf("Expected the newest record of host1 to be kept")
+		}
+		if got[1].hostnames[0] != "host2" {
+			t.Errorf("Expected host2 to win the tie, got %s", got[1].hostnames[0])
+		}
+	}
+}
+
+func TestRemoveExpiredHosts(t *testing.T) {
 	now := time.Now()
 
 	// Test case 1: Old hosts are marked for removal but still present in the array
 	t.Run("old hosts are removed", func(t *testing.T) {
-		// Create test hostmaps with one recent and one old host
+		// Create test hostmaps with one recent and one old host, the time
+		// from the controller is used and not the time of the scrape
 		hostmaps := []*Hostmap{
-			{ip: createIP("192.168.1.1"), hostnames: []string{"host1"}, lastseen: now, removalCode: NotRemoved},
-			{ip: createIP("192.168.1.2"), hostnames: []string{"host2"}, lastseen: now.Add(-2 * time.Hour), removalCode: NotRemoved},
+			{ip: createIP("192.168.1.1"), hostnames: []string{"host1"}, lastseen: now, lastseenUnifi: now, source: SourceClient, removalCode: NotRemoved},
+			{ip: createIP("192.168.1.2"), hostnames: []string{"host2"}, lastseen: now, lastseenUnifi: now.Add(-2 * time.Hour), source: SourceClient, removalCode: NotRemoved},
 		}
 
-		duration := time.Hour
-		result := removeOldHostsByTime(hostmaps, duration)
+		cfg := &TomlConfig{MaxAge: Duration{time.Hour}}
+		result := removeExpiredHosts(hostmaps, cfg, now)
 
 		if len(result) != 2 {
-			t.Errorf("removeOldHostsByTime() returned %d hosts, want %d", len(result), 2)
+			t.Errorf("removeExpiredHosts() returned %d hosts, want %d", len(result), 2)
 		}
 
 		notRemovedCount := 0
@@ -126,22 +300,22 @@ func TestRemoveOldHostsByTime(t *testing.T) {
 		}
 
 		if notRemovedCount != 1 {
-			t.Errorf("removeOldHostsByTime() kept %d hosts as NotRemoved, want %d", notRemovedCount, 1)
+			t.Errorf("removeExpiredHosts() kept %d hosts as NotRemoved, want %d", notRemovedCount, 1)
 		}
 		if oldCount != 1 {
-			t.Errorf("removeOldHostsByTime() marked %d hosts as Old, want %d", oldCount, 1)
+			t.Errorf("removeExpiredHosts() marked %d hosts as Old, want %d", oldCount, 1)
 		}
 	})
 
 	// Test case 2: All hosts within time limit are kept
 	t.Run("all hosts within time limit are kept", func(t *testing.T) {
 		hostmaps := []*Hostmap{
-			{ip: createIP("192.168.1.1"), hostnames: []string{"host1"}, lastseen: now, removalCode: NotRemoved},
-			{ip: createIP("192.168.1.2"), hostnames: []string{"host2"}, lastseen: now.Add(-30 * time.Minute), removalCode: NotRemoved},
+			{ip: createIP("192.168.1.1"), hostnames: []string{"host1"}, lastseen: now, lastseenUnifi: now, source: SourceClient, removalCode: NotRemoved},
+			{ip: createIP("192.168.1.2"), hostnames: []string{"host2"}, lastseen: now, lastseenUnifi: now.Add(-30 * time.Minute), source: SourceClient, removalCode: NotRemoved},
 		}
 
-		duration := time.Hour
-		result := removeOldHostsByTime(hostmaps, duration)
+		cfg := &TomlConfig{MaxAge: Duration{time.Hour}}
+		result := removeExpiredHosts(hostmaps, cfg, now)
 
 		notRemovedCount := 0
 		for _, host := range result {
@@ -151,9 +325,86 @@ func TestRemoveOldHostsByTime(t *testing.T) {
 		}
 
 		if notRemovedCount != 2 {
-			t.Errorf("removeOldHostsByTime() kept %d hosts as NotRemoved, want %d", notRemovedCount, 2)
+			t.Errorf("removeExpiredHosts() kept %d hosts as NotRemoved, want %d", notRemovedCount, 2)
 		}
 	})
+
+	// Test case 3: retention per source, grace for offline devices and
+	// static hosts that are no longer configured
+	t.Run("per source retention and grace", func(t *testing.T) {
+		earlier := now.Add(-10 * time.Minute)
+		tests := []struct {
+			name    string
+			host    *Hostmap
+			wantOld bool
+		}{
+			{"client past clients retention", &Hostmap{source: SourceClient, lastseen: earlier, lastseenUnifi: now.Add(-3 * time.Hour)}, true},
+			{"switch within switches retention", &Hostmap{source: SourceSwitch, lastseen: earlier, lastseenUnifi: now.Add(-3 * time.Hour)}, false},
+			{"ap past MaxAge", &Hostmap{source: SourceAP, lastseen: earlier, lastseenUnifi: now.Add(-25 * time.Hour)}, true},
+			{"offline ap still reported gets grace", &Hostmap{source: SourceAP, lastseen: now, lastseenUnifi: now.Add(-25 * time.Hour)}, false},
+			{"grace runs out", &Hostmap{source: SourceAP, lastseen: now, lastseenUnifi: now.Add(-27 * time.Hour)}, true},
+			{"static host in config", &Hostmap{source: SourceStatic, lastseen: now}, false},
+			{"static host removed from config", &Hostmap{source: SourceStatic, lastseen: now.Add(-2 * time.Hour)}, true},
+		}
+
+		cfg := &TomlConfig{
+			MaxAge: Duration{24 * time.Hour},
+			Expiry: ExpiryConfig{
+				Clients:  Duration{time.Hour},
+				Switches: Duration{7 * 24 * time.Hour},
+				Static:   Duration{time.Hour},
+				Grace:    Duration{2 * time.Hour},
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				tt.host.ip = createIP("192.168.1.1")
+				tt.host.hostnames = []string{"host"}
+				removeExpiredHosts([]*Hostmap{tt.host}, cfg, now)
+				if got := tt.host.removalCode == Old; got != tt.wantOld {
+					t.Errorf("removeExpiredHosts() old = %v, want %v", got, tt.wantOld)
+				}
+			})
+		}
+	})
+
+	// Test case 4: without MaxAge hosts never expire
+	t.Run("no max age", func(t *testing.T) {
+		hostmaps := []*Hostmap{
+			{ip: createIP("192.168.1.1"), hostnames: []string{"host1"}, lastseen: now, lastseenUnifi: now.Add(-1000 * time.Hour), source: SourceClient},
+		}
+		removeExpiredHosts(hostmaps, &TomlConfig{}, now)
+		if hostmaps[0].removalCode != NotRemoved {
+			t.Errorf("removeExpiredHosts() removed a host without MaxAge")
+		}
+	})
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"36h", 36 * time.Hour, false},
+		{"90m", 90 * time.Minute, false},
+		{"7d", 7 * 24 * time.Hour, false},
+		{"1.5d", 36 * time.Hour, false},
+		{"soon", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseDuration(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDuration() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseDuration() = %v, want %v", got, tt.want)
+			}
+		})
+	}
 }
 
 func TestProcessMACHostnames(t *testing.T) {
@@ -203,18 +454,7 @@ func TestProcessMACHostnames(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			cfg := &TomlConfig{
-				Processing: struct {
-					Domains    []string
-					Additional []struct {
-						IP           string
-						Hostnames    []string
-						Name         string
-						KeepMultiple *bool
-					}
-					Blocked  []struct{ IP, Name string }
-					Cnames   []struct{ Cname, Hostname string }
-					KeepMacs bool
-				}{
+				Processing: ProcessingConfig{
 					KeepMacs: tt.keepMacs,
 					Domains:  nil,
 					Additional: []struct {
@@ -347,18 +587,7 @@ func TestRemoveBlockedHosts(t *testing.T) {
 				{ip: createIP("192.168.1.2"), hostnames: []string{"blocked"}, lastseen: time.Now()},
 			},
 			config: &TomlConfig{
-				Processing: struct {
-					Domains    []string
-					Additional []struct {
-						IP           string
-						Hostnames    []string
-						Name         string
-						KeepMultiple *bool
-					}
-					Blocked  []struct{ IP, Name string }
-					Cnames   []struct{ Cname, Hostname string }
-					KeepMacs bool
-				}{
+				Processing: ProcessingConfig{
 					Additional: []struct {
 						IP           string
 						Hostnames    []string
@@ -380,18 +609,7 @@ func TestRemoveBlockedHosts(t *testing.T) {
 				{ip: createIP("192.168.90.2"), hostnames: []string{"powerwall"}, lastseen: time.Now()},
 			},
 			config: &TomlConfig{
-				Processing: struct {
-					Domains    []string
-					Additional []struct {
-						IP           string
-						Hostnames    []string
-						Name         string
-						KeepMultiple *bool
-					}
-					Blocked  []struct{ IP, Name string }
-					Cnames   []struct{ Cname, Hostname string }
-					KeepMacs bool
-				}{
+				Processing: ProcessingConfig{
 					Additional: []struct {
 						IP           string
 						Hostnames    []string
@@ -454,18 +672,7 @@ func TestCheckBlocked(t *testing.T) {
 			name: "blocked by name and IP match",
 			host: &Hostmap{ip: createIP("192.168.90.2"), hostnames: []string{"powerwall"}, lastseen: time.Now()},
 			config: &TomlConfig{
-				Processing: struct {
-					Domains    []string
-					Additional []struct {
-						IP           string
-						Hostnames    []string
-						Name         string
-						KeepMultiple *bool
-					}
-					Blocked  []struct{ IP, Name string }
-					Cnames   []struct{ Cname, Hostname string }
-					KeepMacs bool
-				}{
+				Processing: ProcessingConfig{
 					Additional: []struct {
 						IP           string
 						Hostnames    []string
@@ -483,18 +690,7 @@ func TestCheckBlocked(t *testing.T) {
 			name: "blocked by IP only",
 			host: &Hostmap{ip: createIP("192.168.90.2"), hostnames: []string{"powerwall"}, lastseen: time.Now()},
 			config: &TomlConfig{
-				Processing: struct {
-					Domains    []string
-					Additional []struct {
-						IP           string
-						Hostnames    []string
-						Name         string
-						KeepMultiple *bool
-					}
-					Blocked  []struct{ IP, Name string }
-					Cnames   []struct{ Cname, Hostname string }
-					KeepMacs bool
-				}{
+				Processing: ProcessingConfig{
 					Additional: []struct {
 						IP           string
 						Hostnames    []string
@@ -512,18 +708,7 @@ func TestCheckBlocked(t *testing.T) {
 			name: "blocked by name only",
 			host: &Hostmap{ip: createIP("192.168.90.2"), hostnames: []string{"powerwall"}, lastseen: time.Now()},
 			config: &TomlConfig{
-				Processing: struct {
-					Domains    []string
-					Additional []struct {
-						IP           string
-						Hostnames    []string
-						Name         string
-						KeepMultiple *bool
-					}
-					Blocked  []struct{ IP, Name string }
-					Cnames   []struct{ Cname, Hostname string }
-					KeepMacs bool
-				}{
+				Processing: ProcessingConfig{
 					Additional: []struct {
 						IP           string
 						Hostnames    []string
@@ -541,18 +726,7 @@ func TestCheckBlocked(t *testing.T) {
 			name: "not blocked",
 			host: &Hostmap{ip: createIP("192.168.1.1"), hostnames: []string{"host1"}, lastseen: time.Now()},
 			config: &TomlConfig{
-				Processing: struct {
-					Domains    []string
-					Additional []struct {
-						IP           string
-						Hostnames    []string
-						Name         string
-						KeepMultiple *bool
-					}
-					Blocked  []struct{ IP, Name string }
-					Cnames   []struct{ Cname, Hostname string }
-					KeepMacs bool
-				}{
+				Processing: ProcessingConfig{
 					Additional: []struct {
 						IP           string
 						Hostnames    []string
@@ -577,3 +751,50 @@ func TestCheckBlocked(t *testing.T) {
 		})
 	}
 }
+
+func TestSaveHostsFileIPv6(t *testing.T) {
+	if logger == nil {
+		logger = log.New(nil)
+	}
+
+	filename := filepath.Join(t.TempDir(), "hosts.txt")
+	cfg := &TomlConfig{
+		Processing: ProcessingConfig{
+			Domains:     []string{"example.com", "local"},
+			IPv6Domains: []string{"example.com"},
+		},
+		Hostsfile: HostsfileConfig{Filename: filename},
+	}
+	cfg.Processing.Cnames = append(cfg.Processing.Cnames, struct {
+		Cname    string
+		Hostname string
+	}{Cname: "www.example.com", Hostname: "host1.example.com"})
+
+	hostmaps := []*Hostmap{
+		{
+			ip:        createIP("192.168.1.1"),
+			ipv6:      []netip.Addr{createIP("2001:db8::1")},
+			hostnames: []string{"host1"},
+			fqdns:     []string{"host1.example.com", "host1.local"},
+			lastseen:  time.Now(),
+		},
+	}
+
+	if err := SaveHostsFile(hostmaps, cfg); err != nil {
+		t.Fatalf("SaveHostsFile() error = %v", err)
+	}
+
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("unable to read hosts file: %v", err)
+	}
+
+	for _, want := range []string{
+		"192.168.1.1 host1.example.com host1.local www.example.com\n",
+		"2001:db8::1 host1.example.com www.example.com\n",
+	} {
+		if !strings.Contains(string(contents), want) {
+			t.Errorf("SaveHostsFile() output missing line %q, got:\n%s", want, contents)
+		}
+	}
+}
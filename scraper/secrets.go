@@ -0,0 +1,158 @@
+package scraper
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// secretTimeout is how long an exec: secret command may run
+const secretTimeout = 30 * time.Second
+
+// secretPattern finds references like ${file:/run/secrets/x}, ${env:NAME} and
+// ${exec:command} in configuration values
+var secretPattern = regexp.MustCompile(`\$\{(file|env|exec):([^}]*)\}`)
+
+// bareSecretPattern matches a reference without ${}, like file:/run/secrets/x,
+// that makes up a whole value
+var bareSecretPattern = regexp.MustCompile(`^(file|env|exec):(.+)$`)
+
+// ResolveSecrets replaces the secret references in every string of the
+// configuration with the secrets they point to. A reference can be the whole
+// value or part of it, like the password in a database DSN. This is called
+// every time the configuration is read, so rotated secrets are picked up.
+func ResolveSecrets(cfg *TomlConfig) error {
+	return resolveSecretsIn(reflect.ValueOf(cfg).Elem(), "")
+}
+
+// resolveSecretsIn walks a configuration value and resolves the references in
+// all of the strings it holds. path names the field in error messages.
+func resolveSecretsIn(v reflect.Value, path string) error {
+	switch v.Kind() {
+	case reflect.String:
+		if match := bareSecretPattern.FindStringSubmatch(v.String()); match != nil {
+			// a whole DSN from env: or exec: is a reference too, but SQLite
+			// DSNs can be file: URIs
+			dsn := strings.HasSuffix(path, "DSN")
+			if isSecretField(path) || (dsn && match[1] != "file") {
+				secret, err := readSecret(match[1], match[2])
+				if err != nil {
+					return fmt.Errorf("%s: %w", path, err)
+				}
+				v.SetString(secret)
+				return nil
+			}
+			if !dsn {
+				logger.Warnf("%s looks like a secret reference but is used as it is, write it as ${%s} to read the secret", path, v.String())
+			}
+		}
+		resolved, err := resolveSecretString(v.String())
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		v.SetString(resolved)
+	case reflect.Pointer:
+		if !v.IsNil() {
+			return resolveSecretsIn(v.Elem(), path)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !v.Field(i).CanSet() {
+				continue
+			}
+			name := v.Type().Field(i).Name
+			if path != "" {
+				name = path + "." + name
+			}
+			if err := resolveSecretsIn(v.Field(i), name); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := resolveSecretsIn(v.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		// map values can't be changed in place, so each one is copied,
+		// resolved and put back
+		iter := v.MapRange()
+		for iter.Next() {
+			value := reflect.New(iter.Value().Type()).Elem()
+			value.Set(iter.Value())
+			if err := resolveSecretsIn(value, fmt.Sprintf("%s[%v]", path, iter.Key())); err != nil {
+				return err
+			}
+			v.SetMapIndex(iter.Key(), value)
+		}
+	}
+	return nil
+}
+
+// isSecretField checks if a field only ever holds a credential, such as a
+// password, in which case a whole value like env:NAME is a reference even
+// without the ${}
+func isSecretField(path string) bool {
+	name := path[strings.LastIndex(path, ".")+1:]
+	return strings.HasSuffix(name, "Password") || strings.HasSuffix(name, "Secret") || name == "APIKey"
+}
+
+// resolveSecretString replaces the secret references in one string
+func resolveSecretString(s string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	var firstErr error
+	resolved := secretPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		match := secretPattern.FindStringSubmatch(ref)
+		secret, err := readSecret(match[1], match[2])
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		return secret
+	})
+	if firstErr != nil {
+		return "", firstErr
+	}
+	return resolved, nil
+}
+
+// readSecret reads one secret. Trailing newlines are dropped, as secret files
+// and command output almost always end with one.
+func readSecret(kind, source string) (string, error) {
+	switch kind {
+	case "file":
+		data, err := os.ReadFile(source)
+		if err != nil {
+			return "", fmt.Errorf("reading secret file: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case "env":
+		value, ok := os.LookupEnv(source)
+		if !ok {
+			return "", fmt.Errorf("secret environment variable %s is not set", source)
+		}
+		return value, nil
+	case "exec":
+		ctx, cancel := context.WithTimeout(context.Background(), secretTimeout)
+		defer cancel()
+
+		var stdout, stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "sh", "-c", source)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return "", fmt.Errorf("running secret command %q: %w: %s", source, err, strings.TrimSpace(stderr.String()))
+		}
+		return strings.TrimRight(stdout.String(), "\r\n"), nil
+	}
+	return "", fmt.Errorf("unknown secret type %s", kind)
+}
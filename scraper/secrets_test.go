@@ -0,0 +1,125 @@
+package scraper
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/naoina/toml"
+	"github.com/withmandala/go-log"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "db_password")
+	if err := os.WriteFile(secretFile, []byte("hunter2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_UNIFI_PASSWORD", "from-env")
+
+	input := `
+[unifi]
+host = "https://192.168.1.1"
+user = "scraper"
+password = "${env:TEST_UNIFI_PASSWORD}"
+api_key = "${exec:echo from-exec}"
+
+[unifi.site_domains]
+default = ["${env:TEST_UNIFI_PASSWORD}.example.com"]
+
+[database]
+driver = "mysql"
+dsn = "scraper:${file:` + secretFile + `}@tcp(db:3306)/pdns"
+
+[pihole]
+url = "http://pi.hole"
+password = "plain $HOME {not a secret}"
+
+[adguard]
+url = "http://adguard"
+password = "file:` + secretFile + `"
+
+[rfc2136]
+server = "ns1.example.com:53"
+tsig_secret = "env:TEST_UNIFI_PASSWORD"
+
+[hostsfile]
+filename = "file:hosts"
+`
+	var cfg TomlConfig
+	if err := toml.NewDecoder(strings.NewReader(input)).Decode(&cfg); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if err := ResolveSecrets(&cfg); err != nil {
+		t.Fatalf("ResolveSecrets() error = %v", err)
+	}
+
+	checks := map[string][2]string{
+		"password":     {cfg.Unifi[0].Password, "from-env"},
+		"api_key":      {cfg.Unifi[0].APIKey, "from-exec"},
+		"site_domains": {cfg.Unifi[0].SiteDomains["default"][0], "from-env.example.com"},
+		"dsn":          {cfg.Database.DSN, "scraper:hunter2@tcp(db:3306)/pdns"},
+		"pihole":       {cfg.Pihole.Password, "plain $HOME {not a secret}"},
+		"user":         {cfg.Unifi[0].User, "scraper"},
+		"adguard":      {cfg.AdGuard.Password, "hunter2"},
+		"tsig_secret":  {cfg.RFC2136.TsigSecret, "from-env"},
+		"filename":     {cfg.Hostsfile.Filename, "file:hosts"},
+	}
+	for name, check := range checks {
+		if check[0] != check[1] {
+			t.Errorf("%s = %q, want %q", name, check[0], check[1])
+		}
+	}
+}
+
+// TestResolveSecretsDSN tests that a whole DSN can come from env: or exec:
+// without the ${}, while file: is left for SQLite
+func TestResolveSecretsDSN(t *testing.T) {
+	t.Setenv("TEST_DATABASE_DSN", "scraper:hunter2@tcp(db:3306)/pdns")
+
+	tests := map[string]string{
+		"env:TEST_DATABASE_DSN":        "scraper:hunter2@tcp(db:3306)/pdns",
+		"exec:echo $TEST_DATABASE_DSN": "scraper:hunter2@tcp(db:3306)/pdns",
+		"file:pdns.db?cache=shared":    "file:pdns.db?cache=shared",
+	}
+	for dsn, want := range tests {
+		cfg := TomlConfig{Database: DatabaseConfig{DSN: dsn}}
+		if err := ResolveSecrets(&cfg); err != nil {
+			t.Fatalf("ResolveSecrets(%q) error = %v", dsn, err)
+		}
+		if cfg.Database.DSN != want {
+			t.Errorf("ResolveSecrets(%q) = %q, want %q", dsn, cfg.Database.DSN, want)
+		}
+	}
+}
+
+func TestResolveSecretsErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		ref    string
+		errMsg string
+	}{
+		{name: "missing file", ref: "${file:/nonexistent/secret}", errMsg: "reading secret file"},
+		{name: "missing env", ref: "${env:TEST_SECRET_THAT_IS_NOT_SET}", errMsg: "is not set"},
+		{name: "failing command", ref: "${exec:echo oops >&2; exit 3}", errMsg: "oops"},
+		{name: "missing bare file", ref: "file:/nonexistent/secret", errMsg: "reading secret file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := TomlConfig{Unifi: UnifiControllers{{Password: tt.ref}}}
+			err := ResolveSecrets(&cfg)
+			if err == nil {
+				t.Fatalf("Expected an error")
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) || !strings.HasPrefix(err.Error(), "Unifi[0].Password:") {
+				t.Errorf("Expected an error for Unifi[0].Password containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
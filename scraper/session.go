@@ -0,0 +1,215 @@
+package scraper
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/unpoller/unifi"
+)
+
+const (
+	// sessionBackoffBase is how long to wait after the first failure to reach
+	// a controller. Every further failure doubles this.
+	sessionBackoffBase = 30 * time.Second
+	// sessionBackoffMax caps the wait between attempts
+	sessionBackoffMax = 30 * time.Minute
+)
+
+// controllerSession keeps the logged in client of a controller from one run
+// to the next, so the scraper doesn't log in again every loop. An expired
+// session is replaced by logging in again, and a controller that can't be
+// reached is left alone for a while, longer after each failure.
+type controllerSession struct {
+	name string
+	// settings identifies the settings the client was made with, so changed
+	// credentials get a new session
+	settings [sha256.Size]byte
+	connect  func() (UnifiClientInterface, error)
+	client   UnifiClientInterface
+	failures int
+	retryAt  time.Time
+}
+
+// ErrBackoff is the error for a controller that isn't tried because it failed
+// a short while ago
+var ErrBackoff = errors.New("skipping Unifi controller")
+
+var (
+	sessionsMu sync.Mutex
+	sessions   = make(map[string]*controllerSession)
+)
+
+// sessionSettings hashes the settings that go into a connection
+func sessionSettings(controller *UnifiConfig) [sha256.Size]byte {
+	return sha256.Sum256([]byte(strings.Join([]string{
+		controller.Host, controller.User, controller.Password, controller.APIKey,
+		controller.CAFile, controller.Fingerprint, fmt.Sprint(controller.Insecure != nil, controller.Insecure != nil && *controller.Insecure),
+		controller.ClientCert, controller.ClientKey,
+	}, "\x00")))
+}
+
+// sessionFor returns the session of a controller, which uses connect when it
+// needs to log in. It is an error to ask for the session of a controller that
+// is still being backed off.
+func sessionFor(controller *UnifiConfig, connect func() (UnifiClientInterface, error)) (*controllerSession, error) {
+	name := controllerName(controller)
+	settings := sessionSettings(controller)
+
+	sessionsMu.Lock()
+	session, ok := sessions[name]
+	if !ok || session.settings != settings {
+		if ok {
+			logger.Infof("Settings of Unifi controller %s changed, starting a new session", name)
+		}
+		session = &controllerSession{name: name, settings: settings}
+		sessions[name] = session
+	}
+	sessionsMu.Unlock()
+
+	session.connect = connect
+	if wait := time.Until(session.retryAt); wait > 0 {
+		return nil, fmt.Errorf("%w after %d failures, trying again in %s", ErrBackoff, session.failures, wait.Round(time.Second))
+	}
+	return session, nil
+}
+
+// IsBackoff checks if a scrape only failed because the controllers that
+// failed are still being backed off. Nothing was tried, so this doesn't count
+// as another failure.
+func IsBackoff(err error) bool {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range joined.Unwrap() {
+			if !IsBackoff(err) {
+				return false
+			}
+		}
+		return len(joined.Unwrap()) > 0
+	}
+	return errors.Is(err, ErrBackoff)
+}
+
+// backoffDelay is how long to wait after a number of failures in a row
+func backoffDelay(failures int) time.Duration {
+	delay := sessionBackoffBase
+	for i := 1; i < failures && delay < sessionBackoffMax; i++ {
+		delay *= 2
+	}
+	return min(delay, sessionBackoffMax)
+}
+
+// isSessionExpired checks if a request failed because the controller no
+// longer accepts the session cookie
+func isSessionExpired(err error) bool {
+	return errors.Is(err, unifi.ErrInvalidStatusCode) && strings.Contains(err.Error(), ": 401 ")
+}
+
+// isConnectionLost checks if a request failed in a way that means the client
+// can't be used again, because the controller couldn't be reached or didn't
+// accept the session. Other errors, like a controller that failed to answer
+// one request, keep the session for the next attempt.
+func isConnectionLost(err error) bool {
+	var urlErr *url.Error
+	return isSessionExpired(err) || errors.As(err, &urlErr)
+}
+
+// withLogin runs a request with the client of the session, logging in first
+// when there is no client yet and once more when the session has expired
+func (s *controllerSession) withLogin(request func(client UnifiClientInterface) error) error {
+	if s.client == nil {
+		client, err := s.connect()
+		if err != nil {
+			return err
+		}
+		s.client = client
+	}
+
+	err := request(s.client)
+	if !isSessionExpired(err) {
+		return err
+	}
+
+	logger.Infof("Session with Unifi controller %s expired, logging in again", s.name)
+	s.client = nil
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	s.client = client
+	return request(s.client)
+}
+
+// call runs a request and keeps track of failures for the backoff. A scrape
+// stops at the first request that fails, so this counts once per scrape.
+func (s *controllerSession) call(request func(client UnifiClientInterface) error) error {
+	err := s.withLogin(request)
+	if err != nil {
+		if isConnectionLost(err) {
+			s.client = nil
+		}
+		s.failures++
+		delay := backoffDelay(s.failures)
+		s.retryAt = time.Now().Add(delay)
+		logger.Warnf("Unifi controller %s failed %d times in a row, next attempt in %s", s.name, s.failures, delay)
+	}
+	return err
+}
+
+// scrapeSucceeded ends the backoff once every request of a scrape worked.
+// Single requests that work don't, or a controller that always fails the
+// same request would never back off any further.
+func (s *controllerSession) scrapeSucceeded() {
+	if s.failures > 0 {
+		logger.Infof("Unifi controller %s is back after %d failures", s.name, s.failures)
+	}
+	s.failures = 0
+	s.retryAt = time.Time{}
+}
+
+// GetSites implements UnifiClientInterface
+func (s *controllerSession) GetSites() ([]*unifi.Site, error) {
+	var sites []*unifi.Site
+	err := s.call(func(client UnifiClientInterface) (err error) {
+		sites, err = client.GetSites()
+		return err
+	})
+	return sites, err
+}
+
+// GetClients implements UnifiClientInterface
+func (s *controllerSession) GetClients(sites []*unifi.Site) ([]*unifi.Client, error) {
+	var clients []*unifi.Client
+	err := s.call(func(client UnifiClientInterface) (err error) {
+		clients, err = client.GetClients(sites)
+		return err
+	})
+	return clients, err
+}
+
+// GetDevices implements UnifiClientInterface
+func (s *controllerSession) GetDevices(sites []*unifi.Site) (*unifi.Devices, error) {
+	var devices *unifi.Devices
+	err := s.call(func(client UnifiClientInterface) (err error) {
+		devices, err = client.GetDevices(sites)
+		return err
+	})
+	return devices, err
+}
+
+// GetClientIPv6 implements UnifiIPv6Interface for clients that support it.
+// Failing to get IPv6 addresses doesn't count towards the backoff, as the
+// scrape goes on without them.
+func (s *controllerSession) GetClientIPv6(sites []*unifi.Site) (map[string][]string, error) {
+	var addresses map[string][]string
+	err := s.withLogin(func(client UnifiClientInterface) (err error) {
+		if v6client, ok := client.(UnifiIPv6Interface); ok {
+			addresses, err = v6client.GetClientIPv6(sites)
+		}
+		return err
+	})
+	return addresses, err
+}
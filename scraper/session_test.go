@@ -0,0 +1,268 @@
+package scraper
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/unpoller/unifi"
+	"github.com/withmandala/go-log"
+)
+
+// errSessionExpired looks like the error the unifi library returns for a
+// request with an expired session cookie
+var errSessionExpired = fmt.Errorf("https://unifi/api/stat/sites: 401 Unauthorized: %w", unifi.ErrInvalidStatusCode)
+
+func TestControllerSessionReuse(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	controller := &UnifiConfig{Name: "session-reuse", Host: "https://unifi.example.com", User: "scraper"}
+	logins := 0
+	connect := func() (UnifiClientInterface, error) {
+		logins++
+		client := NewMockUnifiClient().AddSite("default")
+		// the first session expires right away
+		if logins == 1 {
+			client.SetError(errSessionExpired)
+		}
+		return client, nil
+	}
+
+	for run := 1; run <= 3; run++ {
+		session, err := sessionFor(controller, connect)
+		if err != nil {
+			t.Fatalf("run %d: sessionFor() error = %v", run, err)
+		}
+		if _, err := session.GetSites(); err != nil {
+			t.Fatalf("run %d: GetSites() error = %v", run, err)
+		}
+	}
+	if logins != 2 {
+		t.Errorf("Expected one login and one login after the session expired, got %d logins", logins)
+	}
+
+	// new credentials get a new session
+	controller.Password = "rotated"
+	session, _ := sessionFor(controller, connect)
+	if _, err := session.GetSites(); err != nil {
+		t.Fatalf("GetSites() error = %v", err)
+	}
+	if logins != 3 {
+		t.Errorf("Expected a new login after the password changed, got %d logins", logins)
+	}
+}
+
+func TestControllerSessionBackoff(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	controller := &UnifiConfig{Name: "session-backoff", Host: "https://unifi.example.com"}
+	attempts := 0
+	connect := func() (UnifiClientInterface, error) {
+		attempts++
+		if attempts <= 2 {
+			return nil, errors.New("connection refused")
+		}
+		return NewMockUnifiClient().AddSite("default"), nil
+	}
+
+	for failure := 1; failure <= 2; failure++ {
+		session, err := sessionFor(controller, connect)
+		if err != nil {
+			t.Fatalf("sessionFor() error = %v", err)
+		}
+		if _, err := session.GetSites(); err == nil {
+			t.Fatalf("Expected GetSites() to fail")
+		}
+		if wait := time.Until(session.retryAt); wait <= backoffDelay(failure)-time.Second || wait > backoffDelay(failure) {
+			t.Errorf("After %d failures waiting %s, want %s", failure, wait, backoffDelay(failure))
+		}
+
+		// the controller isn't tried again until the backoff is over
+		if _, err := sessionFor(controller, connect); err == nil || !strings.Contains(err.Error(), "trying again in") {
+			t.Errorf("Expected sessionFor() to refuse during the backoff, got %v", err)
+		}
+		session.retryAt = time.Now()
+	}
+
+	session, err := sessionFor(controller, connect)
+	if err != nil {
+		t.Fatalf("sessionFor() error = %v", err)
+	}
+	if _, err := scrapeController(controller, &TomlConfig{}, session); err != nil {
+		t.Fatalf("scrapeController() error = %v", err)
+	}
+	if session.failures != 0 || !session.retryAt.IsZero() {
+		t.Errorf("Expected the backoff to reset, got %d failures", session.failures)
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+}
+
+// failingDevicesClient is a controller that lists its sites and clients but
+// fails to list its devices
+type failingDevicesClient struct {
+	*MockUnifiClient
+}
+
+func (c failingDevicesClient) GetDevices(_ []*unifi.Site) (*unifi.Devices, error) {
+	return nil, fmt.Errorf("https://unifi/api/s/default/stat/device: 500 Internal Server Error: %w", unifi.ErrInvalidStatusCode)
+}
+
+// TestControllerSessionPartialFailure tests that a controller failing one
+// request of every scrape keeps its session and backs off further each time
+func TestControllerSessionPartialFailure(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	controller := &UnifiConfig{Name: "session-partial", Host: "https://unifi.example.com", User: "scraper"}
+	logins := 0
+	connect := func() (UnifiClientInterface, error) {
+		logins++
+		return failingDevicesClient{NewMockUnifiClient().AddSite("default")}, nil
+	}
+
+	for failure := 1; failure <= 3; failure++ {
+		session, err := sessionFor(controller, connect)
+		if err != nil {
+			t.Fatalf("sessionFor() error = %v", err)
+		}
+		if _, err := scrapeController(controller, &TomlConfig{}, session); err == nil {
+			t.Fatalf("Expected scrapeController() to fail")
+		}
+		if session.failures != failure {
+			t.Errorf("Expected %d failures, got %d", failure, session.failures)
+		}
+		if wait := time.Until(session.retryAt); wait <= backoffDelay(failure)-time.Second {
+			t.Errorf("After %d failures waiting %s, want %s", failure, wait, backoffDelay(failure))
+		}
+		session.retryAt = time.Now()
+	}
+	if logins != 1 {
+		t.Errorf("Expected the session to be kept, got %d logins", logins)
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	want := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute}
+	for i, delay := range want {
+		if got := backoffDelay(i + 1); got != delay {
+			t.Errorf("backoffDelay(%d) = %s, want %s", i+1, got, delay)
+		}
+	}
+	if got := backoffDelay(100); got != sessionBackoffMax {
+		t.Errorf("backoffDelay(100) = %s, want %s", got, sessionBackoffMax)
+	}
+}
+
+// TestIsSessionExpired checks the error of the unifi library for a request
+// the controller turns down
+func TestIsSessionExpired(t *testing.T) {
+	for _, status := range []int{http.StatusUnauthorized, http.StatusInternalServerError} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "", status)
+		}))
+		uni := &unifi.Unifi{
+			Client: server.Client(),
+			Config: &unifi.Config{URL: server.URL, ErrorLog: t.Logf, DebugLog: t.Logf},
+		}
+		_, err := uni.GetSites()
+		server.Close()
+
+		if got := isSessionExpired(err); got != (status == http.StatusUnauthorized) {
+			t.Errorf("isSessionExpired() for status %d = %v (%v)", status, got, err)
+		}
+	}
+}
+
+// TestControllerOutage runs the daemon loop through a controller that is down
+// for five minutes. The loops that skip the controller during its backoff
+// don't count as failures, so the scraper doesn't become unhealthy.
+func TestControllerOutage(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	cfg := &TomlConfig{
+		Unifi:      UnifiControllers{{Name: "session-outage", Host: "https://unifi.example.com"}},
+		Processing: ProcessingConfig{Domains: []string{"example.com"}},
+	}
+	down := true
+	connect := func() (UnifiClientInterface, error) {
+		if down {
+			return nil, errors.New("connection refused")
+		}
+		client := NewMockUnifiClient().AddSite("default")
+		client.AddClient("laptop", "192.168.1.10", float64(time.Now().Unix()))
+		return client, nil
+	}
+
+	health := NewHealth(&HealthConfig{})
+	sleep := time.Minute
+	var elapsed time.Duration
+	skipped := 0
+	for loop := 1; ; loop++ {
+		down = elapsed < 5*time.Minute
+		hostmaps, err := generateHostmap(cfg, nil, cfg.Unifi, func(i int) (UnifiClientInterface, error) {
+			return sessionFor(&cfg.Unifi[i], connect)
+		})
+		switch {
+		case err != nil && IsBackoff(err):
+			skipped++
+		case err != nil:
+			if health.Failure(err, 0) == HealthUnhealthy {
+				t.Fatalf("Unhealthy after %s and %d loops: %v", elapsed, loop, err)
+			}
+		default:
+			health.Success(len(hostmaps))
+		}
+		if err == nil {
+			if len(hostmaps) != 1 {
+				t.Errorf("Expected the host once the controller is back, got %d hosts", len(hostmaps))
+			}
+			break
+		}
+
+		// sleep like the daemon, by moving the backoff of the session
+		delay := health.NextDelay(sleep)
+		elapsed += delay
+		session := sessions["session-outage"]
+		session.retryAt = session.retryAt.Add(-delay)
+	}
+
+	if skipped == 0 {
+		t.Errorf("Expected some loops to skip the controller during its backoff")
+	}
+	if health.State() != HealthOK {
+		t.Errorf("State() = %s after the controller came back, want %s", health.State(), HealthOK)
+	}
+}
+
+func TestIsBackoff(t *testing.T) {
+	backoff := fmt.Errorf("home: %w after 3 failures", ErrBackoff)
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{backoff, true},
+		{errors.Join(backoff, fmt.Errorf("office: %w after 1 failures", ErrBackoff)), true},
+		{errors.Join(backoff, errors.New("office: connection refused")), false},
+		{errors.New("connection refused"), false},
+	}
+	for _, tt := range tests {
+		if got := IsBackoff(tt.err); got != tt.want {
+			t.Errorf("IsBackoff(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package scraper
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/unpoller/unifi"
+)
+
+// siteMatches checks if a site is in a list of site names or descriptions
+func siteMatches(site *unifi.Site, list []string) bool {
+	for _, entry := range list {
+		entry = strings.TrimSpace(entry)
+		if strings.EqualFold(entry, site.Name) || strings.EqualFold(entry, site.Desc) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterSites applies the sites and exclude_sites settings to the sites of
+// the controller. It is an error for the settings to leave no sites, as that
+// would expire every host.
+func filterSites(sites []*unifi.Site, controller *UnifiConfig) ([]*unifi.Site, error) {
+	if len(controller.Sites) == 0 && len(controller.ExcludeSites) == 0 {
+		return sites, nil
+	}
+
+	var filtered []*unifi.Site
+	for _, site := range sites {
+		if len(controller.Sites) > 0 && !siteMatches(site, controller.Sites) {
+			logger.Debugf("Skipping site %s, not in sites", site.Name)
+			continue
+		}
+		if siteMatches(site, controller.ExcludeSites) {
+			logger.Debugf("Skipping site %s, in exclude_sites", site.Name)
+			continue
+		}
+		filtered = append(filtered, site)
+	}
+
+	if len(sites) > 0 && len(filtered) == 0 {
+		return nil, errors.New("no Unifi sites left after applying sites and exclude_sites")
+	}
+	return filtered, nil
+}
+
+// siteKey turns the SiteName that the unifi library puts on clients and
+// devices, which looks like "Description (name)", into the name of the site
+func siteKey(siteName string) string {
+	if strings.HasSuffix(siteName, ")") {
+		if i := strings.LastIndex(siteName, " ("); i >= 0 {
+			return siteName[i+2 : len(siteName)-1]
+		}
+	}
+	return siteName
+}
+
+// siteDomains returns the domains configured for a site of a controller, if
+// any
+func siteDomains(site string, controller *UnifiConfig) []string {
+	if site == "" {
+		return nil
+	}
+	for name, domains := range controller.SiteDomains {
+		if strings.EqualFold(name, site) {
+			return domains
+		}
+	}
+	return nil
+}
+
+// siteDomainList lists the domains of all sites of a controller in the order
+// of the site names, so allDomains always returns the same order
+func siteDomainList(controller *UnifiConfig) []string {
+	names := make([]string, 0, len(controller.SiteDomains))
+	for name := range controller.SiteDomains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var domains []string
+	for _, name := range names {
+		domains = append(domains, controller.SiteDomains[name]...)
+	}
+	return domains
+}
+
+// filterHostmapsBySite returns the hosts from the given sites
+func filterHostmapsBySite(hostmaps []*Hostmap, sites []string) []*Hostmap {
+	if len(sites) == 0 {
+		return hostmaps
+	}
+
+	var filtered []*Hostmap
+	for _, hm := range hostmaps {
+		for _, site := range sites {
+			if strings.EqualFold(strings.TrimSpace(site), hm.site) {
+				filtered = append(filtered, hm)
+				break
+			}
+		}
+	}
+	return filtered
+}
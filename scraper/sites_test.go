@@ -0,0 +1,129 @@
+package scraper
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/unpoller/unifi"
+	"github.com/withmandala/go-log"
+)
+
+func TestFilterSites(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	sites := []*unifi.Site{
+		{Name: "default", Desc: "Default"},
+		{Name: "x7f3k2", Desc: "Branch"},
+		{Name: "lab", Desc: "Lab"},
+	}
+
+	tests := []struct {
+		name    string
+		unifi   UnifiConfig
+		want    []string
+		wantErr bool
+	}{
+		{"no filter", UnifiConfig{}, []string{"default", "x7f3k2", "lab"}, false},
+		{"allow by name or description", UnifiConfig{Sites: []string{"default", "branch"}}, []string{"default", "x7f3k2"}, false},
+		{"deny", UnifiConfig{ExcludeSites: []string{"Lab"}}, []string{"default", "x7f3k2"}, false},
+		{"allow and deny", UnifiConfig{Sites: []string{"default", "lab"}, ExcludeSites: []string{"lab"}}, []string{"default"}, false},
+		{"nothing left", UnifiConfig{Sites: []string{"typo"}}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := filterSites(sites, &tt.unifi)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("filterSites() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var names []string
+			for _, site := range got {
+				names = append(names, site.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("filterSites() = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestSiteKey(t *testing.T) {
+	tests := map[string]string{
+		"Default (default)":         "default",
+		"Main Office (hq) (x7f3k2)": "x7f3k2",
+		"branch":                    "branch",
+		"":                          "",
+	}
+	for siteName, want := range tests {
+		if got := siteKey(siteName); got != want {
+			t.Errorf("siteKey(%q) = %q, want %q", siteName, got, want)
+		}
+	}
+}
+
+// TestSiteDomains tests that identically named clients at different sites
+// get names in the domain of their site, and that an output can be limited
+// to one site
+func TestSiteDomains(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	now := float64(time.Now().Unix())
+	mock := NewMockUnifiClient()
+	mock.AddSite("default").AddSite("branch").AddSite("lab")
+	mock.AddSiteClient("default", "printer", "192.168.1.50", now)
+	mock.AddSiteClient("branch", "printer", "10.1.0.50", now)
+	mock.AddSiteClient("lab", "printer", "10.9.0.50", now)
+
+	dir := t.TempDir()
+	cfg := &TomlConfig{
+		Unifi: UnifiControllers{{
+			ExcludeSites: []string{"lab"},
+			SiteDomains:  map[string][]string{"branch": {"branch.example.local"}},
+		}},
+		Processing: ProcessingConfig{Domains: []string{"example.local"}},
+		Output: []OutputConfig{
+			{Format: "hosts", Filename: filepath.Join(dir, "branch.hosts"), Sites: []string{"branch"}},
+		},
+	}
+
+	hostmaps, err := GenerateHostsFileWithClient(cfg, nil, mock)
+	if err != nil {
+		t.Fatalf("GenerateHostsFileWithClient() error = %v", err)
+	}
+	if len(hostmaps) != 2 {
+		t.Fatalf("Expected 2 hosts, got %d", len(hostmaps))
+	}
+
+	want := map[string]string{
+		"10.1.0.50":    "branch printer.branch.example.local",
+		"192.168.1.50": "default printer.example.local",
+	}
+	for _, hm := range hostmaps {
+		got := hm.site + " " + strings.Join(hm.fqdns, " ")
+		if got != want[hm.ip.String()] {
+			t.Errorf("Host %s got %q, want %q", hm.ip, got, want[hm.ip.String()])
+		}
+	}
+
+	outputs, err := NewOutputs(cfg, nil)
+	if err != nil {
+		t.Fatalf("NewOutputs() error = %v", err)
+	}
+	if err := SaveOutputs(outputs, hostmaps, cfg); err != nil {
+		t.Fatalf("SaveOutputs() error = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "branch.hosts"))
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if !strings.Contains(string(data), "10.1.0.50 printer.branch.example.local\n") || strings.Contains(string(data), "192.168.1.50") {
+		t.Errorf("Expected only the branch site in the output, got:\n%s", data)
+	}
+}
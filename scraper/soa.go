@@ -0,0 +1,137 @@
+package scraper
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pridkett/unifi-dns-scraper/sqlmodel"
+	"gorm.io/gorm"
+)
+
+// nameservers normalizes the configured nameservers for generated zones,
+// falling back to localhost when none are set
+func nameservers(configured []string) []string {
+	var names []string
+	for _, nameserver := range configured {
+		names = append(names, strings.ToLower(strings.TrimSuffix(nameserver, ".")))
+	}
+	if len(names) == 0 {
+		names = append(names, "localhost")
+	}
+	return names
+}
+
+// soaContent builds the content of an SOA record in the format PowerDNS
+// stores: primary nameserver, hostmaster, serial, refresh, retry, expire and
+// the negative caching TTL
+func soaContent(zone string, serial uint32, ns []string, hostmaster string) string {
+	if hostmaster == "" {
+		hostmaster = "hostmaster." + zone
+	}
+	// the hostmaster is an email address with the @ replaced by a dot
+	hostmaster = strings.Replace(strings.TrimSuffix(hostmaster, "."), "@", ".", 1)
+
+	return fmt.Sprintf("%s %s %d 10800 3600 604800 3600", nameservers(ns)[0], hostmaster, serial)
+}
+
+// soaSerialFormat validates a SerialFormat setting, which defaults to date
+func soaSerialFormat(configured string) (string, error) {
+	switch format := strings.ToLower(configured); format {
+	case "", "date":
+		return "date", nil
+	case "increment":
+		return format, nil
+	default:
+		return "", fmt.Errorf("unsupported serial_format: %s", configured)
+	}
+}
+
+// nextSerial returns the serial that follows the current one. Date based
+// serials use the YYYYMMDDnn format and fall back to incrementing when there
+// have been more than 99 changes in a day, as serials must never go backwards.
+func nextSerial(current uint32, format string, now time.Time) uint32 {
+	if format == "date" {
+		year, month, day := now.UTC().Date()
+		dated := uint32(year*1000000 + int(month)*10000 + day*100)
+		if current < dated {
+			return dated
+		}
+	}
+
+	if current == ^uint32(0) {
+		return 1
+	}
+	return current + 1
+}
+
+// zoneChanges tracks the domains whose records changed during a run of
+// SaveDatabase so that their SOA serials can be bumped at the end
+type zoneChanges struct {
+	now     time.Time
+	domains map[int64]bool
+}
+
+func newZoneChanges(now time.Time) *zoneChanges {
+	return &zoneChanges{now: now, domains: make(map[int64]bool)}
+}
+
+// touch stamps the change date on records that are about to be written and
+// marks the domains they belong to as changed
+func (z *zoneChanges) touch(records []sqlmodel.Record) {
+	for i := range records {
+		records[i].ChangDate = int(z.now.Unix())
+		if records[i].DomainId.Valid {
+			z.domains[records[i].DomainId.Int64] = true
+		}
+	}
+}
+
+// bumpSerials increments the SOA serial of every domain that had records
+// change. The previous serial is stored as the notified serial of the
+// domain, which makes sure it differs from the new serial so that PowerDNS
+// sends a NOTIFY to the secondaries of MASTER domains and then records the
+// new serial itself.
+func bumpSerials(db *gorm.DB, changes *zoneChanges, format string) error {
+	for domainId := range changes.domains {
+		var soa sqlmodel.Record
+		err := db.Where("domain_id = ? AND type = ?", domainId, "SOA").First(&soa).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		fields := strings.Fields(soa.Content)
+		if len(fields) != 7 {
+			logger.Warnf("unable to parse SOA record for domain %d: %s", domainId, soa.Content)
+			continue
+		}
+
+		current, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			logger.Warnf("unable to parse SOA serial for domain %d: %s", domainId, fields[2])
+			continue
+		}
+
+		serial := nextSerial(uint32(current), format, changes.now)
+		fields[2] = strconv.FormatUint(uint64(serial), 10)
+		soa.Content = strings.Join(fields, " ")
+		soa.ChangDate = int(changes.now.Unix())
+		if err := db.Save(&soa).Error; err != nil {
+			return err
+		}
+
+		if err := db.Model(&sqlmodel.Domain{}).Where("id = ?", domainId).
+			Update("notified_serial", sql.NullInt64{Int64: int64(current), Valid: true}).Error; err != nil {
+			return err
+		}
+
+		logger.Infof("Updated SOA serial for %s to %d", soa.Name, serial)
+	}
+
+	return nil
+}
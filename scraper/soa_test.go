@@ -0,0 +1,115 @@
+package scraper
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pridkett/unifi-dns-scraper/sqlmodel"
+	"github.com/withmandala/go-log"
+)
+
+func TestNextSerial(t *testing.T) {
+	now := time.Date(2024, time.March, 5, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		current uint32
+		format  string
+		want    uint32
+	}{
+		{"date from nothing", 0, "date", 2024030500},
+		{"date from older date", 2023123105, "date", 2024030500},
+		{"date on same day", 2024030500, "date", 2024030501},
+		{"date never goes backwards", 2024030599, "date", 2024030600},
+		{"date from small increment", 7, "date", 2024030500},
+		{"increment", 7, "increment", 8},
+		{"increment wraps", ^uint32(0), "increment", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextSerial(tt.current, tt.format, now); got != tt.want {
+				t.Errorf("nextSerial() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestSaveDatabaseSerials tests that changes bump the SOA serial and stamp the change date
+func TestSaveDatabaseSerials(t *testing.T) {
+	db, err := OpenDatabase("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open in-memory database: %v", err)
+	}
+
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	hostmaps := []*Hostmap{
+		{
+			ip:          createIP("192.168.1.100"),
+			hostnames:   []string{"test1"},
+			fqdns:       []string{"test1.local"},
+			lastseen:    cTimeNow,
+			removalCode: NotRemoved,
+		},
+	}
+
+	config := &TomlConfig{
+		Processing: ProcessingConfig{Domains: []string{"local"}},
+		Database:   DatabaseConfig{SerialFormat: "increment", DomainType: "master"},
+	}
+
+	serial := func() string {
+		var soa sqlmodel.Record
+		if err := db.Where("type = ?", "SOA").First(&soa).Error; err != nil {
+			t.Fatalf("Failed to find SOA: %v", err)
+		}
+		return strings.Fields(soa.Content)[2]
+	}
+
+	if err := SaveDatabase(db, hostmaps, config); err != nil {
+		t.Fatalf("SaveDatabase() error = %v", err)
+	}
+	if got := serial(); got != "2" {
+		t.Errorf("Expected serial 2 after first run, got %s", got)
+	}
+
+	var record sqlmodel.Record
+	if err := db.Where("type = ?", "A").First(&record).Error; err != nil {
+		t.Fatalf("Failed to find record: %v", err)
+	}
+	if record.ChangDate == 0 {
+		t.Errorf("Expected change date to be set on new record")
+	}
+
+	// nothing changed, so the serial must not move
+	if err := SaveDatabase(db, hostmaps, config); err != nil {
+		t.Fatalf("SaveDatabase() error = %v", err)
+	}
+	if got := serial(); got != "2" {
+		t.Errorf("Expected serial to stay at 2 without changes, got %s", got)
+	}
+
+	hostmaps[0].ip = createIP("192.168.1.101")
+	if err := SaveDatabase(db, hostmaps, config); err != nil {
+		t.Fatalf("SaveDatabase() error = %v", err)
+	}
+	if got := serial(); got != "3" {
+		t.Errorf("Expected serial 3 after a change, got %s", got)
+	}
+
+	var domain sqlmodel.Domain
+	if err := db.Where("name = ?", "local").First(&domain).Error; err != nil {
+		t.Fatalf("Failed to find domain: %v", err)
+	}
+	if domain.Type != "MASTER" {
+		t.Errorf("Expected domain type MASTER, got %s", domain.Type)
+	}
+	if !domain.NotifiedSerial.Valid || domain.NotifiedSerial.Int64 != 2 {
+		t.Errorf("Expected notified serial 2, got %v", domain.NotifiedSerial)
+	}
+}
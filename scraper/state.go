@@ -0,0 +1,223 @@
+package scraper
+
+import (
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pridkett/unifi-dns-scraper/sqlmodel"
+	"gorm.io/gorm"
+)
+
+// stateName is the name of the row in the scraper_states table that holds
+// the hostmap
+const stateName = "hostmap"
+
+// hostmapState is the serialized form of a Hostmap
+type hostmapState struct {
+	IP             netip.Addr   `json:"ip"`
+	IPv6           []netip.Addr `json:"ipv6,omitempty"`
+	Hostnames      []string     `json:"hostnames"`
+	Fqdns          []string     `json:"fqdns"`
+	LastSeen       time.Time    `json:"lastseen"`
+	LastSeenUnifi  time.Time    `json:"lastseen_unifi"`
+	FirstSeenUnifi time.Time    `json:"firstseen_unifi"`
+	RemovalCode    RemovalCode  `json:"removal_code"`
+	Source         HostSource   `json:"source,omitempty"`
+	Mac            string       `json:"mac,omitempty"`
+	Site           string       `json:"site,omitempty"`
+	UnifiHostname  string       `json:"unifi_hostname,omitempty"`
+	Wired          bool         `json:"wired,omitempty"`
+	Network        string       `json:"network,omitempty"`
+	VLAN           int          `json:"vlan,omitempty"`
+	SSID           string       `json:"ssid,omitempty"`
+	Controller     string       `json:"controller,omitempty"`
+	Interface      string       `json:"interface,omitempty"`
+}
+
+// MarshalJSON allows a Hostmap to be saved between runs of the scraper
+func (h *Hostmap) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hostmapState{
+		IP:             h.ip,
+		IPv6:           h.ipv6,
+		Hostnames:      h.hostnames,
+		Fqdns:          h.fqdns,
+		LastSeen:       h.lastseen,
+		LastSeenUnifi:  h.lastseenUnifi,
+		FirstSeenUnifi: h.firstseenUnifi,
+		RemovalCode:    h.removalCode,
+		Source:         h.source,
+		Mac:            h.mac,
+		Site:           h.site,
+		UnifiHostname:  h.unifiHostname,
+		Wired:          h.wired,
+		Network:        h.network,
+		VLAN:           h.vlan,
+		SSID:           h.ssid,
+		Controller:     h.controller,
+		Interface:      h.iface,
+	})
+}
+
+// UnmarshalJSON restores a Hostmap saved by MarshalJSON
+func (h *Hostmap) UnmarshalJSON(data []byte) error {
+	var state hostmapState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+
+	*h = Hostmap{
+		ip:             state.IP,
+		ipv6:           state.IPv6,
+		hostnames:      state.Hostnames,
+		fqdns:          state.Fqdns,
+		lastseen:       state.LastSeen,
+		lastseenUnifi:  state.LastSeenUnifi,
+		firstseenUnifi: state.FirstSeenUnifi,
+		removalCode:    state.RemovalCode,
+		source:         state.Source,
+		mac:            state.Mac,
+		site:           state.Site,
+		unifiHostname:  state.UnifiHostname,
+		wired:          state.Wired,
+		network:        state.Network,
+		vlan:           state.VLAN,
+		ssid:           state.SSID,
+		controller:     state.Controller,
+		iface:          state.Interface,
+	}
+	return nil
+}
+
+// SaveState saves the hostmap so that it survives a restart of the scraper.
+// Without this, stale hosts are forgotten and MaxAge starts over whenever
+// the scraper restarts.
+func SaveState(hostmaps []*Hostmap, cfg *TomlConfig, db *gorm.DB) error {
+	if cfg.State.Filename == "" && !cfg.State.Database {
+		return nil
+	}
+
+	data, err := json.Marshal(hostmaps)
+	if err != nil {
+		return err
+	}
+	if err := writeState(cfg, db, stateName, data); err != nil {
+		return err
+	}
+
+	if cfg.State.Database {
+		logger.Infof("Saved %d hosts to the database state", len(hostmaps))
+	}
+	if cfg.State.Filename != "" {
+		logger.Infof("Saved %d hosts to %s", len(hostmaps), cfg.State.Filename)
+	}
+	return nil
+}
+
+// LoadState loads the hostmap saved by SaveState. If the database and a file
+// are both configured, the database is preferred. A missing state is not an
+// error, it just returns no hosts.
+func LoadState(cfg *TomlConfig, db *gorm.DB) ([]*Hostmap, error) {
+	data, err := readState(cfg, db, stateName)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		logger.Infof("No saved state found")
+		return []*Hostmap{}, nil
+	}
+
+	var hostmaps []*Hostmap
+	if err := json.Unmarshal(data, &hostmaps); err != nil {
+		return nil, err
+	}
+
+	logger.Infof("Loaded %d hosts from saved state", len(hostmaps))
+	return hostmaps, nil
+}
+
+// stateFilename returns the file that holds a part of the state. The hostmap
+// is kept in the state file itself and the other parts next to it.
+func stateFilename(cfg *TomlConfig, name string) string {
+	if name == stateName {
+		return cfg.State.Filename
+	}
+	return cfg.State.Filename + "." + name
+}
+
+// writeState saves one part of the state to the database and the file, if
+// they are configured
+func writeState(cfg *TomlConfig, db *gorm.DB, name string, data []byte) error {
+	if cfg.State.Database {
+		if db == nil {
+			return errors.New("state is configured to use the database but no database is configured")
+		}
+		state := sqlmodel.ScraperState{Name: name}
+		if err := db.Where(state).Assign(sqlmodel.ScraperState{Data: string(data)}).FirstOrCreate(&state).Error; err != nil {
+			return err
+		}
+	}
+
+	if cfg.State.Filename != "" {
+		if err := writeFileAtomic(stateFilename(cfg, name), data, 0600); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readState loads one part of the state saved by writeState, preferring the
+// database. It returns no data if nothing was saved yet.
+func readState(cfg *TomlConfig, db *gorm.DB, name string) ([]byte, error) {
+	if cfg.State.Database {
+		if db == nil {
+			return nil, errors.New("state is configured to use the database but no database is configured")
+		}
+		var state sqlmodel.ScraperState
+		err := db.Where(sqlmodel.ScraperState{Name: name}).First(&state).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		if state.Data != "" {
+			return []byte(state.Data), nil
+		}
+	}
+
+	if cfg.State.Filename != "" {
+		data, err := os.ReadFile(stateFilename(cfg, name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		return data, nil
+	}
+
+	return nil, nil
+}
+
+// writeFileAtomic writes to a temporary file and renames it, so a crash never
+// leaves a truncated file behind
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
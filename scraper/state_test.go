@@ -0,0 +1,97 @@
+package scraper
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/withmandala/go-log"
+)
+
+func TestSaveAndLoadState(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	lastseen := time.Now().Add(-time.Hour).Round(0)
+	hostmaps := []*Hostmap{
+		{
+			ip:            createIP("192.168.1.100"),
+			ipv6:          []netip.Addr{createIP("2001:db8::100")},
+			hostnames:     []string{"laptop"},
+			fqdns:         []string{"laptop.local"},
+			lastseen:      lastseen,
+			lastseenUnifi: lastseen.Add(-time.Minute),
+			removalCode:   Old,
+			source:        SourceClient,
+			mac:           "aa:bb:cc:00:00:01",
+			site:          "Default",
+			wired:         true,
+			network:       "Default",
+			vlan:          20,
+		},
+		{
+			ip:        createIP("192.168.1.1"),
+			hostnames: []string{"router"},
+			fqdns:     []string{"router.local"},
+			source:    SourceStatic,
+		},
+	}
+
+	db, err := OpenDatabase("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open in-memory database: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		cfg  *TomlConfig
+	}{
+		{"file", &TomlConfig{State: StateConfig{Filename: filepath.Join(t.TempDir(), "state.json")}}},
+		{"database", &TomlConfig{State: StateConfig{Database: true}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loaded, err := LoadState(tt.cfg, db)
+			if err != nil {
+				t.Fatalf("LoadState() without saved state error = %v", err)
+			}
+			if len(loaded) != 0 {
+				t.Errorf("Expected no hosts without saved state, got %d", len(loaded))
+			}
+
+			// saving twice must replace the state rather than add to it
+			if err := SaveState(hostmaps[:1], tt.cfg, db); err != nil {
+				t.Fatalf("SaveState() error = %v", err)
+			}
+			if err := SaveState(hostmaps, tt.cfg, db); err != nil {
+				t.Fatalf("SaveState() error = %v", err)
+			}
+
+			loaded, err = LoadState(tt.cfg, db)
+			if err != nil {
+				t.Fatalf("LoadState() error = %v", err)
+			}
+			if len(loaded) != 2 {
+				t.Fatalf("Expected 2 hosts, got %d", len(loaded))
+			}
+
+			got := loaded[0]
+			want := hostmaps[0]
+			if got.ip != want.ip || len(got.ipv6) != 1 || got.ipv6[0] != want.ipv6[0] ||
+				got.hostnames[0] != want.hostnames[0] || got.fqdns[0] != want.fqdns[0] ||
+				got.removalCode != want.removalCode || got.source != want.source ||
+				got.mac != want.mac || got.site != want.site || got.wired != want.wired ||
+				got.network != want.network || got.vlan != want.vlan {
+				t.Errorf("Loaded host %+v does not match saved host %+v", got, want)
+			}
+			if !got.lastseen.Equal(want.lastseen) || !got.lastseenUnifi.Equal(want.lastseenUnifi) {
+				t.Errorf("Loaded host times %v/%v do not match saved %v/%v",
+					got.lastseen, got.lastseenUnifi, want.lastseen, want.lastseenUnifi)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package scraper
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"os"
+	"strings"
+
+	"github.com/unpoller/unifi"
+)
+
+// controllerTLSConfig builds the TLS settings for connecting to a controller.
+// CAFile adds a private CA, Fingerprint pins the certificate, which is
+// enough on its own for a self-signed certificate, Insecure = false only
+// trusts the CAs of the system and Insecure = true turns verification off
+// altogether. Without any of them the certificate isn't verified, as older
+// versions never did, and a warning asks to pick one.
+func controllerTLSConfig(controller *UnifiConfig) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if controller.CAFile != "" {
+		pem, err := os.ReadFile(controller.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading ca_file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ca_file %s", controller.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if controller.Fingerprint != "" {
+		want, err := parseFingerprint(controller.Fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		// a pinned certificate doesn't need a CA, unless one was given
+		config.InsecureSkipVerify = controller.CAFile == ""
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("controller sent no certificate")
+			}
+			got := sha256.Sum256(cs.PeerCertificates[0].Raw)
+			if !bytes.Equal(got[:], want) {
+				return fmt.Errorf("certificate fingerprint %x does not match the pinned fingerprint", got)
+			}
+			return nil
+		}
+	}
+
+	switch {
+	case controller.Insecure != nil && *controller.Insecure:
+		logger.Warnf("!!! TLS certificate verification is OFF for Unifi controller %s - anyone on the network can read its credentials !!!", controllerName(controller))
+		config.InsecureSkipVerify = true
+	case controller.Insecure == nil && controller.CAFile == "" && controller.Fingerprint == "":
+		logger.Warnf("!!! DEPRECATED: the TLS certificate of Unifi controller %s is not verified - set fingerprint or ca_file for the controller, insecure = false to use the CAs of the system, or insecure = true to keep it unverified. A future version will verify it by default !!!", controllerName(controller))
+		config.InsecureSkipVerify = true
+	}
+
+	if controller.ClientCert != "" || controller.ClientKey != "" {
+		if controller.ClientCert == "" || controller.ClientKey == "" {
+			return nil, errors.New("client_cert and client_key have to be given together")
+		}
+		cert, err := tls.LoadX509KeyPair(controller.ClientCert, controller.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// parseFingerprint reads a SHA-256 fingerprint in hex, with or without the
+// colons that openssl puts between the bytes
+func parseFingerprint(fingerprint string) ([]byte, error) {
+	cleaned := strings.NewReplacer(":", "", " ", "").Replace(fingerprint)
+	if strings.HasPrefix(strings.ToLower(cleaned), "sha256") {
+		cleaned = strings.TrimLeft(cleaned[len("sha256"):], "=")
+	}
+	decoded, err := hex.DecodeString(cleaned)
+	if err != nil || len(decoded) != sha256.Size {
+		return nil, fmt.Errorf("fingerprint %q is not a SHA-256 fingerprint", fingerprint)
+	}
+	return decoded, nil
+}
+
+// tlsHint adds a pointer to the TLS settings to certificate errors, which is
+// what most people hit with the self-signed certificate of a console
+func tlsHint(err error) error {
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalid x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	if errors.As(err, &unknownAuthority) || errors.As(err, &invalid) || errors.As(err, &hostname) {
+		return fmt.Errorf("%w (set ca_file, fingerprint or insecure for the controller)", err)
+	}
+	return err
+}
+
+// newControllerHTTPClient creates the HTTP client for talking to a
+// controller, with its TLS settings and a cookie jar for the session
+func newControllerHTTPClient(controller *UnifiConfig) (*http.Client, error) {
+	tlsConfig, err := controllerTLSConfig(controller)
+	if err != nil {
+		return nil, err
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Timeout:   apiTimeout,
+		Jar:       jar,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}, nil
+}
+
+// isUniFiOS checks if the controller runs on a UniFi OS console. Consoles
+// answer / themselves, older controllers redirect to their login page.
+func isUniFiOS(client *http.Client, host string) (bool, error) {
+	noRedirect := *client
+	noRedirect.Jar = nil
+	noRedirect.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	resp, err := noRedirect.Get(host + "/")
+	if err != nil {
+		return false, tlsHint(err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	return resp.StatusCode == http.StatusOK, nil
+}
+
+// loginController logs into a controller at the login path for its kind. The
+// session cookie ends up in the cookie jar of the client. Unlike the login of
+// the unifi library this escapes the user and password.
+func loginController(client *http.Client, controller *UnifiConfig, loginPath string) error {
+	body, err := json.Marshal(map[string]string{
+		"username": controller.User,
+		"password": controller.Password,
+	})
+	if err != nil {
+		return err
+	}
+
+	host := strings.TrimRight(controller.Host, "/")
+	resp, err := client.Post(host+loginPath, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return tlsHint(err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("(user: %s): %s: %w", controller.User, resp.Status, unifi.ErrAuthenticationFailed)
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package scraper
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/withmandala/go-log"
+)
+
+// writeServerCA writes the certificate of a TLS test server to a file, to be
+// used as ca_file
+func writeServerCA(t *testing.T, server *httptest.Server) string {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeClientCert creates a self-signed client certificate and returns the
+// files with the certificate and the key
+func writeClientCert(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// newFakeController starts a fake Unifi controller over TLS. A UniFi OS
+// console answers / and serves the Network application below /proxy/network,
+// an older controller redirects / to its login page.
+func newFakeController(t *testing.T, unifiOS bool, requireClientCert bool) *httptest.Server {
+	prefix, loginPath := "", "/api/login"
+	if unifiOS {
+		prefix, loginPath = "/proxy/network", "/api/auth/login"
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		if !unifiOS {
+			http.Redirect(w, r, "/manage", http.StatusFound)
+		}
+	})
+	mux.HandleFunc(loginPath, func(w http.ResponseWriter, r *http.Request) {
+		var login struct{ Username, Password string }
+		if err := json.NewDecoder(r.Body).Decode(&login); err != nil || login.Username != "scraper" || login.Password != `pa"ss` {
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "TOKEN", Value: "session", Path: "/"})
+	})
+	mux.HandleFunc(prefix+"/api/stat/sites", func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie("TOKEN"); err != nil || cookie.Value != "session" {
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"meta":{"rc":"ok"},"data":[{"name":"default","desc":"Default"}]}`)
+	})
+
+	server := httptest.NewUnstartedServer(mux)
+	if requireClientCert {
+		server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	}
+	server.StartTLS()
+	return server
+}
+
+func TestControllerTLS(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	clientCert, clientKey := writeClientCert(t)
+
+	tests := []struct {
+		name              string
+		classic           bool
+		requireClientCert bool
+		configure         func(c *UnifiConfig, server *httptest.Server)
+		errMsg            string
+	}{
+		{
+			name:      "certificate is not verified by default",
+			configure: func(c *UnifiConfig, server *httptest.Server) {},
+		},
+		{
+			name: "self-signed certificate is rejected when verifying",
+			configure: func(c *UnifiConfig, server *httptest.Server) {
+				insecure := false
+				c.Insecure = &insecure
+			},
+			errMsg: "set ca_file, fingerprint or insecure",
+		},
+		{
+			name: "ca file",
+			configure: func(c *UnifiConfig, server *httptest.Server) {
+				c.CAFile = writeServerCA(t, server)
+			},
+		},
+		{
+			name: "pinned fingerprint",
+			configure: func(c *UnifiConfig, server *httptest.Server) {
+				sum := sha256.Sum256(server.Certificate().Raw)
+				var parts []string
+				for _, b := range sum {
+					parts = append(parts, fmt.Sprintf("%02X", b))
+				}
+				c.Fingerprint = strings.Join(parts, ":")
+			},
+		},
+		{
+			name: "wrong fingerprint",
+			configure: func(c *UnifiConfig, server *httptest.Server) {
+				c.Fingerprint = strings.Repeat("ab", sha256.Size)
+			},
+			errMsg: "does not match the pinned fingerprint",
+		},
+		{
+			name: "insecure",
+			configure: func(c *UnifiConfig, server *httptest.Server) {
+				insecure := true
+				c.Insecure = &insecure
+			},
+		},
+		{
+			name:    "classic controller",
+			classic: true,
+			configure: func(c *UnifiConfig, server *httptest.Server) {
+				c.CAFile = writeServerCA(t, server)
+			},
+		},
+		{
+			name:              "client certificate",
+			requireClientCert: true,
+			configure: func(c *UnifiConfig, server *httptest.Server) {
+				c.CAFile = writeServerCA(t, server)
+				c.ClientCert, c.ClientKey = clientCert, clientKey
+			},
+		},
+		{
+			name:              "missing client certificate",
+			requireClientCert: true,
+			configure: func(c *UnifiConfig, server *httptest.Server) {
+				c.CAFile = writeServerCA(t, server)
+			},
+			errMsg: "certificate",
+		},
+		{
+			name: "wrong password",
+			configure: func(c *UnifiConfig, server *httptest.Server) {
+				c.CAFile = writeServerCA(t, server)
+				c.Password = "wrong"
+			},
+			errMsg: "authentication failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeController(t, !tt.classic, tt.requireClientCert)
+			defer server.Close()
+
+			controller := &UnifiConfig{Host: server.URL, User: "scraper", Password: `pa"ss`}
+			tt.configure(controller, server)
+
+			client, err := connectUnifi(controller)
+			if tt.errMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+					t.Fatalf("connectUnifi() error = %v, want an error containing %q", err, tt.errMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("connectUnifi() error = %v", err)
+			}
+
+			sites, err := client.GetSites()
+			if err != nil {
+				t.Fatalf("GetSites() error = %v", err)
+			}
+			if len(sites) != 1 || sites[0].Name != "default" {
+				t.Errorf("GetSites() = %v, want the default site", sites)
+			}
+		})
+	}
+}
+
+func TestParseFingerprint(t *testing.T) {
+	hex := strings.Repeat("0a", sha256.Size)
+	for _, input := range []string{hex, strings.ToUpper(hex), "sha256=" + hex, strings.Repeat("0A:", sha256.Size-1) + "0A"} {
+		if _, err := parseFingerprint(input); err != nil {
+			t.Errorf("parseFingerprint(%q) error = %v", input, err)
+		}
+	}
+	for _, input := range []string{"", "0a0b", "not hex"} {
+		if _, err := parseFingerprint(input); err == nil {
+			t.Errorf("parseFingerprint(%q) expected an error", input)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package scraper
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// SaveZoneFiles writes an RFC 1035 zone file for each of the configured
+// domains, and optionally their reverse zones, that BIND, Knot or NSD can
+// load. The SOA serial is taken from the existing file and only bumped when
+// the records in it change.
+func SaveZoneFiles(hostmaps []*Hostmap, cfg *TomlConfig) error {
+	serialFormat, err := soaSerialFormat(cfg.Zonefile.SerialFormat)
+	if err != nil {
+		return err
+	}
+
+	ttl := cfg.Zonefile.TTL
+	if ttl == 0 {
+		ttl = defaultDNSTTL
+	}
+
+	zones := recordZones(hostmaps, cfg, cfg.Zonefile.ReverseZones)
+	records := make(map[string][]dnsRecord)
+	for _, record := range collectRecords(hostmaps, cfg, cfg.Zonefile.ReverseZones) {
+		if zone := zoneFor(record.Name, zones); zone != "" {
+			records[zone] = append(records[zone], record)
+		}
+	}
+
+	now := time.Now()
+	for _, zone := range zones {
+		filename := filepath.Join(cfg.Zonefile.Directory, zone+".zone")
+		existing, err := os.ReadFile(filename)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		// render the zone with the old serial first, if nothing else changed
+		// the file is left alone
+		serial := zoneFileSerial(existing, filename)
+		if serial != 0 && bytes.Equal(existing, renderZone(zone, records[zone], serial, ttl, cfg)) {
+			logger.Debugf("Zone file %s is unchanged", filename)
+			continue
+		}
+
+		serial = nextSerial(serial, serialFormat, now)
+		if err := writeFileAtomic(filename, renderZone(zone, records[zone], serial, ttl, cfg), 0644); err != nil {
+			return err
+		}
+		logger.Infof("Wrote %d records to %s with serial %d", len(records[zone]), filename, serial)
+	}
+
+	return nil
+}
+
+// zoneFileSerial returns the SOA serial of an existing zone file, or zero if
+// there is no file or it has no SOA record
+func zoneFileSerial(data []byte, filename string) uint32 {
+	if len(data) == 0 {
+		return 0
+	}
+	zp := dns.NewZoneParser(bytes.NewReader(data), "", filename)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		if soa, isSOA := rr.(*dns.SOA); isSOA {
+			return soa.Serial
+		}
+	}
+	if err := zp.Err(); err != nil {
+		logger.Warnf("Unable to read the serial from %s: %s", filename, err)
+	}
+	return 0
+}
+
+// renderZone builds the contents of a zone file. Names inside the zone are
+// written relative to $ORIGIN and the targets of CNAME and PTR records are
+// always fully qualified.
+func renderZone(zone string, records []dnsRecord, serial uint32, ttl uint32, cfg *TomlConfig) []byte {
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "; %s zone generated by unifi-dns-scraper, do not edit\n", zone)
+	fmt.Fprintf(&b, "$ORIGIN %s.\n", zone)
+	fmt.Fprintf(&b, "$TTL %d\n", ttl)
+
+	// soaContent leaves the names relative, which would get $ORIGIN appended
+	fields := strings.Fields(soaContent(zone, serial, cfg.Zonefile.Nameservers, cfg.Zonefile.Hostmaster))
+	fmt.Fprintf(&b, "@\tIN\tSOA\t%s. %s. %s\n", fields[0], fields[1], strings.Join(fields[2:], " "))
+	for _, nameserver := range nameservers(cfg.Zonefile.Nameservers) {
+		fmt.Fprintf(&b, "@\tIN\tNS\t%s.\n", nameserver)
+	}
+
+	for _, record := range records {
+		name := "@"
+		if record.Name != zone {
+			name = strings.TrimSuffix(record.Name, "."+zone)
+		}
+		content := record.Content
+		if record.Type == "CNAME" || record.Type == "PTR" {
+			content += "."
+		}
+		fmt.Fprintf(&b, "%s\tIN\t%s\t%s\n", name, record.Type, content)
+	}
+
+	return b.Bytes()
+}
@@ -0,0 +1,158 @@
+package scraper
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/withmandala/go-log"
+)
+
+// readZone parses a zone file and returns its records by type
+func readZone(t *testing.T, filename string) map[uint16][]dns.RR {
+	t.Helper()
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf("Failed to open zone file: %v", err)
+	}
+	defer f.Close()
+
+	rrs := make(map[uint16][]dns.RR)
+	zp := dns.NewZoneParser(f, "", filename)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		rrs[rr.Header().Rrtype] = append(rrs[rr.Header().Rrtype], rr)
+	}
+	if err := zp.Err(); err != nil {
+		t.Fatalf("Failed to parse zone file: %v", err)
+	}
+	return rrs
+}
+
+// TestSaveZoneFiles tests writing forward and reverse zone files
+func TestSaveZoneFiles(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	dir := t.TempDir()
+	cfg := &TomlConfig{
+		Processing: ProcessingConfig{
+			Domains:     []string{"example.com"},
+			IPv6Domains: []string{"example.com"},
+			Cnames: []struct {
+				Cname    string
+				Hostname string
+			}{
+				{Cname: "www.example.com", Hostname: "host1.example.com"},
+			},
+		},
+		Zonefile: ZonefileConfig{
+			Directory:    dir,
+			ReverseZones: true,
+			Nameservers:  []string{"ns1.example.com"},
+			Hostmaster:   "admin@example.com",
+			SerialFormat: "increment",
+		},
+	}
+
+	hm := &Hostmap{
+		ip:        createIP("192.168.1.10"),
+		ipv6:      []netip.Addr{createIP("2001:db8::10")},
+		hostnames: []string{"host1"},
+	}
+	addDomainsToHostmap(hm, cfg.Processing.Domains)
+
+	if err := SaveZoneFiles([]*Hostmap{hm}, cfg); err != nil {
+		t.Fatalf("SaveZoneFiles failed: %v", err)
+	}
+
+	forward := filepath.Join(dir, "example.com.zone")
+	rrs := readZone(t, forward)
+	soa := rrs[dns.TypeSOA][0].(*dns.SOA)
+	if soa.Hdr.Name != "example.com." || soa.Ns != "ns1.example.com." || soa.Mbox != "admin.example.com." || soa.Serial != 1 {
+		t.Errorf("Unexpected SOA record: %s", soa)
+	}
+	if len(rrs[dns.TypeNS]) != 1 || rrs[dns.TypeNS][0].(*dns.NS).Ns != "ns1.example.com." {
+		t.Errorf("Unexpected NS records: %v", rrs[dns.TypeNS])
+	}
+	if len(rrs[dns.TypeA]) != 1 || rrs[dns.TypeA][0].Header().Name != "host1.example.com." {
+		t.Errorf("Unexpected A records: %v", rrs[dns.TypeA])
+	}
+	if len(rrs[dns.TypeAAAA]) != 1 || rrs[dns.TypeAAAA][0].(*dns.AAAA).AAAA.String() != "2001:db8::10" {
+		t.Errorf("Unexpected AAAA records: %v", rrs[dns.TypeAAAA])
+	}
+	if len(rrs[dns.TypeCNAME]) != 1 || rrs[dns.TypeCNAME][0].(*dns.CNAME).Target != "host1.example.com." {
+		t.Errorf("Unexpected CNAME records: %v", rrs[dns.TypeCNAME])
+	}
+
+	data, _ := os.ReadFile(forward)
+	if !strings.Contains(string(data), "$ORIGIN example.com.\n") {
+		t.Errorf("Expected $ORIGIN in zone file, got:\n%s", data)
+	}
+
+	reverse := readZone(t, filepath.Join(dir, "1.168.192.in-addr.arpa.zone"))
+	if len(reverse[dns.TypePTR]) != 1 || reverse[dns.TypePTR][0].(*dns.PTR).Ptr != "host1.example.com." {
+		t.Errorf("Unexpected PTR records: %v", reverse[dns.TypePTR])
+	}
+	if _, err := os.Stat(filepath.Join(dir, reverseZone(createIP("2001:db8::10"))+".zone")); err != nil {
+		t.Errorf("Expected IPv6 reverse zone file: %v", err)
+	}
+
+	// writing the same records again keeps the serial
+	if err := SaveZoneFiles([]*Hostmap{hm}, cfg); err != nil {
+		t.Fatalf("SaveZoneFiles failed: %v", err)
+	}
+	if got := readZone(t, forward)[dns.TypeSOA][0].(*dns.SOA).Serial; got != 1 {
+		t.Errorf("Expected serial to stay at 1, got %d", got)
+	}
+
+	// a changed address bumps it
+	hm.ip = createIP("192.168.1.20")
+	if err := SaveZoneFiles([]*Hostmap{hm}, cfg); err != nil {
+		t.Fatalf("SaveZoneFiles failed: %v", err)
+	}
+	rrs = readZone(t, forward)
+	if got := rrs[dns.TypeSOA][0].(*dns.SOA).Serial; got != 2 {
+		t.Errorf("Expected serial 2 after a change, got %d", got)
+	}
+	if got := rrs[dns.TypeA][0].(*dns.A).A.String(); got != "192.168.1.20" {
+		t.Errorf("Expected updated A record, got %s", got)
+	}
+}
+
+// TestSaveZoneFilesIPv6Host tests that an additional host with an IPv6
+// address gets an AAAA record, as an A record would break the whole zone
+func TestSaveZoneFilesIPv6Host(t *testing.T) {
+	if logger == nil {
+		logger = log.New(os.Stderr)
+	}
+
+	dir := t.TempDir()
+	cfg := &TomlConfig{
+		Processing: ProcessingConfig{Domains: []string{"example.com"}},
+		Zonefile:   ZonefileConfig{Directory: dir, ReverseZones: true},
+	}
+
+	hm := &Hostmap{ip: createIP("fd00::53"), hostnames: []string{"nas"}, source: SourceStatic}
+	addDomainsToHostmap(hm, cfg.Processing.Domains)
+
+	if err := SaveZoneFiles([]*Hostmap{hm}, cfg); err != nil {
+		t.Fatalf("SaveZoneFiles failed: %v", err)
+	}
+
+	rrs := readZone(t, filepath.Join(dir, "example.com.zone"))
+	if len(rrs[dns.TypeA]) != 0 {
+		t.Errorf("Expected no A records, got %v", rrs[dns.TypeA])
+	}
+	if len(rrs[dns.TypeAAAA]) != 1 || rrs[dns.TypeAAAA][0].(*dns.AAAA).AAAA.String() != "fd00::53" {
+		t.Errorf("Expected an AAAA record for fd00::53, got %v", rrs[dns.TypeAAAA])
+	}
+
+	reverse := filepath.Join(dir, reverseZone(hm.ip)+".zone")
+	if ptrs := readZone(t, reverse)[dns.TypePTR]; len(ptrs) != 1 || ptrs[0].(*dns.PTR).Ptr != "nas.example.com." {
+		t.Errorf("Expected a PTR record for nas.example.com, got %v", ptrs)
+	}
+}
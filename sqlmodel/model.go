@@ -2,7 +2,6 @@ package sqlmodel
 
 import (
 	"database/sql"
-	"net"
 	"time"
 )
 
@@ -26,12 +25,26 @@ type Record struct {
 	Prio      int
 	ChangDate int
 	Disabled  bool `gorm:"type:boolean"`
+	// Managed is set on records created by the scraper, which are the only
+	// records it will ever disable or delete
+	Managed bool `gorm:"type:boolean;default:false"`
 }
 
 type UnifiHost struct {
-	ID        uint      `gorm:"primary_key"`
-	Name      string    `gorm:"type:varchar(255);not null"`
-	IP        net.IP    `gorm:"type:varchar(15);not null"`
-	LastSeen  time.Time `gorm:"type:timestamp"`
-	FirstSeen time.Time `gorm:"type:timestamp"`
+	ID         uint      `gorm:"primary_key"`
+	Mac        string    `gorm:"type:varchar(17);uniqueIndex"`
+	Name       string    `gorm:"type:varchar(255);not null"`
+	Hostname   string    `gorm:"type:varchar(255)"`
+	IP         string    `gorm:"type:varchar(45);not null"`
+	Site       string    `gorm:"type:varchar(255)"`
+	DeviceType string    `gorm:"type:varchar(32)"`
+	LastSeen   time.Time `gorm:"type:timestamp"`
+	FirstSeen  time.Time `gorm:"type:timestamp"`
+}
+
+type ScraperState struct {
+	ID        uint   `gorm:"primary_key"`
+	Name      string `gorm:"type:varchar(64);uniqueIndex"`
+	Data      string `gorm:"type:mediumtext"`
+	UpdatedAt time.Time
 }
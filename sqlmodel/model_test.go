@@ -2,7 +2,6 @@ package sqlmodel
 
 import (
 	"database/sql"
-	"net"
 	"testing"
 	"time"
 )
@@ -75,7 +74,8 @@ func TestUnifiHostModel(t *testing.T) {
 	host := UnifiHost{
 		ID:        1,
 		Name:      "device1",
-		IP:        net.ParseIP("192.168.1.100"),
+		Mac:       "aa:bb:cc:dd:ee:ff",
+		IP:        "192.168.1.100",
 		LastSeen:  now,
 		FirstSeen: now.Add(-24 * time.Hour),
 	}
@@ -87,8 +87,11 @@ func TestUnifiHostModel(t *testing.T) {
 	if host.Name != "device1" {
 		t.Errorf("UnifiHost.Name = %s; want device1", host.Name)
 	}
-	if host.IP.String() != "192.168.1.100" {
-		t.Errorf("UnifiHost.IP = %s; want 192.168.1.100", host.IP.String())
+	if host.IP != "192.168.1.100" {
+		t.Errorf("UnifiHost.IP = %s; want 192.168.1.100", host.IP)
+	}
+	if host.Mac != "aa:bb:cc:dd:ee:ff" {
+		t.Errorf("UnifiHost.Mac = %s; want aa:bb:cc:dd:ee:ff", host.Mac)
 	}
 
 	// Check that LastSeen is within a second of now